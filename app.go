@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/config"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/exporter"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/mqtt"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/notify"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	lastReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("bt", "homehub", "config_last_reload_successful"),
+		Help: "Whether the last configuration reload attempt was successful",
+	})
+	lastReloadSuccessTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("bt", "homehub", "config_last_reload_success_timestamp_seconds"),
+		Help: "Unix timestamp of the last successful configuration reload",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(lastReloadSuccessful, lastReloadSuccessTimestamp)
+}
+
+// app holds the running exporter, which is replaced whenever the configuration is reloaded
+type app struct {
+	configFile string
+	flags      *config.Config
+	flagsSet   map[string]bool
+	// clientOptions are applied to every Home Hub client
+	clientOptions []client.Option
+
+	// reloadMutex serialises reloads, so that only one new client logs in to the Home Hub at a time
+	reloadMutex sync.Mutex
+
+	mutex     sync.RWMutex
+	config    *config.Config
+	exporter  *exporter.Exporter
+	publisher *mqtt.Publisher
+	cancel    context.CancelFunc
+	targets   map[string]*probeTarget
+	// notifier is kept across reloads that leave the notification configuration unchanged, so it is stopped by
+	// stopNotifier rather than by cancel
+	notifier     *notify.Notifier
+	stopNotifier context.CancelFunc
+}
+
+// loadConfig reads the configuration file, if any, and applies the command line flags to it. Flags that
+// were set explicitly take precedence over the file, otherwise the flag defaults fill in missing settings
+func (a *app) loadConfig() (*config.Config, error) {
+	cfg := &config.Config{}
+	if a.configFile != "" {
+		var err error
+		cfg, err = config.Load(a.configFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration file %s: %s", a.configFile, err)
+		}
+	}
+
+	overrideString(&cfg.Web.ListenAddress, a.flags.Web.ListenAddress, a.flagsSet["listen-address"])
+	overrideString(&cfg.Web.MetricsPath, a.flags.Web.MetricsPath, a.flagsSet["metrics-path"])
+	// When only targets are configured, the default Home Hub address is not used
+	if len(cfg.Targets) == 0 || a.flagsSet["hub-address"] {
+		overrideString(&cfg.Hub.Address, a.flags.Hub.Address, a.flagsSet["hub-address"])
+	}
+	overrideString(&cfg.Hub.Scheme, a.flags.Hub.Scheme, a.flagsSet["hub-scheme"])
+	overrideString(&cfg.Hub.Username, a.flags.Hub.Username, a.flagsSet["hub-username"])
+	overrideString(&cfg.Hub.CAFile, a.flags.Hub.CAFile, a.flagsSet["hub-ca-file"])
+	overrideString(&cfg.Hub.ProxyURL, a.flags.Hub.ProxyURL, a.flagsSet["hub-proxy-url"])
+	overrideString(&cfg.Bandwidth.StateFile, a.flags.Bandwidth.StateFile, a.flagsSet["bandwidth-state-file"])
+	overrideString(&cfg.Presence.StateFile, a.flags.Presence.StateFile, a.flagsSet["presence-state-file"])
+	overrideDuration(&cfg.Hub.Timeout, a.flags.Hub.Timeout, a.flagsSet["hub-timeout"])
+	overrideDuration(&cfg.Polling.Interval, a.flags.Polling.Interval, a.flagsSet["poll-interval"])
+	overrideDuration(&cfg.Polling.MaxAge, a.flags.Polling.MaxAge, a.flagsSet["poll-max-age"])
+	overrideInt(&cfg.Hub.WANInterface, a.flags.Hub.WANInterface, a.flagsSet["hub-wan-interface"])
+	overrideInt(&cfg.Hub.DSLChannel, a.flags.Hub.DSLChannel, a.flagsSet["hub-dsl-channel"])
+
+	if err := a.overridePassword(&cfg.Hub); err != nil {
+		return nil, err
+	}
+
+	if a.flagsSet["hub-insecure-skip-verify"] {
+		cfg.Hub.InsecureSkipVerify = a.flags.Hub.InsecureSkipVerify
+	}
+
+	for name := range cfg.Collectors {
+		if _, ok := a.flags.Collectors[name]; !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+	}
+
+	if cfg.Collectors == nil {
+		cfg.Collectors = make(map[string]bool)
+	}
+	for name, enabled := range a.flags.Collectors {
+		if _, present := cfg.Collectors[name]; !present || a.flagsSet["collector."+name] {
+			cfg.Collectors[name] = enabled
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := exporter.CheckCustomMetrics(cfg.Metrics, cfg.Labels); err != nil {
+		return nil, err
+	}
+
+	hashPassword(&cfg.Hub)
+	for i := range cfg.Targets {
+		hashPassword(&cfg.Targets[i].HubConfig)
+	}
+	return cfg, nil
+}
+
+// overridePassword applies the password flags. A password that was set explicitly, either directly or as a file,
+// replaces the password in the configuration file. The password file is read again on every reload
+func (a *app) overridePassword(hub *config.HubConfig) error {
+	if a.flagsSet["hub-password"] || a.flagsSet["hub-password-file"] || (hub.Password == "" && hub.PasswordFile == "") {
+		hub.Password, hub.PasswordFile, hub.PasswordFormat = a.flags.Hub.Password, a.flags.Hub.PasswordFile, a.flags.Hub.PasswordFormat
+		if err := hub.ReadPasswordFile(""); err != nil {
+			return fmt.Errorf("unable to read Home Hub password: %s", err)
+		}
+	}
+	overrideString(&hub.PasswordFormat, a.flags.Hub.PasswordFormat, a.flagsSet["hub-password-format"])
+	return nil
+}
+
+// hashPassword replaces a plain text password in the configuration with its MD5 hash. An empty password is left
+// alone, so that targets without a password still inherit the password of the hub
+func hashPassword(hub *config.HubConfig) {
+	if hub.PasswordFormat == client.PasswordFormatMD5 || hub.Password == "" {
+		return
+	}
+
+	if _, err := hex.DecodeString(hub.Password); err == nil && len(hub.Password) == 32 {
+		slog.Warn("The Home Hub password looks like an MD5 hash but is treated as plain text. Set the password format to md5 if it is a hash")
+	}
+
+	hub.Password = client.HashPassword([]byte(hub.Password))
+	hub.PasswordFormat = client.PasswordFormatMD5
+}
+
+// reload loads the configuration and replaces the running exporter. The running exporter is kept if the
+// configuration is invalid or the Home Hub cannot be logged in to
+func (a *app) reload() error {
+	a.reloadMutex.Lock()
+	defer a.reloadMutex.Unlock()
+
+	err := a.start()
+	if err != nil {
+		lastReloadSuccessful.Set(0)
+		return err
+	}
+
+	lastReloadSuccessful.Set(1)
+	lastReloadSuccessTimestamp.Set(float64(time.Now().Unix()))
+	return nil
+}
+
+func (a *app) start() error {
+	cfg, err := a.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	var (
+		e            *exporter.Exporter
+		publisher    *mqtt.Publisher
+		notifier     *notify.Notifier
+		cancel       context.CancelFunc = func() {}
+		stopNotifier context.CancelFunc
+	)
+
+	if cfg.Hub.Address != "" {
+		metrics := client.NewMetrics()
+		clientOptions := append(append([]client.Option{}, a.clientOptions...), client.WithMetrics(metrics))
+
+		// Resume downloading bandwidth statistics from the last stored day rather than from the start of the history
+		if day, err := exporter.LatestBandwidthDay(cfg.Bandwidth.StateFile); err != nil {
+			slog.Error("Error loading bandwidth statistics", "file", cfg.Bandwidth.StateFile, "err", err)
+		} else if !day.IsZero() {
+			clientOptions = append(clientOptions, client.WithBandwidthStartDate(day))
+		}
+
+		homehub, err := newHubClient(cfg.Hub, clientOptions...)
+		if err != nil {
+			return err
+		}
+
+		if response := homehub.Login(context.Background()); response.Error != nil {
+			return fmt.Errorf("Home Hub login failed: %s", response.Error)
+		}
+
+		options := []exporter.Option{
+			exporter.WithPresenceStateFile(cfg.Presence.StateFile),
+			exporter.WithClientMetrics(metrics),
+		}
+
+		// The running notifier is kept if its configuration is unchanged, so that the events in its queue are still
+		// delivered and recently sent events are not repeated
+		a.mutex.RLock()
+		running := a.config != nil && reflect.DeepEqual(a.config.Notifications, cfg.Notifications)
+		notifier, stopNotifier = a.notifier, a.stopNotifier
+		a.mutex.RUnlock()
+
+		if !running {
+			notifier, stopNotifier = newNotifier(cfg), nil
+		}
+		if notifier != nil {
+			options = append(options, exporter.WithNotifier(notifier))
+		}
+
+		publisher, err = newPublisher(cfg)
+		if err != nil {
+			return fmt.Errorf("mqtt: %s", err)
+		}
+		if publisher != nil {
+			options = append(options, exporter.WithPublisher(publisher))
+		}
+
+		// The new exporter loads the state files, so the running exporter must have stopped writing them first. The
+		// running publisher must also have disconnected, so that its offline status cannot follow the new online status
+		a.stopPolling()
+		e = newExporter(homehub, cfg, cfg.Bandwidth.StateFile, options...)
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		e.Start(ctx)
+		if notifier != nil && stopNotifier == nil {
+			var notifierCtx context.Context
+			notifierCtx, stopNotifier = context.WithCancel(context.Background())
+			notifier.Start(notifierCtx)
+		}
+		if publisher != nil {
+			publisher.Start(ctx)
+		}
+	}
+
+	targets := newProbeTargets(cfg, a.clientOptions)
+
+	a.mutex.Lock()
+	previousConfig, previousCancel, previousTargets := a.config, a.cancel, a.targets
+	previousNotifier, previousStopNotifier := a.notifier, a.stopNotifier
+	a.config, a.exporter, a.publisher, a.cancel, a.targets = cfg, e, publisher, cancel, targets
+	a.notifier, a.stopNotifier = notifier, stopNotifier
+	a.mutex.Unlock()
+
+	if previousNotifier != notifier && previousStopNotifier != nil {
+		previousStopNotifier()
+	}
+
+	for _, target := range targets {
+		go target.warm()
+	}
+
+	if previousCancel != nil {
+		previousCancel()
+		for _, target := range previousTargets {
+			target.stop()
+		}
+		if previousConfig.Web != cfg.Web {
+			slog.Warn("Web server configuration changes require a restart to take effect")
+		}
+		slog.Info("Configuration reloaded", "file", a.configFile)
+	}
+	return nil
+}
+
+// stopPolling stops the background polling of the running exporter and waits for any poll in progress to finish,
+// so that only one exporter polls the Home Hub and writes the state files at a time. It also waits for the running
+// publisher to disconnect from the MQTT broker. The caller must hold the reload mutex
+func (a *app) stopPolling() {
+	a.mutex.RLock()
+	e, publisher, cancel := a.exporter, a.publisher, a.cancel
+	a.mutex.RUnlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	if e != nil {
+		e.Wait()
+	}
+	if publisher != nil {
+		publisher.Wait()
+	}
+}
+
+func (a *app) current() (*exporter.Exporter, *config.Config) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.exporter, a.config
+}
+
+func (a *app) currentConfig() *config.Config {
+	_, cfg := a.current()
+	return cfg
+}
+
+func (a *app) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	e, cfg := a.current()
+
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	if e != nil {
+		if err := prometheus.WrapRegistererWith(cfg.Labels, registry).Register(e.WithContext(ctx)); err != nil {
+			slog.ErrorContext(ctx, "Error registering metrics", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, registry}
+	promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+func (a *app) bandwidthHandler(w http.ResponseWriter, r *http.Request) {
+	e, _ := a.current()
+
+	if name := r.URL.Query().Get("target"); name != "" {
+		target, _ := a.target(name)
+		if target == nil {
+			http.Error(w, fmt.Sprintf("unknown target %q", name), http.StatusNotFound)
+			return
+		}
+
+		var err error
+		e, err = target.get(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	if e == nil {
+		http.Error(w, "No Home Hub is configured. Use the target parameter to select one", http.StatusNotFound)
+		return
+	}
+	e.BandwidthHandler().ServeHTTP(w, r)
+}
+
+func (a *app) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := a.reload(); err != nil {
+		slog.Error("Error reloading configuration", "file", a.configFile, "err", err)
+		http.Error(w, fmt.Sprintf("failed to reload configuration: %s", err), http.StatusInternalServerError)
+	}
+}
+
+// query logs in to the Home Hub and writes the values of the given XPaths to w as JSON
+func (a *app) query(ctx context.Context, xpaths []string, w io.Writer) error {
+	cfg, err := a.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	homehub, err := newHubClient(cfg.Hub, a.clientOptions...)
+	if err != nil {
+		return err
+	}
+
+	if response := homehub.Login(ctx); response.Error != nil {
+		return fmt.Errorf("Home Hub login failed: %s", response.Error)
+	}
+
+	return runQuery(ctx, homehub, xpaths, w)
+}
+
+func newHubClient(hub config.HubConfig, opts ...client.Option) (client.Client, error) {
+	if hub.Address == "" {
+		return nil, fmt.Errorf("no Home Hub address is configured")
+	}
+
+	tlsConfig, err := client.NewTLSConfig(hub.CAFile, hub.InsecureSkipVerify)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load Home Hub CA file: %s", err)
+	}
+
+	options := []client.Option{
+		client.WithTimeout(hub.Timeout),
+		client.WithTLSConfig(tlsConfig),
+		client.WithWANInterface(hub.WANInterface),
+		client.WithDSLChannel(hub.DSLChannel),
+		client.WithPasswordFormat(hub.PasswordFormat),
+	}
+
+	options = append(options, opts...)
+
+	if hub.ProxyURL != "" {
+		proxy, err := url.Parse(hub.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Home Hub proxy URL: %s", err)
+		}
+		options = append(options, client.WithProxy(proxy))
+	}
+
+	return client.New(hub.Scheme+"://"+hub.Address, hub.Username, hub.Password, options...), nil
+}
+
+func newExporter(homehub client.Client, cfg *config.Config, bandwidthStateFile string, opts ...exporter.Option) *exporter.Exporter {
+	options := []exporter.Option{
+		exporter.WithPollInterval(cfg.Polling.Interval),
+		exporter.WithMaxSnapshotAge(cfg.Polling.MaxAge),
+		exporter.WithBandwidthStateFile(bandwidthStateFile),
+		exporter.WithBandwidthRetention(cfg.Bandwidth.RetentionDays),
+		exporter.WithCustomMetrics(cfg.Metrics),
+		exporter.WithCollectors(enabledCollectors(cfg)...),
+	}
+	return exporter.New(homehub, append(options, opts...)...)
+}
+
+// newNotifier creates a notifier for the configured webhooks, or returns nil if there are none
+func newNotifier(cfg *config.Config) *notify.Notifier {
+	if len(cfg.Notifications.Webhooks) == 0 {
+		return nil
+	}
+
+	if !cfg.Collectors["presence"] {
+		slog.Warn("Device events are not sent to webhooks while the presence collector is disabled")
+	}
+
+	webhooks := make([]notify.Webhook, 0, len(cfg.Notifications.Webhooks))
+	for _, webhook := range cfg.Notifications.Webhooks {
+		webhooks = append(webhooks, notify.Webhook{
+			URL:        webhook.URL,
+			Format:     webhook.Format,
+			Events:     webhook.Events,
+			Timeout:    webhook.Timeout,
+			MaxRetries: webhook.MaxRetries,
+		})
+	}
+
+	options := []notify.Option{notify.WithAllowlist(cfg.Notifications.Allowlist)}
+	if cfg.Notifications.DedupWindow > 0 {
+		options = append(options, notify.WithDedupWindow(cfg.Notifications.DedupWindow))
+	}
+	return notify.New(webhooks, options...)
+}
+
+// newPublisher creates an MQTT publisher for the configured broker, or returns nil if there is none
+func newPublisher(cfg *config.Config) (*mqtt.Publisher, error) {
+	if cfg.MQTT.Broker == "" {
+		return nil, nil
+	}
+
+	if cfg.Polling.Interval <= 0 {
+		slog.Warn("MQTT messages are only published when metrics are scraped while polling is disabled")
+	}
+
+	tlsConfig, err := client.NewTLSConfig(cfg.MQTT.CAFile, cfg.MQTT.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.MQTT.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.MQTT.CertFile, cfg.MQTT.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	options := []mqtt.Option{
+		mqtt.WithCredentials(cfg.MQTT.Username, cfg.MQTT.Password),
+		mqtt.WithTLSConfig(tlsConfig),
+		mqtt.WithDiscovery(!cfg.MQTT.DisableDiscovery, cfg.MQTT.DiscoveryPrefix),
+		mqtt.WithQoS(byte(cfg.MQTT.QoS)),
+		mqtt.WithRetain(cfg.MQTT.Retain),
+		mqtt.WithTimeout(cfg.MQTT.Timeout),
+	}
+	if cfg.MQTT.ClientID != "" {
+		options = append(options, mqtt.WithClientID(cfg.MQTT.ClientID))
+	}
+	if cfg.MQTT.TopicPrefix != "" {
+		options = append(options, mqtt.WithTopicPrefix(cfg.MQTT.TopicPrefix))
+	}
+	return mqtt.New(cfg.MQTT.Broker, options...), nil
+}
+
+func enabledCollectors(cfg *config.Config) []string {
+	var names []string
+	for name, enabled := range cfg.Collectors {
+		if enabled {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// overrideString replaces a configuration setting with the flag value if the flag was set explicitly,
+// or if the setting is missing from the configuration file
+func overrideString(setting *string, flagValue string, flagSet bool) {
+	if flagSet || *setting == "" {
+		*setting = flagValue
+	}
+}
+
+func overrideDuration(setting *time.Duration, flagValue time.Duration, flagSet bool) {
+	if flagSet || *setting == 0 {
+		*setting = flagValue
+	}
+}
+
+func overrideInt(setting *int, flagValue int, flagSet bool) {
+	if flagSet || *setting == 0 {
+		*setting = flagValue
+	}
+}
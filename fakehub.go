@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/hubtest"
+)
+
+// runFakeHub serves a fake Home Hub, so that the exporter can be tried out without a router. The uptime and
+// traffic counters of the fake Home Hub increase while it runs
+func runFakeHub(args []string) error {
+	flags := flag.NewFlagSet("fake-hub", flag.ContinueOnError)
+	listenAddress := flags.String("listen-address", ":8080", "Address that the fake Home Hub will listen on")
+	username := flags.String("username", hubtest.DefaultUsername, "Username accepted by the fake Home Hub")
+	password := flags.String("password", hubtest.DefaultPassword, "Password accepted by the fake Home Hub")
+	dataModel := flags.String("data-model", "", "JSON file containing the data model served by the fake Home Hub. Defaults to a Home Hub connected over VDSL")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	opts := []hubtest.Option{hubtest.WithCredentials(*username, *password)}
+	if *dataModel != "" {
+		data, err := ioutil.ReadFile(*dataModel)
+		if err != nil {
+			return err
+		}
+
+		var model map[string]interface{}
+		if err := json.Unmarshal(data, &model); err != nil {
+			return fmt.Errorf("invalid data model %s: %s", *dataModel, err)
+		}
+		opts = append(opts, hubtest.WithDataModel(model))
+	}
+
+	hub := hubtest.New(opts...)
+	go func() {
+		for range time.Tick(time.Second) {
+			hub.Advance(time.Second)
+		}
+	}()
+
+	slog.Info("Starting fake Home Hub", "listen_address", *listenAddress, "username", *username)
+	return http.ListenAndServe(*listenAddress, hub)
+}
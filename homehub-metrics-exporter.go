@@ -1,57 +1,186 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/config"
 	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/exporter"
-
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/logging"
 )
 
+const scrapeTimeoutOffset = 0.5
+
 func main() {
 	var (
-		listenAddress string
-		hubAddress    string
-		username      string
-		password      string
+		flags          config.Config
+		configFile     string
+		logLevel       string
+		logFormat      string
+		dumpHubTraffic bool
 	)
 
-	flag.StringVar(&listenAddress, "listen-address", envOrDefault("HUB_EXPORTER_LISTEN_ADDRESS", ":19092"), "Address that the metrics HTTP server will listen on")
-	flag.StringVar(&hubAddress, "hub-address", envOrDefault("HUB_ADDRESS", "192.168.1.254"), "Address for the Home Hub router")
-	flag.StringVar(&username, "hub-username", envOrDefault("HUB_USERNAME", "admin"), "Username for the Home Hub router")
-	flag.StringVar(&password, "hub-password", envOrDefault("HUB_PASSWORD", ""), "Password for the Home Hub router, either plain text or MD5 hashed")
+	flag.StringVar(&flags.Web.ListenAddress, "listen-address", envOrDefault("HUB_EXPORTER_LISTEN_ADDRESS", ":19092"), "Address that the metrics HTTP server will listen on")
+	flag.StringVar(&flags.Web.MetricsPath, "metrics-path", "/metrics", "Path under which metrics are served")
+	flag.StringVar(&flags.Hub.Address, "hub-address", envOrDefault("HUB_ADDRESS", "192.168.1.254"), "Address for the Home Hub router")
+	flag.StringVar(&flags.Hub.Scheme, "hub-scheme", envOrDefault("HUB_SCHEME", "http"), "Scheme used to connect to the Home Hub router, either http or https")
+	flag.StringVar(&flags.Hub.Username, "hub-username", envOrDefault("HUB_USERNAME", "admin"), "Username for the Home Hub router")
+	flag.StringVar(&flags.Hub.Password, "hub-password", envOrDefault("HUB_PASSWORD", ""), "Password for the Home Hub router. Command line arguments are visible to other processes, so prefer --hub-password-file")
+	flag.StringVar(&flags.Hub.PasswordFile, "hub-password-file", envOrDefault("HUB_PASSWORD_FILE", ""), "File containing the password for the Home Hub router. The file is read again when the configuration is reloaded")
+	flag.StringVar(&flags.Hub.PasswordFormat, "hub-password-format", envOrDefault("HUB_PASSWORD_FORMAT", client.PasswordFormatPlain), "Format of the Home Hub router password, either plain or md5. Use the hash-password command to create an MD5 hash")
+	flag.DurationVar(&flags.Hub.Timeout, "hub-timeout", client.DefaultTimeout, "Timeout for requests to the Home Hub router")
+	flag.StringVar(&flags.Hub.CAFile, "hub-ca-file", envOrDefault("HUB_CA_FILE", ""), "Path to a PEM encoded CA certificate bundle used to verify the Home Hub router HTTPS certificate")
+	flag.BoolVar(&flags.Hub.InsecureSkipVerify, "hub-insecure-skip-verify", false, "Disable verification of the Home Hub router HTTPS certificate")
+	flag.StringVar(&flags.Hub.ProxyURL, "hub-proxy-url", envOrDefault("HUB_PROXY_URL", ""), "URL of an HTTP proxy used to connect to the Home Hub router")
+	flag.IntVar(&flags.Hub.WANInterface, "hub-wan-interface", 0, "uid of the Home Hub WAN IP interface. When 0, the interface is discovered automatically")
+	flag.IntVar(&flags.Hub.DSLChannel, "hub-dsl-channel", 0, "uid of the Home Hub DSL channel. When 0, the channel is discovered automatically")
+	flag.DurationVar(&flags.Polling.Interval, "poll-interval", 0, "Interval at which the Home Hub router is polled in the background. When 0, the router is queried on every scrape")
+	flag.DurationVar(&flags.Polling.MaxAge, "poll-max-age", 0, "Maximum age of polled metrics before they are considered stale. Defaults to 3 times the poll interval")
+	flag.StringVar(&flags.Bandwidth.StateFile, "bandwidth-state-file", envOrDefault("HUB_EXPORTER_BANDWIDTH_STATE_FILE", ""), "File used to persist device bandwidth statistics across restarts")
+	flag.StringVar(&flags.Presence.StateFile, "presence-state-file", envOrDefault("HUB_EXPORTER_PRESENCE_STATE_FILE", ""), "File used to persist when devices were first and last seen across restarts")
+	flag.StringVar(&configFile, "config.file", envOrDefault("HUB_EXPORTER_CONFIG_FILE", ""), "Path to a YAML configuration file. Flags that are set explicitly override the file")
+	flag.StringVar(&logLevel, "log.level", envOrDefault("HUB_EXPORTER_LOG_LEVEL", "info"), "Only log messages with the given severity or above. One of debug, info, warn or error")
+	flag.StringVar(&logFormat, "log.format", envOrDefault("HUB_EXPORTER_LOG_FORMAT", logging.FormatLogfmt), "Output format of log messages. One of logfmt or json")
+	flag.BoolVar(&dumpHubTraffic, "log.dump-hub-traffic", false, "Log the bodies of requests to and responses from the Home Hub router with credentials removed. Requires --log.level=debug")
+
+	collectors := make(map[string]*bool)
+	for _, name := range exporter.CollectorNames() {
+		usage := fmt.Sprintf("Enable the %s collector", name)
+		if exporter.UsesSummaryStatistics(name) {
+			usage += ". It shares the summary statistics request with the other collectors except bandwidth, so it fails whenever that request fails"
+		}
+		collectors[name] = flag.Bool("collector."+name, true, usage)
+	}
 	flag.Parse()
 
-	homehub := client.New("http://"+hubAddress, username, password)
-	response := homehub.Login()
+	flags.Collectors = make(map[string]bool)
+	for name, enabled := range collectors {
+		flags.Collectors[name] = *enabled
+	}
+
+	logger, err := logging.New(os.Stderr, logLevel, logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	flagsSet := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		flagsSet[f.Name] = true
+	})
+
+	app := &app{
+		configFile: configFile,
+		flags:      &flags,
+		flagsSet:   flagsSet,
+	}
+
+	// The password flag is only hashed once, rather than keeping the plain text to hash again on every reload
+	hashPassword(&app.flags.Hub)
+
+	if dumpHubTraffic {
+		if !logger.Enabled(context.Background(), slog.LevelDebug) {
+			slog.Warn("Home Hub traffic is only logged when the log level is debug")
+		}
+		app.clientOptions = []client.Option{client.WithTrafficDump()}
+	}
+
+	if flag.Arg(0) == "hash-password" {
+		if err := hashPasswordCommand(os.Stdin, os.Stderr, os.Stdout); err != nil {
+			slog.Error("Unable to hash password", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "fake-hub" {
+		if err := runFakeHub(flag.Args()[1:]); err != nil {
+			slog.Error("Fake Home Hub stopped", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "query" {
+		if err := app.query(context.Background(), flag.Args()[1:], os.Stdout); err != nil {
+			slog.Error("Query failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "record" {
+		if err := app.record(context.Background(), flag.Args()[1:]); err != nil {
+			slog.Error("Recording failed", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	if response.Error != nil {
-		log.Fatalln("Home Hub login failed. Unable to collect metrics.")
+	if err := app.reload(); err != nil {
+		slog.Error("Unable to start Home Hub Exporter", "err", err)
+		os.Exit(1)
 	}
 
-	exporter := exporter.New(homehub)
-	prometheus.MustRegister(exporter)
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if err := app.reload(); err != nil {
+				slog.Error("Error reloading configuration", "file", configFile, "err", err)
+			}
+		}
+	}()
+
+	webConfig := app.currentConfig().Web
 
-	log.Printf("Starting Home Hub Exporter")
+	slog.Info("Starting Home Hub Exporter", "listen_address", webConfig.ListenAddress, "metrics_path", webConfig.MetricsPath)
 
-	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc(webConfig.MetricsPath, app.metricsHandler)
+	http.HandleFunc("/probe", app.probeHandler)
+	http.HandleFunc("/api/bandwidth", app.bandwidthHandler)
+	http.HandleFunc("/-/reload", app.reloadHandler)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		//nolint:golint,errcheck
 		w.Write([]byte(`<html>
 		                <head><title>Home Hub Exporter</title></head>
 		                <body>
 		                   <h1>Home Hub Exporter</h1>
-		                   <p><a href="/metrics">Metrics</a></p>
+		                   <p><a href="` + webConfig.MetricsPath + `">Metrics</a></p>
+		                   <p><a href="/api/bandwidth">Bandwidth history</a></p>
 		                   </body>
 		                </html>
 		              `))
 	})
-	log.Fatal(http.ListenAndServe(listenAddress, nil))
+	err = http.ListenAndServe(webConfig.ListenAddress, nil)
+	slog.Error("HTTP server stopped", "err", err)
+	os.Exit(1)
+}
+
+// scrapeContext returns a context that is cancelled when the scrape request is abandoned or when the
+// timeout advertised by Prometheus has elapsed
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	timeout, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || timeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+
+	// Leave some headroom so that a response can be written before Prometheus gives up on the scrape
+	timeout -= scrapeTimeoutOffset
+	if timeout <= 0 {
+		timeout = scrapeTimeoutOffset
+	}
+	return context.WithTimeout(r.Context(), time.Duration(timeout*float64(time.Second)))
 }
 
 func envOrDefault(env string, defaultValue string) string {
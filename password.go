@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+
+	"golang.org/x/term"
+)
+
+// hashPasswordCommand reads a password from in and writes its MD5 hash to w, so that the hash can be stored in place of the
+// plain text password. When in is a terminal, the password is prompted for twice without being echoed
+func hashPasswordCommand(in *os.File, prompt io.Writer, w io.Writer) error {
+	var (
+		password     []byte
+		confirmation []byte
+		err          error
+	)
+	// The plain text is zeroed once it has been hashed, or when it is rejected
+	defer func() {
+		clear(password)
+		clear(confirmation)
+	}()
+
+	if term.IsTerminal(int(in.Fd())) {
+		password, err = readPassword(in, prompt, "Home Hub password: ")
+		if err != nil {
+			return err
+		}
+
+		confirmation, err = readPassword(in, prompt, "Confirm password: ")
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(password, confirmation) {
+			return fmt.Errorf("passwords do not match")
+		}
+	} else {
+		password, err = bufio.NewReader(in).ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		password = bytes.TrimRight(password, "\r\n")
+	}
+
+	if len(password) == 0 {
+		return fmt.Errorf("no password was entered")
+	}
+
+	_, err = fmt.Fprintln(w, client.HashPassword(password))
+	return err
+}
+
+func readPassword(in *os.File, prompt io.Writer, message string) ([]byte, error) {
+	fmt.Fprint(prompt, message)
+	password, err := term.ReadPassword(int(in.Fd()))
+	fmt.Fprintln(prompt)
+	return password, err
+}
@@ -0,0 +1,100 @@
+// Package cassette records the HTTP traffic between a client and the Home Hub, with credentials removed, so that it
+// can be replayed in tests
+package cassette
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Cassette is a sequence of recorded requests to the Home Hub and the responses to them
+type Cassette struct {
+	Interactions []Interaction `yaml:"interactions"`
+}
+
+// Interaction is a single request and its response
+type Interaction struct {
+	Request  Request  `yaml:"request"`
+	Response Response `yaml:"response"`
+}
+
+// Request is a recorded request. The scheme and host are not recorded, so that a cassette can be replayed
+// against any address
+type Request struct {
+	Method string `yaml:"method"`
+	Path   string `yaml:"path"`
+	// Body is the JSON-RPC request sent in the req form field, if any
+	Body string `yaml:"body,omitempty"`
+}
+
+// Response is a recorded response
+type Response struct {
+	Status      int    `yaml:"status"`
+	ContentType string `yaml:"content_type,omitempty"`
+	Body        string `yaml:"body"`
+}
+
+// Load reads a cassette file
+func Load(file string) (*Cassette, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	cassette := &Cassette{}
+	if err := yaml.UnmarshalStrict(data, cassette); err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %s", file, err)
+	}
+	return cassette, nil
+}
+
+// Save writes the cassette to file
+func (c *Cassette) Save(file string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// key identifies a request by its method, path and the method and XPath of each JSON-RPC action. Request ids,
+// nonces, auth keys and action parameters such as dates are ignored, since they differ every time a request is made
+func (r Request) key() string {
+	key := r.Method + " " + r.Path
+	if r.Body == "" {
+		return key
+	}
+
+	var body struct {
+		Request struct {
+			Actions []struct {
+				Method string `json:"method"`
+				XPath  string `json:"xpath"`
+			} `json:"actions"`
+		} `json:"request"`
+	}
+
+	if err := json.Unmarshal([]byte(r.Body), &body); err != nil {
+		return key + " " + r.Body
+	}
+
+	actions := make([]string, 0, len(body.Request.Actions))
+	for _, action := range body.Request.Actions {
+		actions = append(actions, action.Method+"("+action.XPath+")")
+	}
+	return key + " " + strings.Join(actions, ",")
+}
+
+// requestBody returns the JSON-RPC request from a form encoded request body
+func requestBody(body []byte) string {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return string(body)
+	}
+	return form.Get("req")
+}
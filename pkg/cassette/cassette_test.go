@@ -0,0 +1,112 @@
+package cassette
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/hubtest"
+)
+
+func record(t *testing.T, opts ...RecorderOption) *Cassette {
+	_, server := hubtest.NewServer()
+	defer server.Close()
+
+	var recorder *Recorder
+	homehub := client.New(server.URL, hubtest.DefaultUsername, hubtest.DefaultPassword, client.WithTransportWrapper(func(next http.RoundTripper) http.RoundTripper {
+		recorder = NewRecorder(next, opts...)
+		return recorder
+	}))
+
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	if _, err := homehub.GetDevices(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	return recorder.Cassette()
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cassette.yaml")
+	if err := record(t).Save(file); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"sess_id", "cookie", `"cnonce":1`} {
+		if strings.Contains(string(data), secret) {
+			t.Fatalf("Expected %s to be removed from the cassette", secret)
+		}
+	}
+
+	recorded, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, interaction := range recorded.Interactions {
+		if strings.Contains(interaction.Request.Body, `"auth-key":"`) && !strings.Contains(interaction.Request.Body, `"auth-key":"[REDACTED]"`) {
+			t.Fatalf("Expected the auth-key to be removed. Got %s", interaction.Request.Body)
+		}
+	}
+
+	homehub := client.New("http://homehub", "admin", "anything", client.WithTransportWrapper(func(http.RoundTripper) http.RoundTripper {
+		return recorded.Transport()
+	}))
+
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	// Recorded responses are reused once they have all been replayed
+	for i := 0; i < 2; i++ {
+		devices, err := homehub.GetDevices(context.Background())
+		if err != nil || len(devices) != 4 {
+			t.Fatalf("Expected 4 devices. Got %d, %v", len(devices), err)
+		}
+	}
+
+	if _, err := homehub.GetValues(context.Background(), client.UpTime); err == nil || !strings.Contains(err.Error(), "no recorded response") {
+		t.Fatalf("Expected an error for a request that was not recorded. Got %v", err)
+	}
+}
+
+func TestHashedMACAddresses(t *testing.T) {
+	recorded := record(t, WithHashedMACAddresses())
+
+	var body string
+	for _, interaction := range recorded.Interactions {
+		body += interaction.Response.Body
+	}
+
+	if strings.Contains(body, "AA:BB:CC:DD:EE:01") {
+		t.Fatal("Expected MAC addresses to be hashed")
+	}
+
+	hashed := hashMACAddress("aa:bb:cc:dd:ee:01")
+	if hashed != hashMACAddress("AA:BB:CC:DD:EE:01") || !strings.Contains(body, hashed) {
+		t.Fatalf("Expected MAC address to be replaced with %s", hashed)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cassette.yaml")
+	if err := ioutil.WriteFile(file, []byte("interactions: [{unknown: true}]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(file); err == nil {
+		t.Fatal("Expected an error loading an invalid cassette")
+	}
+}
@@ -0,0 +1,134 @@
+package cassette
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/logging"
+)
+
+var macAddressPattern = regexp.MustCompile(`(?i)\b[0-9a-f]{2}(?::[0-9a-f]{2}){5}\b`)
+
+// Recorder is an http.RoundTripper that records each request and response. Credentials are removed before they
+// are recorded: the auth-key and cnonce of each request are cleared, nonces are redacted and cookies are not recorded
+type Recorder struct {
+	next             http.RoundTripper
+	hashMACAddresses bool
+
+	mutex    sync.Mutex
+	cassette Cassette
+}
+
+// RecorderOption configures a Recorder
+type RecorderOption func(*Recorder)
+
+// WithHashedMACAddresses replaces device MAC addresses with a hash of the address. The same address is always
+// replaced with the same hash, so that devices can still be matched up across responses
+func WithHashedMACAddresses() RecorderOption {
+	return func(r *Recorder) {
+		r.hashMACAddresses = true
+	}
+}
+
+// NewRecorder creates a Recorder that sends requests with next
+func NewRecorder(next http.RoundTripper, opts ...RecorderOption) *Recorder {
+	r := &Recorder{next: next}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Recorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	var requestBytes []byte
+	if request.Body != nil {
+		var err error
+		requestBytes, err = ioutil.ReadAll(request.Body)
+		request.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		request.Body = ioutil.NopCloser(bytes.NewReader(requestBytes))
+	}
+
+	response, err := r.next.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(responseBytes))
+
+	interaction := Interaction{
+		Request: Request{
+			Method: request.Method,
+			Path:   request.URL.Path,
+		},
+		Response: Response{
+			Status:      response.StatusCode,
+			ContentType: response.Header.Get("Content-Type"),
+			Body:        r.sanitize(string(responseBytes)),
+		},
+	}
+
+	if len(requestBytes) > 0 {
+		interaction.Request.Body = r.sanitize(sanitizeRequest(requestBody(requestBytes)))
+	}
+
+	r.mutex.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.mutex.Unlock()
+	return response, nil
+}
+
+// Cassette returns the interactions recorded so far
+func (r *Recorder) Cassette() *Cassette {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return &Cassette{Interactions: append([]Interaction(nil), r.cassette.Interactions...)}
+}
+
+func (r *Recorder) sanitize(body string) string {
+	body = logging.Redact(body)
+	if r.hashMACAddresses {
+		body = macAddressPattern.ReplaceAllStringFunc(body, hashMACAddress)
+	}
+	return body
+}
+
+// sanitizeRequest clears the auth-key and cnonce of a JSON-RPC request
+func sanitizeRequest(body string) string {
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		return body
+	}
+
+	if request, ok := payload["request"].(map[string]interface{}); ok {
+		request["auth-key"] = ""
+		request["cnonce"] = 0
+	}
+
+	sanitized, err := json.Marshal(payload)
+	if err != nil {
+		return body
+	}
+	return string(sanitized)
+}
+
+// hashMACAddress returns a locally administered MAC address derived from a hash of the address
+func hashMACAddress(address string) string {
+	hash := sha256.Sum256([]byte(strings.ToUpper(address)))
+	return fmt.Sprintf("02:%02X:%02X:%02X:%02X:%02X", hash[0], hash[1], hash[2], hash[3], hash[4])
+}
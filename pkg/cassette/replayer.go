@@ -0,0 +1,92 @@
+package cassette
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// replayer is an http.RoundTripper that answers requests with the responses recorded in a cassette
+type replayer struct {
+	cassette *Cassette
+
+	mutex sync.Mutex
+	used  []bool
+}
+
+// Transport returns an http.RoundTripper that answers each request with the response to the first unused recorded
+// request that matches it. Once every matching request has been used, the last one is used again, so that a
+// cassette recorded from a single scrape can be replayed for any number of scrapes
+func (c *Cassette) Transport() http.RoundTripper {
+	return &replayer{
+		cassette: c,
+		used:     make([]bool, len(c.Interactions)),
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *replayer) RoundTrip(request *http.Request) (*http.Response, error) {
+	recorded := Request{
+		Method: request.Method,
+		Path:   request.URL.Path,
+	}
+
+	if request.Body != nil {
+		body, err := ioutil.ReadAll(request.Body)
+		request.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > 0 {
+			recorded.Body = requestBody(body)
+		}
+	}
+
+	interaction, ok := r.match(recorded.key())
+	if !ok {
+		return nil, fmt.Errorf("no recorded response for %s", recorded.key())
+	}
+
+	header := http.Header{}
+	if interaction.Response.ContentType != "" {
+		header.Set("Content-Type", interaction.Response.ContentType)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Response.Status, http.StatusText(interaction.Response.Status)),
+		StatusCode:    interaction.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(interaction.Response.Body))),
+		ContentLength: int64(len(interaction.Response.Body)),
+		Request:       request,
+	}, nil
+}
+
+func (r *replayer) match(key string) (Interaction, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	last := -1
+	for i, interaction := range r.cassette.Interactions {
+		if !strings.EqualFold(interaction.Request.key(), key) {
+			continue
+		}
+
+		if !r.used[i] {
+			r.used[i] = true
+			return interaction, true
+		}
+		last = i
+	}
+
+	if last < 0 {
+		return Interaction{}, false
+	}
+	return r.cassette.Interactions[last], true
+}
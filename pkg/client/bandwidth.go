@@ -0,0 +1,135 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BandwidthRecordDateFormat is the layout used to format BandwidthRecord dates
+const BandwidthRecordDateFormat = "2006-01-02"
+
+// Layouts that the Home Hub has been seen to use for dates in the bandwidth monitoring file
+var bandwidthRecordDateLayouts = []string{BandwidthRecordDateFormat, bandwidthDateFormat, "2006/01/02"}
+
+// BandwidthRecord represents a single row of the Home Hub bandwidth monitoring file. Each row holds the
+// amount of data transferred by a device on a particular day
+type BandwidthRecord struct {
+	SerialNumber string
+	MACAddress   string
+	Date         time.Time
+	// Downloaded is the number of megabytes downloaded by the device
+	Downloaded float64
+	// Uploaded is the number of megabytes uploaded by the device
+	Uploaded float64
+	// Extra holds any additional columns that follow the uploaded megabytes column
+	Extra []string
+}
+
+type bandwidthRecordJSON struct {
+	SerialNumber string   `json:"serialNumber"`
+	MACAddress   string   `json:"macAddress"`
+	Date         string   `json:"date"`
+	Downloaded   float64  `json:"downloadedMegabytes"`
+	Uploaded     float64  `json:"uploadedMegabytes"`
+	Extra        []string `json:"extra,omitempty"`
+}
+
+// MarshalJSON encodes the record with its date formatted as YYYY-MM-DD
+func (record BandwidthRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(bandwidthRecordJSON{
+		SerialNumber: record.SerialNumber,
+		MACAddress:   record.MACAddress,
+		Date:         record.Date.Format(BandwidthRecordDateFormat),
+		Downloaded:   record.Downloaded,
+		Uploaded:     record.Uploaded,
+		Extra:        record.Extra,
+	})
+}
+
+// UnmarshalJSON decodes a record encoded by MarshalJSON
+func (record *BandwidthRecord) UnmarshalJSON(data []byte) error {
+	var decoded bandwidthRecordJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	date, err := time.Parse(BandwidthRecordDateFormat, decoded.Date)
+	if err != nil {
+		return err
+	}
+
+	*record = BandwidthRecord{
+		SerialNumber: decoded.SerialNumber,
+		MACAddress:   decoded.MACAddress,
+		Date:         date,
+		Downloaded:   decoded.Downloaded,
+		Uploaded:     decoded.Uploaded,
+		Extra:        decoded.Extra,
+	}
+	return nil
+}
+
+// ParseBandwidthRecord parses a single line of the bandwidth monitoring file. Lines have the format:
+//
+//	serial number,MAC address,date,downloaded megabytes,uploaded megabytes[,...]
+//
+// Unparseable megabyte values are treated as zero
+func ParseBandwidthRecord(line string) (*BandwidthRecord, error) {
+	columns := strings.Split(strings.TrimSpace(line), ",")
+	if len(columns) < 5 {
+		return nil, fmt.Errorf("expected at least 5 columns in bandwidth record but found %d", len(columns))
+	}
+
+	date, err := parseBandwidthRecordDate(strings.TrimSpace(columns[2]))
+	if err != nil {
+		return nil, err
+	}
+
+	downloaded, err := strconv.ParseFloat(strings.TrimSpace(columns[3]), 64)
+	if err != nil {
+		downloaded = 0
+	}
+
+	uploaded, err := strconv.ParseFloat(strings.TrimSpace(columns[4]), 64)
+	if err != nil {
+		uploaded = 0
+	}
+
+	record := &BandwidthRecord{
+		SerialNumber: strings.TrimSpace(columns[0]),
+		MACAddress:   strings.ToUpper(strings.TrimSpace(columns[1])),
+		Date:         date,
+		Downloaded:   downloaded,
+		Uploaded:     uploaded,
+	}
+
+	if len(columns) > 5 {
+		record.Extra = columns[5:]
+	}
+
+	return record, nil
+}
+
+// ParseBandwidthRecords parses the content of a bandwidth monitoring file, skipping any lines that cannot be parsed
+func ParseBandwidthRecords(data string) []BandwidthRecord {
+	var records []BandwidthRecord
+	for _, line := range strings.Split(data, "\n") {
+		record, err := ParseBandwidthRecord(line)
+		if err == nil {
+			records = append(records, *record)
+		}
+	}
+	return records
+}
+
+func parseBandwidthRecordDate(value string) (time.Time, error) {
+	for _, layout := range bandwidthRecordDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid bandwidth record date %q", value)
+}
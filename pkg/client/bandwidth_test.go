@@ -0,0 +1,58 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBandwidthRecord(t *testing.T) {
+	record, err := ParseBandwidthRecord("FAKE+SERIAL+NUMBER,aa:bb:cc:dd:ee:f1,2016-12-30,100.5,10,extra")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expectedDate := time.Date(2016, time.December, 30, 0, 0, 0, 0, time.UTC)
+	if record.SerialNumber != "FAKE+SERIAL+NUMBER" || record.MACAddress != "AA:BB:CC:DD:EE:F1" || !record.Date.Equal(expectedDate) ||
+		record.Downloaded != 100.5 || record.Uploaded != 10 || len(record.Extra) != 1 || record.Extra[0] != "extra" {
+		t.Fatalf("Unexpected bandwidth record: %+v", record)
+	}
+
+	for _, line := range []string{"", "FAKE+SERIAL+NUMBER,AA:BB:CC:DD:EE:F1,2016-12-30,100", "FAKE+SERIAL+NUMBER,AA:BB:CC:DD:EE:F1,yesterday,100,10"} {
+		if _, err := ParseBandwidthRecord(line); err == nil {
+			t.Fatalf("Expected error parsing bandwidth record %q", line)
+		}
+	}
+}
+
+func TestParseBandwidthRecords(t *testing.T) {
+	records := ParseBandwidthRecords("A,AA:BB:CC:DD:EE:F1,20161230,100,10\n\nA,AA:BB:CC:DD:EE:F2,2016-12-31,200,20\n")
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 bandwidth records. Got %d", len(records))
+	}
+
+	if records[0].Date.Day() != 30 || records[1].Date.Day() != 31 {
+		t.Fatalf("Unexpected bandwidth record dates: %v, %v", records[0].Date, records[1].Date)
+	}
+}
+
+func FuzzParseBandwidthRecord(f *testing.F) {
+	f.Add("FAKE+SERIAL+NUMBER,AA:BB:CC:DD:EE:F1,2016-12-30,100,10")
+	f.Add("A,aa:bb:cc:dd:ee:f1,20161230,1e3,NaN,extra")
+	f.Add("A,B,2016/12/30,,")
+	f.Add(",,,,")
+
+	f.Fuzz(func(t *testing.T, line string) {
+		record, err := ParseBandwidthRecord(line)
+		if err != nil {
+			return
+		}
+
+		if record.Date.IsZero() {
+			t.Fatalf("Parsed bandwidth record %q without a date", line)
+		}
+
+		if len(ParseBandwidthRecords(line)) != 1 {
+			t.Fatalf("Expected ParseBandwidthRecords to return the record parsed from %q", line)
+		}
+	})
+}
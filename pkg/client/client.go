@@ -1,53 +1,96 @@
 package client
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+const bandwidthDateFormat = "20060102"
+
+// The earliest date requested from the bandwidth monitoring service
+var bandwidthMonitoringEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
 type session struct {
-	url          string
-	apiURL       string
-	userName     string
-	password     string
+	url      string
+	apiURL   string
+	userName string
+	// passwordHash is the MD5 hash of the password. The plain text password is never kept
+	passwordHash string
 	sessionID    string
 	nonce        string
 	requestCount int32
 }
 
-// Client represents an interface to the Home Hub router
+// Client represents an interface to the Home Hub router. Implementations must be safe for concurrent use.
 type Client interface {
-	Login() *Response
-	GetSummaryStatistics() *Response
-	GetBandwidthStatistics() *Response
+	Login(ctx context.Context) *Response
+	GetSummaryStatistics(ctx context.Context, xpaths ...string) *Response
+	GetBandwidthStatistics(ctx context.Context) *Response
+	GetDevices(ctx context.Context) ([]DeviceDetail, error)
+	GetValues(ctx context.Context, xpaths ...string) (map[string]Value, error)
+	Relogins() int
 }
 
 // HubClient is an instance of client
 type HubClient struct {
-	session session
+	// mutex serialises conversations with the Home Hub. Each request must carry the next
+	// request id and the current session nonce, so requests cannot be interleaved
+	mutex      sync.Mutex
+	session    session
+	relogins   int32
+	httpClient *http.Client
+	metrics    *Metrics
+	// dumpTraffic logs the bodies of requests and responses
+	dumpTraffic bool
+	// interfaces are the WAN facing interfaces discovered when logging in
+	interfaces           interfaces
+	wanInterfaceOverride int
+	dslChannelOverride   int
+	// bandwidthStartDate is the first day requested from the bandwidth monitoring service. It advances to the
+	// most recently downloaded day, so that only new statistics are retrieved
+	bandwidthStartDate time.Time
 }
 
-// New creates a new client
-func New(url string, userName string, password string) Client {
-	// MD5 hashes are 32 characters long
-	// A plain text password is maximum 20 characters long
-	if len(password) != 32 {
-		password = hexmd5(password)
-	} else {
+// New creates a new client. The url is the base address of the Home Hub, including the scheme. The password is
+// plain text unless WithPasswordFormat specifies that it is an MD5 hash
+func New(url string, userName string, password string, opts ...Option) Client {
+	o := &options{
+		timeout:        DefaultTimeout,
+		passwordFormat: PasswordFormatPlain,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	var passwordHash string
+	if o.passwordFormat == PasswordFormatMD5 {
 		// Make sure the MD5 hash is lowercase
-		password = strings.ToLower(password)
+		passwordHash = strings.ToLower(password)
+	} else {
+		passwordHash = HashPassword([]byte(password))
 	}
 
 	return &HubClient{
+		httpClient:           newHTTPClient(o),
+		metrics:              o.metrics,
+		dumpTraffic:          o.dumpTraffic,
+		interfaces:           defaultInterfaces(),
+		wanInterfaceOverride: o.wanInterface,
+		dslChannelOverride:   o.dslChannel,
+		bandwidthStartDate:   o.bandwidthStartDate,
 		session: session{
 			url:          url,
 			apiURL:       url + "/" + homeHubAPIPath,
 			userName:     userName,
-			password:     password,
+			passwordHash: passwordHash,
 			sessionID:    "0",
 			requestCount: 0,
 		},
@@ -55,7 +98,166 @@ func New(url string, userName string, password string) Client {
 }
 
 // Login authenticates a user against the Home Hub
-func (client *HubClient) Login() *Response {
+func (client *HubClient) Login(ctx context.Context) *Response {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.login(ctx)
+}
+
+// GetSummaryStatistics returns a composite response for various Home Hub metrics. Any additional xpaths are
+// requested in the same batch and are reported unchanged
+func (client *HubClient) GetSummaryStatistics(ctx context.Context, additionalXPaths ...string) *Response {
+	var (
+		flags   *capabilityFlags
+		options *interfaceOptions
+	)
+
+	flags = &capabilityFlags{
+		Interface: true,
+	}
+
+	options = &interfaceOptions{
+		CapabilityFlags: *flags,
+	}
+
+	xpaths := []string{ConnectedDevices, DownloadedBytes, DownloadRate, FirmwareVersion, UploadedBytes, UploadRate, UpTime, IPInterfaces}
+	xpaths = append(xpaths, dslXPaths...)
+	xpaths = append(xpaths, wanXPaths...)
+	xpaths = append(xpaths, wifiXPaths...)
+	summaryXPaths := len(xpaths)
+
+	for _, xpath := range additionalXPaths {
+		if !containsString(xpaths, xpath) {
+			xpaths = append(xpaths, xpath)
+		}
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	actions := make([]action, 0, len(xpaths))
+	for i, xpath := range xpaths {
+		// XPaths for interfaces that do not exist on this Home Hub, such as DSL on FTTP connections, are skipped
+		resolvedXPath, ok := xpath, true
+		if i < summaryXPaths {
+			resolvedXPath, ok = client.interfaces.resolve(xpath)
+		}
+		if !ok {
+			continue
+		}
+
+		getValueAction := action{
+			ID:               i,
+			Method:           "getValue",
+			XPath:            resolvedXPath,
+			InterfaceOptions: options,
+			Parameters:       nil,
+		}
+		actions = append(actions, getValueAction)
+	}
+
+	response := client.sendActions(ctx, actions)
+
+	// XPaths that the Home Hub rejects, such as Wi-Fi values on some firmware, are left out of the reply rather
+	// than failing the whole batch
+	if response.Error != nil && response.actionFailed() {
+		response.Error = nil
+		responseActions := response.ResponseBody.Reply.ResponseActions[:0]
+		for _, responseAction := range response.ResponseBody.Reply.ResponseActions {
+			if isSuccess(responseAction.ReplyError.Description) {
+				responseActions = append(responseActions, responseAction)
+			}
+		}
+		response.ResponseBody.Reply.ResponseActions = responseActions
+	}
+
+	// Report values against the XPath constants, regardless of which interface they were fetched from
+	if response.ResponseBody.Reply != nil {
+		for _, responseAction := range response.ResponseBody.Reply.ResponseActions {
+			if responseAction.ID >= 0 && responseAction.ID < len(xpaths) {
+				for i := range responseAction.ResponseCallbacks {
+					responseAction.ResponseCallbacks[i].XPath = xpaths[responseAction.ID]
+				}
+			}
+		}
+	}
+
+	return response
+}
+
+// GetBandwidthStatistics returns a response containing a summary of bandwidth statistics for any devices
+// that have connected to the Home Hub. The first call returns all available statistics. Subsequent calls
+// only return statistics from the day of the previous call onwards, since the current day's figures may
+// still change
+func (client *HubClient) GetBandwidthStatistics(ctx context.Context) *Response {
+
+	var (
+		options *interfaceOptions
+		params  *Parameters
+	)
+
+	var actions []action
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	now := time.Now()
+	startDate := client.bandwidthStartDate
+	if startDate.IsZero() {
+		startDate = bandwidthMonitoringEpoch
+	}
+
+	params = &Parameters{
+		StartDate: startDate.Format(bandwidthDateFormat),
+		EndDate:   now.Format(bandwidthDateFormat),
+	}
+
+	getValueAction := action{
+		ID:               0,
+		Method:           "uploadBMStatisticsFile",
+		XPath:            BandwidthMonitoring,
+		InterfaceOptions: options,
+		Parameters:       params,
+	}
+	actions = append(actions, getValueAction)
+
+	response := client.sendActions(ctx, actions)
+	if response.Error != nil {
+		return response
+	}
+
+	callback, err := response.firstCallback()
+	if err != nil {
+		response.Error = fmt.Errorf("error requesting bandwidth statistics: %w", err)
+		return response
+	}
+
+	vo := reflect.ValueOf(callback.Parameters.Data)
+
+	statisticsDownloadRequest := request{
+		session:    client.session,
+		httpClient: client.httpClient,
+		metrics:    client.metrics,
+		dump:       client.dumpTraffic,
+		method:     "GET",
+		url:        fmt.Sprintf("%s/%s", client.session.url, vo.String()),
+	}
+
+	response = statisticsDownloadRequest.send(ctx)
+	if response.Error == nil {
+		client.bandwidthStartDate = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	}
+
+	return response
+}
+
+// Relogins returns the number of times the client has had to log in again after the Home Hub session expired
+func (client *HubClient) Relogins() int {
+	return int(atomic.LoadInt32(&client.relogins))
+}
+
+// login starts a new Home Hub session. The caller must hold the client mutex
+func (client *HubClient) login(ctx context.Context) *Response {
 	newNss := newNss()
 	var nssOptions []nss
 	nssOptions = append(nssOptions, *newNss)
@@ -96,107 +298,69 @@ func (client *HubClient) Login() *Response {
 	var actions []action
 	actions = append(actions, loginAction)
 
-	request := request{
-		Body:    newRequestBody(client.session, actions),
-		session: client.session,
-		method:  "POST",
-		url:     client.session.apiURL,
+	client.session.sessionID = "0"
+	client.session.nonce = ""
+	client.session.requestCount = 0
+
+	response := client.newActionRequest(actions).send(ctx)
+	var callback *ResponseCallback
+	if response.Error == nil {
+		var err error
+		if callback, err = response.firstCallback(); err != nil {
+			response.Error = fmt.Errorf("error logging in: %w", err)
+		}
 	}
 
-	response := request.send()
+	client.metrics.login(response.Error)
 	if response.Error == nil {
-		responseParams := response.ResponseBody.Reply.ResponseActions[0].ResponseCallbacks[0].Parameters
+		responseParams := callback.Parameters
 		client.session.sessionID = strconv.Itoa(responseParams.ID)
 		client.session.nonce = responseParams.Nonce
+		client.discoverInterfaces(ctx)
 	}
 
 	return response
 }
 
-// GetSummaryStatistics returns a composite response for various Home Hub metrics
-func (client *HubClient) GetSummaryStatistics() *Response {
-	var (
-		flags   *capabilityFlags
-		options *interfaceOptions
-	)
-
+// sendActions sends the given actions to the Home Hub. If the Home Hub rejects the request because the
+// session has expired, the client logs in again and the actions are retried once with the new session.
+// The caller must hold the client mutex
+func (client *HubClient) sendActions(ctx context.Context, actions []action) *Response {
 	client.session.requestCount++
-
-	flags = &capabilityFlags{
-		Interface: true,
+	response := client.newActionRequest(actions).send(ctx)
+	if !response.authenticationFailed() {
+		return response
 	}
 
-	options = &interfaceOptions{
-		CapabilityFlags: *flags,
-	}
+	slog.InfoContext(ctx, "Home Hub session expired. Logging in again")
 
-	xpaths := []string{ConnectedDevices, DownloadedBytes, DownloadRate, FirmwareVersion, UploadedBytes, UploadRate, UpTime}
-	actions := make([]action, 0, len(xpaths))
-
-	for i, xpath := range xpaths {
-		getValueAction := action{
-			ID:               i,
-			Method:           "getValue",
-			XPath:            xpath,
-			InterfaceOptions: options,
-			Parameters:       nil,
-		}
-		actions = append(actions, getValueAction)
+	loginResponse := client.login(ctx)
+	if loginResponse.Error != nil {
+		return loginResponse
 	}
+	atomic.AddInt32(&client.relogins, 1)
 
-	request := request{
-		Body:    newRequestBody(client.session, actions),
-		session: client.session,
-		method:  "POST",
-		url:     client.session.apiURL,
-	}
-
-	return request.send()
+	client.session.requestCount++
+	return client.newActionRequest(actions).send(ctx)
 }
 
-// GetBandwidthStatistics returns a response containing a summary of bandwidth statistics for any devices
-// that have connected to the Home Hub
-func (client *HubClient) GetBandwidthStatistics() *Response {
-
-	var (
-		options *interfaceOptions
-		params  *Parameters
-	)
-
-	atomic.AddInt32(&client.session.requestCount, 1)
-
-	var actions []action
-
-	now := time.Now()
-	params = &Parameters{
-		StartDate: "20000101",
-		EndDate:   fmt.Sprintf("%d%02d%02d", now.Year(), now.Month(), now.Day()),
-	}
-
-	getValueAction := action{
-		ID:               0,
-		Method:           "uploadBMStatisticsFile",
-		XPath:            BandwidthMonitoring,
-		InterfaceOptions: options,
-		Parameters:       params,
+func (client *HubClient) newActionRequest(actions []action) request {
+	return request{
+		Body:       newRequestBody(client.session, actions),
+		session:    client.session,
+		httpClient: client.httpClient,
+		metrics:    client.metrics,
+		dump:       client.dumpTraffic,
+		method:     "POST",
+		url:        client.session.apiURL,
 	}
-	actions = append(actions, getValueAction)
-
-	statisticsFileRequest := request{
-		Body:    newRequestBody(client.session, actions),
-		session: client.session,
-		method:  "POST",
-		url:     client.session.apiURL,
-	}
-
-	response := statisticsFileRequest.send()
-	vo := reflect.ValueOf(response.ResponseBody.Reply.ResponseActions[0].ResponseCallbacks[0].Parameters.Data)
+}
 
-	statisticsDownloadRequest := request{
-		session: client.session,
-		method:  "GET",
-		url:     fmt.Sprintf("%s/%s", client.session.url, vo.String()),
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
 	}
-
-	return statisticsDownloadRequest.send()
+	return false
 }
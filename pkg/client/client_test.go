@@ -0,0 +1,404 @@
+package client
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/hubtest"
+)
+
+// newHub starts a fake Home Hub that accepts the credentials used by the tests
+func newHub(opts ...hubtest.Option) (*hubtest.Hub, *httptest.Server) {
+	return hubtest.NewServer(append([]hubtest.Option{hubtest.WithCredentials("admin", "secret")}, opts...)...)
+}
+
+// checkProtocol fails the test if the hub received a request that a real Home Hub would have rejected
+func checkProtocol(t *testing.T, hub *hubtest.Hub) {
+	for _, err := range hub.ProtocolErrors() {
+		t.Error(err)
+	}
+}
+
+// requestedXPaths returns the XPaths of the getValue actions, one per line
+func requestedXPaths(actions []hubtest.Action) string {
+	var xpaths []string
+	for _, action := range actions {
+		if action.Method == "getValue" {
+			xpaths = append(xpaths, action.XPath)
+		}
+	}
+	return strings.Join(xpaths, "\n")
+}
+
+// bandwidthStartDates returns the start date of each request for the bandwidth monitoring file
+func bandwidthStartDates(actions []hubtest.Action) []string {
+	var startDates []string
+	for _, action := range actions {
+		if action.Method == "uploadBMStatisticsFile" {
+			startDates = append(startDates, action.StartDate)
+		}
+	}
+	return startDates
+}
+
+// useFTTPInterfaces replaces the interfaces of the hub with those of a Home Hub connected over FTTP. The WAN
+// connection moves to IP interface 5 and PPP interface 2, and there are no DSL channels
+func useFTTPInterfaces(t *testing.T, hub *hubtest.Hub) {
+	wan, _ := hub.Value("Device/IP/Interfaces/Interface[@uid='3']")
+	ppp, _ := hub.Value("Device/PPP/Interfaces/Interface[@uid='1']")
+	if wan == nil || ppp == nil {
+		t.Fatal("Expected the default data model to have a WAN connection")
+	}
+
+	wanInterface, pppInterface := wan.(map[string]interface{}), ppp.(map[string]interface{})
+	wanInterface["uid"], wanInterface["Alias"], wanInterface["LowerLayers"] = 5, "IP_DATA_FTTP", "Device.PPP.Interface.2"
+	pppInterface["uid"], pppInterface["LowerLayers"] = 2, "Device.Ethernet.VLANTermination.1"
+
+	interfaces := []interface{}{
+		map[string]interface{}{"uid": 1, "Alias": "IP_BR_LAN", "Enable": true, "LowerLayers": "Device.Ethernet.Link.1"},
+		map[string]interface{}{"uid": 3, "Alias": "IP_DATA", "Enable": false, "LowerLayers": "Device.Ethernet.VLANTermination.1"},
+		wanInterface,
+	}
+
+	for xpath, value := range map[string]interface{}{IPInterfaces: interfaces, "Device/PPP/Interfaces": []interface{}{pppInterface}, dslChannels: []interface{}{}} {
+		if err := hub.SetValue(xpath, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReloginAfterSessionExpiry(t *testing.T) {
+	hub, server := newHub()
+	defer server.Close()
+
+	homehub := New(server.URL, "admin", "secret")
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	hub.ExpireSession()
+
+	response := homehub.GetSummaryStatistics(context.Background())
+	if response.Error != nil {
+		t.Fatalf("Expected summary statistics to succeed after relogin. Got error: %s", response.Error)
+	}
+
+	expectedActions := 8 + len(dslXPaths) + len(wanXPaths) + len(wifiXPaths)
+	if len(response.ResponseBody.Reply.ResponseActions) != expectedActions {
+		t.Fatalf("Expected %d response actions. Got %d", expectedActions, len(response.ResponseBody.Reply.ResponseActions))
+	}
+
+	if homehub.Relogins() != 1 {
+		t.Fatalf("Expected 1 relogin. Got %d", homehub.Relogins())
+	}
+
+	if hub.Logins() != 2 {
+		t.Fatalf("Expected 2 logins. Got %d", hub.Logins())
+	}
+
+	checkProtocol(t, hub)
+}
+
+func TestInterfaceDiscovery(t *testing.T) {
+	hub, server := newHub()
+	defer server.Close()
+
+	useFTTPInterfaces(t, hub)
+
+	homehub := New(server.URL, "admin", "secret")
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	discoveryActions := len(hub.Actions())
+
+	response := homehub.GetSummaryStatistics(context.Background())
+	if response.Error != nil {
+		t.Fatalf("Summary statistics failed: %s", response.Error)
+	}
+
+	// The download and upload rates are DSL channel values, so are not requested either
+	expectedActions := 6 + len(wanXPaths) + len(wifiXPaths)
+	if len(response.ResponseBody.Reply.ResponseActions) != expectedActions {
+		t.Fatalf("Expected %d response actions. Got %d", expectedActions, len(response.ResponseBody.Reply.ResponseActions))
+	}
+
+	requested := requestedXPaths(hub.Actions()[discoveryActions:])
+	if strings.Contains(requested, "Device/DSL/") {
+		t.Fatalf("Expected no DSL XPaths to be requested. Got:\n%s", requested)
+	}
+
+	if !strings.Contains(requested, "Device/IP/Interfaces/Interface[@uid='5']/IPv4Addresses") {
+		t.Fatalf("Expected WAN XPaths to use the discovered interface. Got:\n%s", requested)
+	}
+
+	if !strings.Contains(requested, "Device/PPP/Interfaces/Interface[@uid='2']/ConnectionStatus") {
+		t.Fatalf("Expected PPP XPaths to use the discovered interface. Got:\n%s", requested)
+	}
+
+	for _, responseAction := range response.ResponseBody.Reply.ResponseActions {
+		xpath := responseAction.ResponseCallbacks[0].XPath
+		if xpath == WANIPv4Address {
+			return
+		}
+	}
+	t.Fatalf("Expected response values to be reported against %s", WANIPv4Address)
+}
+
+func TestInterfaceDiscoveryWithoutReply(t *testing.T) {
+	hub := hubtest.New(hubtest.WithCredentials("admin", "secret"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.FormValue("req"), IPInterfaces) {
+			w.Header().Set("Content-Type", "text/html")
+			//nolint:golint,errcheck
+			w.Write([]byte("<html><body>Proxy error</body></html>"))
+			return
+		}
+		hub.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	homehub := New(server.URL, "admin", "secret")
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	if interfaces := homehub.(*HubClient).interfaces; interfaces != defaultInterfaces() {
+		t.Fatalf("Expected the default interfaces to be used. Got %+v", interfaces)
+	}
+}
+
+func TestInterfaceOverrides(t *testing.T) {
+	hub, server := newHub()
+	defer server.Close()
+
+	homehub := New(server.URL, "admin", "secret", WithWANInterface(7), WithDSLChannel(2))
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	if response := homehub.GetSummaryStatistics(context.Background()); response.Error != nil {
+		t.Fatalf("Summary statistics failed: %s", response.Error)
+	}
+
+	requested := requestedXPaths(hub.Actions())
+
+	if !strings.Contains(requested, "Device/IP/Interfaces/Interface[@uid='7']/") {
+		t.Fatalf("Expected WAN XPaths to use interface 7. Got:\n%s", requested)
+	}
+
+	if !strings.Contains(requested, "Device/DSL/Channels/Channel[@uid='2']/") {
+		t.Fatalf("Expected DSL XPaths to use channel 2. Got:\n%s", requested)
+	}
+}
+
+func TestAdditionalXPaths(t *testing.T) {
+	hub, server := newHub()
+	defer server.Close()
+
+	homehub := New(server.URL, "admin", "secret")
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	response := homehub.GetSummaryStatistics(context.Background(), "Device/DeviceInfo/ModelName", UpTime)
+	if response.Error != nil {
+		t.Fatalf("Summary statistics failed: %s", response.Error)
+	}
+
+	expectedActions := 9 + len(dslXPaths) + len(wanXPaths) + len(wifiXPaths)
+	if len(response.ResponseBody.Reply.ResponseActions) != expectedActions {
+		t.Fatalf("Expected %d response actions. Got %d", expectedActions, len(response.ResponseBody.Reply.ResponseActions))
+	}
+
+	requested := requestedXPaths(hub.Actions())
+
+	if !strings.Contains(requested, "Device/DeviceInfo/ModelName") {
+		t.Fatalf("Expected additional XPath to be requested. Got:\n%s", requested)
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	hub, server := newHub()
+	defer server.Close()
+
+	homehub := New(server.URL, "admin", "secret")
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	var wg sync.WaitGroup
+	responses := make(chan *Response, 40)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			responses <- homehub.GetSummaryStatistics(context.Background())
+		}()
+		go func() {
+			defer wg.Done()
+			responses <- homehub.GetBandwidthStatistics(context.Background())
+		}()
+
+		if i == 10 {
+			hub.ExpireSession()
+		}
+	}
+
+	wg.Wait()
+	close(responses)
+
+	for response := range responses {
+		if response.Error != nil {
+			t.Fatalf("Unexpected error: %s", response.Error)
+		}
+	}
+
+	checkProtocol(t, hub)
+
+	if homehub.Relogins() != 1 {
+		t.Fatalf("Expected 1 relogin. Got %d", homehub.Relogins())
+	}
+}
+
+func TestRequestCancellation(t *testing.T) {
+	hub, server := newHub()
+	defer server.Close()
+
+	homehub := New(server.URL, "admin", "secret")
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	hub.SetLatency(time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	response := homehub.GetSummaryStatistics(ctx)
+	if !errors.Is(response.Error, context.DeadlineExceeded) {
+		t.Fatalf("Expected context deadline exceeded error. Got: %v", response.Error)
+	}
+
+	response = homehub.GetBandwidthStatistics(ctx)
+	if response.Error == nil || !strings.Contains(response.Error.Error(), context.DeadlineExceeded.Error()) {
+		t.Fatalf("Expected context deadline exceeded error. Got: %v", response.Error)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	hub, server := newHub()
+	defer server.Close()
+
+	hub.SetLatency(time.Second)
+
+	homehub := New(server.URL, "admin", "secret", WithTimeout(50*time.Millisecond))
+	response := homehub.Login(context.Background())
+	if response.Error == nil || !strings.Contains(response.Error.Error(), "Timeout") {
+		t.Fatalf("Expected timeout error. Got: %v", response.Error)
+	}
+}
+
+func TestHTTPSWithCustomCA(t *testing.T) {
+	server := httptest.NewTLSServer(hubtest.New(hubtest.WithCredentials("admin", "secret")))
+	defer server.Close()
+
+	caFile, err := ioutil.TempFile("", "homehub-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(caFile.Name())
+
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if _, err := caFile.Write(certificate); err != nil {
+		t.Fatal(err)
+	}
+	caFile.Close()
+
+	homehub := New(server.URL, "admin", "secret")
+	if response := homehub.Login(context.Background()); response.Error == nil {
+		t.Fatal("Expected login to fail with an untrusted certificate")
+	}
+
+	tlsConfig, err := NewTLSConfig(caFile.Name(), false)
+	if err != nil {
+		t.Fatalf("Unable to create TLS config: %s", err)
+	}
+
+	homehub = New(server.URL, "admin", "secret", WithTLSConfig(tlsConfig))
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+}
+
+func TestIncrementalBandwidthStatistics(t *testing.T) {
+	hub, server := newHub()
+	defer server.Close()
+
+	homehub := New(server.URL, "admin", "secret")
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	for i := 0; i < 2; i++ {
+		if response := homehub.GetBandwidthStatistics(context.Background()); response.Error != nil {
+			t.Fatalf("Unexpected error: %s", response.Error)
+		}
+	}
+
+	today := time.Now().Format(bandwidthDateFormat)
+	expected := []string{"20000101", today}
+	if startDates := bandwidthStartDates(hub.Actions()); strings.Join(startDates, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected bandwidth start dates %v. Got %v", expected, startDates)
+	}
+}
+
+func TestBandwidthStatisticsStartDate(t *testing.T) {
+	hub, server := newHub()
+	defer server.Close()
+
+	// A client created after a restart resumes from the last day kept by the previous run
+	homehub := New(server.URL, "admin", "secret", WithBandwidthStartDate(time.Date(2016, time.December, 31, 0, 0, 0, 0, time.UTC)))
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	if response := homehub.GetBandwidthStatistics(context.Background()); response.Error != nil {
+		t.Fatalf("Unexpected error: %s", response.Error)
+	}
+
+	expected := []string{"20161231"}
+	if startDates := bandwidthStartDates(hub.Actions()); strings.Join(startDates, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected bandwidth start dates %v. Got %v", expected, startDates)
+	}
+}
+
+func TestNonJSONReply(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		//nolint:golint,errcheck
+		w.Write([]byte("<html><body>Please log in</body></html>"))
+	}))
+	defer server.Close()
+
+	homehub := New(server.URL, "admin", "secret")
+	response := homehub.Login(context.Background())
+	if response.Error == nil || !strings.Contains(response.Error.Error(), "no reply returned by the Home Hub") {
+		t.Fatalf("Expected login to fail without a reply. Got: %v", response.Error)
+	}
+
+	response = homehub.GetBandwidthStatistics(context.Background())
+	if response.Error == nil || !strings.Contains(response.Error.Error(), "no reply returned by the Home Hub") {
+		t.Fatalf("Expected bandwidth statistics to fail without a reply. Got: %v", response.Error)
+	}
+}
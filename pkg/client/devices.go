@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Name returns the most descriptive name known for the device, preferring the name set by the user
+func (d DeviceDetail) Name() string {
+	switch {
+	case d.UserHostName != "":
+		return d.UserHostName
+	case d.HostName != "":
+		return d.HostName
+	default:
+		return d.Alias
+	}
+}
+
+// MACAddress returns the upper case MAC address of the device
+func (d DeviceDetail) MACAddress() string {
+	return strings.ToUpper(d.PhysicalAddress)
+}
+
+// DecodeDevices decodes the value returned by the Home Hub for the ConnectedDevices XPath. Host entries that
+// cannot be decoded are skipped and counted in the returned number of malformed entries. An error is returned
+// if the value is not a list of hosts
+func DecodeDevices(value interface{}) ([]DeviceDetail, int, error) {
+	var entries []json.RawMessage
+	if err := decodeValue(value, &entries); err != nil {
+		return nil, 0, err
+	}
+
+	devices := make([]DeviceDetail, 0, len(entries))
+	malformed := 0
+	for _, entry := range entries {
+		var device DeviceDetail
+		if err := json.Unmarshal(entry, &device); err != nil || device.PhysicalAddress == "" {
+			malformed++
+			continue
+		}
+		devices = append(devices, device)
+	}
+	return devices, malformed, nil
+}
+
+// GetDevices returns the devices that have connected to the Home Hub. Malformed host entries are skipped
+func (client *HubClient) GetDevices(ctx context.Context) ([]DeviceDetail, error) {
+	actions := []action{
+		{
+			ID:               0,
+			Method:           "getValue",
+			XPath:            ConnectedDevices,
+			InterfaceOptions: &interfaceOptions{CapabilityFlags: capabilityFlags{Interface: true}},
+		},
+	}
+
+	client.mutex.Lock()
+	response := client.sendActions(ctx, actions)
+	client.mutex.Unlock()
+
+	if response.Error != nil {
+		return nil, response.Error
+	}
+
+	reply := response.ResponseBody.Reply
+	if reply == nil || len(reply.ResponseActions) == 0 || len(reply.ResponseActions[0].ResponseCallbacks) == 0 {
+		return nil, fmt.Errorf("no value returned for %s", ConnectedDevices)
+	}
+
+	devices, _, err := DecodeDevices(reply.ResponseActions[0].ResponseCallbacks[0].Parameters.Value)
+	return devices, err
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodeDevices(t *testing.T) {
+	var value interface{}
+	//nolint:golint,errcheck
+	json.Unmarshal([]byte(`[
+		{"uid": 1, "PhysAddress": "aa:bb:cc:dd:ee:f1", "HostName": null, "Alias": "Laptop", "Active": true, "LeaseTimeRemaining": "3600"},
+		{"uid": 2, "PhysAddress": "AA:BB:CC:DD:EE:F2", "Active": "yes"},
+		{"uid": 3, "HostName": "No MAC address"},
+		null,
+		"not a host",
+		{"uid": 4, "PhysAddress": "AA:BB:CC:DD:EE:F4", "HostName": "phone", "UserHostName": "My Phone"}
+	]`), &value)
+
+	devices, malformed, err := DecodeDevices(value)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if malformed != 4 {
+		t.Fatalf("Expected 4 malformed devices. Got %d", malformed)
+	}
+
+	if len(devices) != 2 {
+		t.Fatalf("Expected 2 devices. Got %d", len(devices))
+	}
+
+	if devices[0].MACAddress() != "AA:BB:CC:DD:EE:F1" || devices[0].Name() != "Laptop" || !devices[0].Active || devices[0].LeaseTimeRemaining != 3600 {
+		t.Fatalf("Unexpected device: %+v", devices[0])
+	}
+
+	if devices[1].Name() != "My Phone" {
+		t.Fatalf("Expected user host name to be preferred. Got %s", devices[1].Name())
+	}
+
+	if _, _, err := DecodeDevices("Device/Hosts/Hosts"); err == nil {
+		t.Fatal("Expected error decoding a value that is not a list of hosts")
+	}
+}
+
+func TestGetDevices(t *testing.T) {
+	hub, server := newHub()
+	defer server.Close()
+
+	hosts := []map[string]interface{}{
+		{"uid": 1, "PhysAddress": "AA:BB:CC:DD:EE:F1", "HostName": "laptop", "Active": true},
+		{"uid": 2, "PhysAddress": "AA:BB:CC:DD:EE:F2", "Active": 1},
+	}
+	if err := hub.SetValue(ConnectedDevices, hosts); err != nil {
+		t.Fatal(err)
+	}
+
+	homehub := New(server.URL, "admin", "secret")
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	devices, err := homehub.GetDevices(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(devices) != 1 || devices[0].HostName != "laptop" {
+		t.Fatalf("Unexpected devices: %+v", devices)
+	}
+}
+
+func FuzzDecodeDevices(f *testing.F) {
+	f.Add([]byte(`[{"uid": 1, "PhysAddress": "AA:BB:CC:DD:EE:F1", "HostName": "laptop", "Active": true, "InterfaceType": "WiFi"}]`))
+	f.Add([]byte(`[{"PhysAddress": null, "Active": null}, null, 1, "x", []]`))
+	f.Add([]byte(`{"PhysAddress": "AA:BB:CC:DD:EE:F1"}`))
+	f.Add([]byte(`[{"PhysAddress": "a", "LeaseTimeRemaining": "soon"}]`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return
+		}
+
+		devices, malformed, err := DecodeDevices(value)
+		if err != nil {
+			return
+		}
+
+		entries, _ := value.([]interface{})
+		if len(devices)+malformed != len(entries) {
+			t.Fatalf("Expected %d decoded and malformed devices. Got %d and %d", len(entries), len(devices), malformed)
+		}
+
+		for _, device := range devices {
+			if device.PhysicalAddress == "" {
+				t.Fatalf("Decoded device without a MAC address: %+v", device)
+			}
+			device.Name()
+		}
+	})
+}
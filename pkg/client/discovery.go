@@ -0,0 +1,266 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The interface uids that the XPath constants refer to. These are correct for ADSL / VDSL Home Hubs
+const (
+	defaultWANInterface = 3
+	defaultPPPInterface = 1
+	defaultDSLChannel   = 1
+	defaultDSLLine      = 1
+)
+
+var (
+	wanInterfacePrefix = fmt.Sprintf("Device/IP/Interfaces/Interface[@uid='%d']", defaultWANInterface)
+	pppInterfacePrefix = fmt.Sprintf("Device/PPP/Interfaces/Interface[@uid='%d']", defaultPPPInterface)
+	dslChannelPrefix   = fmt.Sprintf("Device/DSL/Channels/Channel[@uid='%d']", defaultDSLChannel)
+	dslLinePrefix      = fmt.Sprintf("Device/DSL/Lines/Line[@uid='%d']", defaultDSLLine)
+	lowerLayerPattern  = regexp.MustCompile(`^Device\.(PPP\.Interface|DSL\.Channel|DSL\.Line|WiFi\.Radio|WiFi\.SSID)\.(\d+)`)
+)
+
+// IPInterface represents an entry from the Home Hub Device/IP/Interfaces table
+type IPInterface struct {
+	UID         int              `json:"uid"`
+	Alias       string           `json:"Alias"`
+	Name        string           `json:"Name"`
+	Status      string           `json:"Status"`
+	Enable      bool             `json:"Enable"`
+	Loopback    bool             `json:"Loopback"`
+	LowerLayers string           `json:"LowerLayers"`
+	Stats       IPInterfaceStats `json:"Stats"`
+}
+
+// IPInterfaceStats holds the traffic counters of an IP interface
+type IPInterfaceStats struct {
+	BytesSent       Counter `json:"BytesSent"`
+	BytesReceived   Counter `json:"BytesReceived"`
+	PacketsSent     Counter `json:"PacketsSent"`
+	PacketsReceived Counter `json:"PacketsReceived"`
+	ErrorsSent      Counter `json:"ErrorsSent"`
+	ErrorsReceived  Counter `json:"ErrorsReceived"`
+}
+
+// Label returns a human readable name for the interface
+func (i IPInterface) Label() string {
+	switch {
+	case i.Alias != "":
+		return i.Alias
+	case i.Name != "":
+		return i.Name
+	default:
+		return strconv.Itoa(i.UID)
+	}
+}
+
+type dslChannel struct {
+	UID         int    `json:"uid"`
+	Status      string `json:"Status"`
+	LowerLayers string `json:"LowerLayers"`
+}
+
+// Counter is a numeric value that the Home Hub may encode as either a JSON number or a string
+type Counter float64
+
+// UnmarshalJSON decodes a counter from a JSON number or string
+func (c *Counter) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+	if value == "" || value == "null" {
+		*c = 0
+		return nil
+	}
+
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	*c = Counter(floatValue)
+	return nil
+}
+
+// DecodeIPInterfaces decodes the value returned by the Home Hub for the IPInterfaces XPath
+func DecodeIPInterfaces(value interface{}) ([]IPInterface, error) {
+	var ipInterfaces []IPInterface
+	err := decodeValue(value, &ipInterfaces)
+	return ipInterfaces, err
+}
+
+func decodeValue(value interface{}, target interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+// interfaces holds the uids of the interfaces that metrics are collected from. A uid of 0 means the
+// interface does not exist on the Home Hub
+type interfaces struct {
+	wanInterface int
+	pppInterface int
+	dslChannel   int
+	dslLine      int
+}
+
+func defaultInterfaces() interfaces {
+	return interfaces{
+		wanInterface: defaultWANInterface,
+		pppInterface: defaultPPPInterface,
+		dslChannel:   defaultDSLChannel,
+		dslLine:      defaultDSLLine,
+	}
+}
+
+// resolve rewrites an XPath constant so that it refers to the discovered interfaces. It returns false
+// if the XPath refers to an interface that does not exist on the Home Hub
+func (i interfaces) resolve(xpath string) (string, bool) {
+	prefixes := []struct {
+		prefix string
+		uid    int
+		format string
+	}{
+		{wanInterfacePrefix, i.wanInterface, "Device/IP/Interfaces/Interface[@uid='%d']"},
+		{pppInterfacePrefix, i.pppInterface, "Device/PPP/Interfaces/Interface[@uid='%d']"},
+		{dslChannelPrefix, i.dslChannel, "Device/DSL/Channels/Channel[@uid='%d']"},
+		{dslLinePrefix, i.dslLine, "Device/DSL/Lines/Line[@uid='%d']"},
+	}
+
+	for _, p := range prefixes {
+		if strings.HasPrefix(xpath, p.prefix) {
+			if p.uid == 0 {
+				return "", false
+			}
+			return fmt.Sprintf(p.format, p.uid) + strings.TrimPrefix(xpath, p.prefix), true
+		}
+	}
+	return xpath, true
+}
+
+// discoverInterfaces enumerates the IP interfaces and DSL channels of the Home Hub and chooses the ones
+// facing the internet. The caller must hold the client mutex
+func (client *HubClient) discoverInterfaces(ctx context.Context) {
+	discovered := defaultInterfaces()
+
+	actions := []action{
+		{ID: 0, Method: "getValue", XPath: IPInterfaces},
+		{ID: 1, Method: "getValue", XPath: dslChannels},
+	}
+
+	client.session.requestCount++
+	response := client.newActionRequest(actions).send(ctx)
+	if response.Error != nil {
+		slog.WarnContext(ctx, "Unable to discover Home Hub interfaces, using defaults", "err", response.Error)
+	} else if response.ResponseBody.Reply == nil {
+		// The Home Hub did not reply with JSON, for example because a login page or proxy error was returned
+		slog.WarnContext(ctx, "Unable to discover Home Hub interfaces, using defaults", "err", "no reply returned by the Home Hub")
+	} else {
+		for _, responseAction := range response.ResponseBody.Reply.ResponseActions {
+			if len(responseAction.ResponseCallbacks) == 0 {
+				continue
+			}
+
+			value := responseAction.ResponseCallbacks[0].Parameters.Value
+			switch responseAction.ID {
+			case 0:
+				ipInterfaces, err := DecodeIPInterfaces(value)
+				if err != nil {
+					slog.WarnContext(ctx, "Unable to decode Home Hub IP interfaces", "err", err)
+					continue
+				}
+				chooseWANInterface(ipInterfaces, &discovered)
+			case 1:
+				var channels []dslChannel
+				if err := decodeValue(value, &channels); err != nil {
+					slog.WarnContext(ctx, "Unable to decode Home Hub DSL channels", "err", err)
+					continue
+				}
+				chooseDSLChannel(channels, &discovered)
+			}
+		}
+	}
+
+	if client.wanInterfaceOverride > 0 {
+		discovered.wanInterface = client.wanInterfaceOverride
+	}
+
+	if client.dslChannelOverride > 0 {
+		discovered.dslChannel = client.dslChannelOverride
+		if discovered.dslLine == 0 {
+			discovered.dslLine = defaultDSLLine
+		}
+	}
+
+	client.interfaces = discovered
+}
+
+// chooseWANInterface picks the IP interface carried over PPP, as used by BT for both DSL and FTTP
+// connections. Otherwise the first enabled, non loopback interface that is not part of the LAN is chosen
+func chooseWANInterface(ipInterfaces []IPInterface, discovered *interfaces) {
+	var candidate *IPInterface
+	for i := range ipInterfaces {
+		ipInterface := &ipInterfaces[i]
+		if ipInterface.Loopback || isLANInterface(ipInterface) {
+			continue
+		}
+
+		if kind, uid := lowerLayer(ipInterface.LowerLayers); kind == "PPP.Interface" {
+			discovered.wanInterface = ipInterface.UID
+			discovered.pppInterface = uid
+			return
+		}
+
+		if candidate == nil && ipInterface.Enable {
+			candidate = ipInterface
+		}
+	}
+
+	if candidate != nil {
+		discovered.wanInterface = candidate.UID
+		discovered.pppInterface = 0
+	}
+}
+
+// chooseDSLChannel picks the DSL channel that is up, or the first channel if none are up
+func chooseDSLChannel(channels []dslChannel, discovered *interfaces) {
+	if len(channels) == 0 {
+		discovered.dslChannel = 0
+		discovered.dslLine = 0
+		return
+	}
+
+	chosen := channels[0]
+	for _, channel := range channels {
+		if channel.Status == "Up" {
+			chosen = channel
+			break
+		}
+	}
+
+	discovered.dslChannel = chosen.UID
+	if kind, uid := lowerLayer(chosen.LowerLayers); kind == "DSL.Line" {
+		discovered.dslLine = uid
+	}
+}
+
+func isLANInterface(ipInterface *IPInterface) bool {
+	name := strings.ToUpper(ipInterface.Alias + " " + ipInterface.Name)
+	return strings.Contains(name, "LAN")
+}
+
+// lowerLayer parses the first entry of a TR-181 LowerLayers value such as Device.PPP.Interface.1
+func lowerLayer(lowerLayers string) (string, int) {
+	match := lowerLayerPattern.FindStringSubmatch(strings.TrimSpace(lowerLayers))
+	if match == nil {
+		return "", 0
+	}
+
+	uid, _ := strconv.Atoi(match[2])
+	return match[1], uid
+}
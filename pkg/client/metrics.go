@@ -0,0 +1,128 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Request methods reported for requests that are not JSON-RPC actions
+const (
+	downloadMethod = "download"
+	unknownMethod  = "unknown"
+)
+
+// Error reasons reported for requests that did not receive a valid reply from the Home Hub. Errors returned in
+// a reply are reported by their description, such as XMO_INVALID_SESSION_ERR
+const (
+	transportErrorReason = "transport"
+	readErrorReason      = "read"
+	decodeErrorReason    = "json_decode"
+)
+
+// Metrics instruments the requests made by a client to the Home Hub. It is a prometheus.Collector, so that it
+// can be reported alongside the Home Hub metrics. A nil Metrics records nothing
+type Metrics struct {
+	requestDuration *prometheus.HistogramVec
+	requestErrors   *prometheus.CounterVec
+	logins          *prometheus.CounterVec
+	sessionAge      prometheus.GaugeFunc
+
+	mutex        sync.Mutex
+	sessionStart time.Time
+}
+
+// NewMetrics creates the metrics for a single client
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    prometheus.BuildFQName("bt", "homehub", "client_request_duration_seconds"),
+			Help:    "Duration of requests to the router",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+		}, []string{"method"}),
+		requestErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName("bt", "homehub", "client_request_errors_total"),
+			Help: "Number of requests to the router that failed",
+		}, []string{"reason"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName("bt", "homehub", "client_logins_total"),
+			Help: "Number of attempts to log in to the router",
+		}, []string{"result"}),
+	}
+
+	m.sessionAge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: prometheus.BuildFQName("bt", "homehub", "client_session_age_seconds"),
+		Help: "Time since the current router session was started",
+	}, m.currentSessionAge)
+	return m
+}
+
+// Describe implements prometheus.Collector
+func (m *Metrics) Describe(channel chan<- *prometheus.Desc) {
+	m.requestDuration.Describe(channel)
+	m.requestErrors.Describe(channel)
+	m.logins.Describe(channel)
+	m.sessionAge.Describe(channel)
+}
+
+// Collect implements prometheus.Collector
+func (m *Metrics) Collect(channel chan<- prometheus.Metric) {
+	m.requestDuration.Collect(channel)
+	m.requestErrors.Collect(channel)
+	m.logins.Collect(channel)
+	m.sessionAge.Collect(channel)
+}
+
+func (m *Metrics) observeRequest(method string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requestDuration.WithLabelValues(method).Observe(duration.Seconds())
+}
+
+func (m *Metrics) requestError(reason string) {
+	if m == nil {
+		return
+	}
+	m.requestErrors.WithLabelValues(reason).Inc()
+}
+
+// login records a login attempt. A successful login starts a new session
+func (m *Metrics) login(err error) {
+	if m == nil {
+		return
+	}
+
+	if err != nil {
+		m.logins.WithLabelValues("failure").Inc()
+		return
+	}
+	m.logins.WithLabelValues("success").Inc()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sessionStart = time.Now()
+}
+
+func (m *Metrics) currentSessionAge() float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.sessionStart.IsZero() {
+		return 0
+	}
+	return time.Since(m.sessionStart).Seconds()
+}
+
+// requestMethod returns the method reported for a request, which is the method of its first action
+func requestMethod(req request) string {
+	if req.method == "GET" {
+		return downloadMethod
+	}
+
+	if req.Body == nil || len(req.Body.Actions) == 0 {
+		return unknownMethod
+	}
+	return req.Body.Actions[0].Method
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/hubtest"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	hub, server := newHub()
+	defer server.Close()
+
+	hub.SetError(WiFiRadios, hubtest.ErrorUnknownPath)
+
+	metrics := NewMetrics()
+	homehub := New(server.URL, "admin", "secret", WithMetrics(metrics))
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	hub.ExpireSession()
+
+	if response := homehub.GetSummaryStatistics(context.Background()); response.Error != nil {
+		t.Fatalf("Unexpected error: %s", response.Error)
+	}
+
+	if response := homehub.GetBandwidthStatistics(context.Background()); response.Error != nil {
+		t.Fatalf("Unexpected error: %s", response.Error)
+	}
+
+	hub.SetHTTPStatus(http.StatusServiceUnavailable)
+
+	if response := homehub.GetSummaryStatistics(context.Background()); response.Error == nil {
+		t.Fatal("Expected an error for an HTTP 503 response")
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metrics)
+
+	if count := testutil.CollectAndCount(metrics, "bt_homehub_client_request_duration_seconds"); count != 4 {
+		t.Fatalf("Expected request durations for 4 methods. Got %d", count)
+	}
+
+	// Interface discovery after each login makes a getValue request, as does each attempt at the summary statistics
+	for method, expected := range map[string]uint64{"logIn": 2, "getValue": 5, "uploadBMStatisticsFile": 1, "download": 1} {
+		if count := histogramCount(t, registry, method); count != expected {
+			t.Fatalf("Expected %d %s requests. Got %d", expected, method, count)
+		}
+	}
+
+	// The failed action is counted once, rather than again for the reply it fails
+	for reason, expected := range map[string]float64{invalidSessionError: 1, "XMO_UNKNOWN_PATH_ERR": 1, "XMO_REQUEST_ACTION_ERR": 0, "http_503": 1} {
+		if value := testutil.ToFloat64(metrics.requestErrors.WithLabelValues(reason)); value != expected {
+			t.Fatalf("Expected %f errors with reason %s. Got %f", expected, reason, value)
+		}
+	}
+
+	if value := testutil.ToFloat64(metrics.logins.WithLabelValues("success")); value != 2 {
+		t.Fatalf("Expected 2 successful logins. Got %f", value)
+	}
+
+	if value := testutil.ToFloat64(metrics.sessionAge); value <= 0 {
+		t.Fatalf("Expected a positive session age. Got %f", value)
+	}
+}
+
+func TestMetricsDecodeError(t *testing.T) {
+	hub, server := newHub()
+	defer server.Close()
+
+	hub.SetMalformedReplies(1)
+
+	metrics := NewMetrics()
+	homehub := New(server.URL, "admin", "secret", WithMetrics(metrics))
+	if response := homehub.Login(context.Background()); response.Error == nil {
+		t.Fatal("Expected login to fail")
+	}
+
+	if value := testutil.ToFloat64(metrics.requestErrors.WithLabelValues(decodeErrorReason)); value != 1 {
+		t.Fatalf("Expected 1 decode error. Got %f", value)
+	}
+
+	if value := testutil.ToFloat64(metrics.logins.WithLabelValues("failure")); value != 1 {
+		t.Fatalf("Expected 1 failed login. Got %f", value)
+	}
+
+	if value := testutil.ToFloat64(metrics.sessionAge); value != 0 {
+		t.Fatalf("Expected no session age before a successful login. Got %f", value)
+	}
+}
+
+func histogramCount(t *testing.T, gatherer prometheus.Gatherer, method string) uint64 {
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatalf("Error occurred gathering metrics: %s", err)
+	}
+
+	for _, metricFamily := range metricFamilies {
+		if metricFamily.GetName() != "bt_homehub_client_request_duration_seconds" {
+			continue
+		}
+
+		for _, metric := range metricFamily.GetMetric() {
+			if metric.GetLabel()[0].GetValue() == method {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,168 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultTimeout is the default amount of time allowed for a single request to the Home Hub
+const DefaultTimeout = 10 * time.Second
+
+type options struct {
+	timeout   time.Duration
+	transport http.RoundTripper
+	// wrapTransport wraps the transport, whether it is the default or was set with WithTransport
+	wrapTransport func(http.RoundTripper) http.RoundTripper
+	tlsConfig     *tls.Config
+	proxyURL      *url.URL
+	wanInterface  int
+	dslChannel    int
+	metrics       *Metrics
+	dumpTraffic   bool
+	// bandwidthStartDate is the first day requested by the first download of bandwidth statistics
+	bandwidthStartDate time.Time
+	// passwordFormat is either PasswordFormatPlain or PasswordFormatMD5
+	passwordFormat string
+}
+
+// Option configures how the client communicates with the Home Hub
+type Option func(*options)
+
+// WithTimeout sets the maximum amount of time a single request to the Home Hub may take. A timeout of zero means no timeout
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used when the Home Hub is accessed over HTTPS
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = tlsConfig
+	}
+}
+
+// WithProxy routes requests to the Home Hub through the given HTTP proxy
+func WithProxy(proxyURL *url.URL) Option {
+	return func(o *options) {
+		o.proxyURL = proxyURL
+	}
+}
+
+// WithTransport replaces the HTTP transport used to communicate with the Home Hub. When set, the TLS and proxy options are ignored
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *options) {
+		o.transport = transport
+	}
+}
+
+// WithTransportWrapper wraps the HTTP transport used to communicate with the Home Hub, for example to record the
+// traffic. Unlike WithTransport, the TLS and proxy options still apply to the wrapped transport
+func WithTransportWrapper(wrap func(http.RoundTripper) http.RoundTripper) Option {
+	return func(o *options) {
+		o.wrapTransport = wrap
+	}
+}
+
+// WithWANInterface overrides the automatically discovered WAN IP interface with the interface having the given uid
+func WithWANInterface(uid int) Option {
+	return func(o *options) {
+		o.wanInterface = uid
+	}
+}
+
+// WithDSLChannel overrides the automatically discovered DSL channel with the channel having the given uid
+func WithDSLChannel(uid int) Option {
+	return func(o *options) {
+		o.dslChannel = uid
+	}
+}
+
+// WithMetrics records the duration and errors of requests to the Home Hub, along with logins, in metrics
+func WithMetrics(metrics *Metrics) Option {
+	return func(o *options) {
+		o.metrics = metrics
+	}
+}
+
+// WithPasswordFormat sets whether the password passed to New is plain text or an MD5 hash
+func WithPasswordFormat(format string) Option {
+	return func(o *options) {
+		o.passwordFormat = format
+	}
+}
+
+// WithBandwidthStartDate makes the first download of bandwidth statistics start from date instead of
+// downloading the full history, for example when the statistics up to date have been kept from a previous run
+func WithBandwidthStartDate(date time.Time) Option {
+	return func(o *options) {
+		o.bandwidthStartDate = date
+	}
+}
+
+// WithTrafficDump logs the body of every request to and response from the Home Hub at debug level. Credentials
+// are removed from the logged bodies
+func WithTrafficDump() Option {
+	return func(o *options) {
+		o.dumpTraffic = true
+	}
+}
+
+// NewTLSConfig creates a TLS configuration which trusts the certificates in caFile in addition to the system
+// certificate pool. If insecureSkipVerify is true, the Home Hub certificate is not verified
+func NewTLSConfig(caFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	//nolint:gosec
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	if caFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	certPool, err := x509.SystemCertPool()
+	if err != nil || certPool == nil {
+		certPool = x509.NewCertPool()
+	}
+
+	if !certPool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+	}
+
+	tlsConfig.RootCAs = certPool
+	return tlsConfig, nil
+}
+
+func newHTTPClient(o *options) *http.Client {
+	transport := o.transport
+	if transport == nil {
+		httpTransport := http.DefaultTransport.(*http.Transport).Clone()
+		httpTransport.MaxIdleConnsPerHost = 2
+		if o.tlsConfig != nil {
+			httpTransport.TLSClientConfig = o.tlsConfig
+		}
+		if o.proxyURL != nil {
+			httpTransport.Proxy = http.ProxyURL(o.proxyURL)
+		}
+		transport = httpTransport
+	}
+
+	if o.wrapTransport != nil {
+		transport = o.wrapTransport(transport)
+	}
+
+	return &http.Client{
+		Timeout:   o.timeout,
+		Transport: transport,
+	}
+}
@@ -0,0 +1,20 @@
+package client
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+)
+
+// Formats of the password passed to New
+const (
+	PasswordFormatPlain = "plain"
+	PasswordFormatMD5   = "md5"
+)
+
+// HashPassword returns the MD5 hash of a plain text password in the form expected by the Home Hub. The password
+// is zeroed afterwards, so that the plain text does not remain in memory
+func HashPassword(password []byte) string {
+	hash := md5.Sum(password)
+	clear(password)
+	return hex.EncodeToString(hash[:])
+}
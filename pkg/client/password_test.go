@@ -0,0 +1,32 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPasswordFormat(t *testing.T) {
+	// Plain text passwords of 32 characters must not be mistaken for an MD5 hash
+	plain := "abcdefghijklmnopqrstuvwxyz012345"
+	homehub := New("http://192.168.1.254", "admin", plain).(*HubClient)
+	if homehub.session.passwordHash != hexmd5(plain) {
+		t.Fatalf("Expected the hash of the plain text password. Got %s", homehub.session.passwordHash)
+	}
+
+	hash := "5EBE2294ECD0E0F08EAB7690D2A6EE69"
+	homehub = New("http://192.168.1.254", "admin", hash, WithPasswordFormat(PasswordFormatMD5)).(*HubClient)
+	if homehub.session.passwordHash != "5ebe2294ecd0e0f08eab7690d2a6ee69" {
+		t.Fatalf("Expected the MD5 hash to be used as is. Got %s", homehub.session.passwordHash)
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	password := []byte("secret")
+	if hash := HashPassword(password); hash != "5ebe2294ecd0e0f08eab7690d2a6ee69" {
+		t.Fatalf("Unexpected hash %s", hash)
+	}
+
+	if !bytes.Equal(password, make([]byte, len(password))) {
+		t.Fatalf("Expected the plain text password to be zeroed. Got %q", password)
+	}
+}
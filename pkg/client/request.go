@@ -1,12 +1,14 @@
 package client
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"math"
 	"math/rand"
 	"net/http"
@@ -14,13 +16,21 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/logging"
 )
 
+// maxDumpedBodySize limits how much of each request and response body is logged when dumping Home Hub traffic
+const maxDumpedBodySize = 64 * 1024
+
 type request struct {
-	Body    *requestBody `json:"request"`
-	session session
-	method  string
-	url     string
+	Body       *requestBody `json:"request"`
+	session    session
+	httpClient *http.Client
+	metrics    *Metrics
+	dump       bool
+	method     string
+	url        string
 }
 
 type requestBody struct {
@@ -116,7 +126,7 @@ const (
 	homeHubAPIPath string = "cgi/json-req"
 )
 
-func (req request) send() *Response {
+func (req request) send(ctx context.Context) *Response {
 	response := &Response{}
 
 	session, err := getSessionData(req)
@@ -125,30 +135,50 @@ func (req request) send() *Response {
 		return response
 	}
 
-	httpResponse, err := doHTTPRequest(req, session)
+	if req.dump {
+		req.dumpRequest(ctx)
+	}
+
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		req.metrics.observeRequest(requestMethod(req), duration)
+		req.logActions(ctx, response, duration)
+	}()
+
+	httpResponse, err := doHTTPRequest(ctx, req, session)
 	if err != nil {
+		req.metrics.requestError(transportErrorReason)
 		response.Error = err
 		return response
 	}
 
+	defer httpResponse.Body.Close()
+
 	if httpResponse.StatusCode >= 400 {
+		req.metrics.requestError(fmt.Sprintf("http_%d", httpResponse.StatusCode))
 		response.Error = fmt.Errorf("error processing request. Hub returned HTTP response code: %d", httpResponse.StatusCode)
 		return response
 	}
 
-	defer httpResponse.Body.Close()
 	bodyBytes, err := ioutil.ReadAll(httpResponse.Body)
 	if err != nil {
+		req.metrics.requestError(readErrorReason)
 		response.Error = err
 		return response
 	}
 
+	if req.dump {
+		slog.DebugContext(ctx, "Home Hub response", "status", httpResponse.StatusCode, "body", dumpBody(string(bodyBytes)))
+	}
+
 	var responseBody = &ResponseBody{}
 
 	contentType := httpResponse.Header.Get("Content-type")
 	if strings.HasPrefix(contentType, "application/json") {
 		err := json.Unmarshal(bodyBytes, responseBody)
 		if err != nil {
+			req.metrics.requestError(decodeErrorReason)
 			response.Error = err
 			return response
 		}
@@ -157,6 +187,17 @@ func (req request) send() *Response {
 		if responseBody.Reply != nil && responseBody.Reply.ReplyError.Description != "Ok" {
 			response.Error = errors.New(responseBody.Reply.ReplyError.Description)
 		}
+
+		// A failed action also fails the reply, so the reply error is only counted when no action reports the cause
+		if response.actionFailed() {
+			for _, action := range responseBody.Reply.ResponseActions {
+				if !isSuccess(action.ReplyError.Description) {
+					req.metrics.requestError(action.ReplyError.Description)
+				}
+			}
+		} else if response.Error != nil {
+			req.metrics.requestError(responseBody.Reply.ReplyError.Description)
+		}
 	} else {
 		response.Body = string(bodyBytes)
 	}
@@ -164,12 +205,58 @@ func (req request) send() *Response {
 	return response
 }
 
+// logActions logs each action of a JSON-RPC request at debug level, along with the error returned for it
+func (req request) logActions(ctx context.Context, response *Response, duration time.Duration) {
+	logger := slog.Default()
+	if req.Body == nil || !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	replies := make(map[int]ResponseAction)
+	if response.ResponseBody.Reply != nil {
+		for _, action := range response.ResponseBody.Reply.ResponseActions {
+			replies[action.ID] = action
+		}
+	}
+
+	for _, action := range req.Body.Actions {
+		attrs := []any{"id", action.ID, "method", action.Method, "xpath", action.XPath, "duration", duration}
+		if reply, ok := replies[action.ID]; ok {
+			attrs = append(attrs, "error_code", reply.ReplyError.Code, "error_description", reply.ReplyError.Description)
+		}
+		if response.Error != nil {
+			attrs = append(attrs, "err", response.Error)
+		}
+		logger.DebugContext(ctx, "Home Hub action", attrs...)
+	}
+}
+
+// dumpRequest logs the request at debug level with credentials removed
+func (req request) dumpRequest(ctx context.Context) {
+	var body string
+	if req.Body != nil {
+		payload, err := json.Marshal(req)
+		if err != nil {
+			return
+		}
+		body = string(payload)
+	}
+	slog.DebugContext(ctx, "Home Hub request", "http_method", req.method, "url", req.url, "body", dumpBody(body))
+}
+
+func dumpBody(body string) string {
+	if len(body) > maxDumpedBodySize {
+		body = body[:maxDumpedBodySize] + "...(truncated)"
+	}
+	return logging.Redact(body)
+}
+
 func getSessionData(req request) ([]byte, error) {
 	sessionData := newSessionData(&req.session)
 	return json.Marshal(sessionData)
 }
 
-func getHTTPRequest(req request) (*http.Request, error) {
+func getHTTPRequest(ctx context.Context, req request) (*http.Request, error) {
 	if req.method == "POST" {
 		payload, err := json.Marshal(req)
 		if err != nil {
@@ -179,22 +266,25 @@ func getHTTPRequest(req request) (*http.Request, error) {
 		form := url.Values{}
 		form.Add("req", string(payload))
 		body := strings.NewReader(form.Encode())
-		return http.NewRequest(req.method, req.url, body)
+		return http.NewRequestWithContext(ctx, req.method, req.url, body)
 	}
 
-	return http.NewRequest(req.method, req.url, nil)
+	return http.NewRequestWithContext(ctx, req.method, req.url, nil)
 }
 
-func doHTTPRequest(req request, session []byte) (*http.Response, error) {
-	httpRequest, _ := getHTTPRequest(req)
+func doHTTPRequest(ctx context.Context, req request, session []byte) (*http.Response, error) {
+	httpRequest, err := getHTTPRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
 	httpRequest.Header.Set("Content-Type", contentType)
 	httpRequest.Header.Set("Accept", accept)
 	httpRequest.Header.Set("Accept-Encoding", encoding)
 	httpRequest.Header.Set("Accept-Language", language)
 	httpRequest.AddCookie(&http.Cookie{Name: "lang", Value: "en"})
 	httpRequest.AddCookie(&http.Cookie{Name: "session", Value: url.QueryEscape(string(session))})
-	httpClient := &http.Client{}
-	return httpClient.Do(httpRequest)
+	return req.httpClient.Do(httpRequest)
 }
 
 func newNss() *nss {
@@ -206,9 +296,9 @@ func newRequestBody(session session, actions []action) *requestBody {
 
 	var ha1 string
 	if session.nonce != "" {
-		ha1 = hexmd5(fmt.Sprintf("%s:%s:%s", session.userName, session.nonce, session.password))
+		ha1 = hexmd5(fmt.Sprintf("%s:%s:%s", session.userName, session.nonce, session.passwordHash))
 	} else {
-		ha1 = hexmd5(fmt.Sprintf("%s::%s", session.userName, session.password))
+		ha1 = hexmd5(fmt.Sprintf("%s::%s", session.userName, session.passwordHash))
 	}
 	authKey := hexmd5(fmt.Sprintf("%s:%d:%d:JSON:/%s", ha1, session.requestCount, cnonce, homeHubAPIPath))
 
@@ -234,8 +324,8 @@ func newSessionData(session *session) *sessionData {
 	}
 
 	sessionID, _ := strconv.Atoi(session.sessionID)
-	authKey := hexmd5(fmt.Sprintf("%s:%s:%s", session.userName, session.nonce, session.password))
-	ha1 := authKey[:10] + session.password + authKey[10:]
+	authKey := hexmd5(fmt.Sprintf("%s:%s:%s", session.userName, session.nonce, session.passwordHash))
+	ha1 := authKey[:10] + session.passwordHash + authKey[10:]
 
 	return &sessionData{
 		ID:        session.requestCount,
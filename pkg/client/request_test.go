@@ -0,0 +1,56 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/hubtest"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/logging"
+)
+
+func TestTrafficDump(t *testing.T) {
+	hub, server := newHub()
+	defer server.Close()
+
+	hub.SetError(WiFiRadios, hubtest.ErrorUnknownPath)
+
+	var buffer bytes.Buffer
+	logger, err := logging.New(&buffer, "debug", logging.FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaultLogger := slog.Default()
+	slog.SetDefault(logger)
+	defer slog.SetDefault(defaultLogger)
+
+	homehub := New(server.URL, "admin", "secret", WithTrafficDump())
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	if response := homehub.GetSummaryStatistics(context.Background()); response.Error != nil {
+		t.Fatalf("Unexpected error: %s", response.Error)
+	}
+
+	output := buffer.String()
+	for _, expected := range []string{`"msg":"Home Hub request"`, `"msg":"Home Hub response"`, `"method":"logIn"`, `"xpath":"` + WiFiRadios + `"`, `"error_description":"XMO_UNKNOWN_PATH_ERR"`} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("Expected log output to contain %s:\n%s", expected, output)
+		}
+	}
+
+	hubClient := homehub.(*HubClient)
+	hubClient.mutex.Lock()
+	nonce := hubClient.session.nonce
+	hubClient.mutex.Unlock()
+
+	for _, credential := range []string{"secret", hexmd5("secret"), nonce} {
+		if strings.Contains(output, credential) {
+			t.Fatalf("Expected %s to be removed from log output:\n%s", credential, output)
+		}
+	}
+}
@@ -1,5 +1,12 @@
 package client
 
+import "fmt"
+
+const (
+	invalidSessionError string = "XMO_INVALID_SESSION_ERR"
+	authenticationError string = "XMO_AUTHENTICATION_ERR"
+)
+
 // Response represents a HTTP response from the Home Hub
 type Response struct {
 	Body         string
@@ -7,6 +14,53 @@ type Response struct {
 	Error        error
 }
 
+// authenticationFailed returns true if the Home Hub rejected the request because the session is no longer valid
+func (response *Response) authenticationFailed() bool {
+	reply := response.ResponseBody.Reply
+	if reply == nil {
+		return false
+	}
+
+	if reply.ReplyError.isAuthenticationError() {
+		return true
+	}
+
+	for _, action := range reply.ResponseActions {
+		if action.ReplyError.isAuthenticationError() {
+			return true
+		}
+	}
+	return false
+}
+
+// actionFailed returns true if the Home Hub reported an error for at least one of the actions in the reply
+func (response *Response) actionFailed() bool {
+	reply := response.ResponseBody.Reply
+	if reply == nil {
+		return false
+	}
+
+	for _, action := range reply.ResponseActions {
+		if !isSuccess(action.ReplyError.Description) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstCallback returns the first callback of the first action in the reply. An error is returned if the Home Hub
+// did not send a JSON reply, such as when a login page or proxy error is returned, or if the reply has no callbacks
+func (response *Response) firstCallback() (*ResponseCallback, error) {
+	reply := response.ResponseBody.Reply
+	if reply == nil {
+		return nil, fmt.Errorf("no reply returned by the Home Hub")
+	}
+	if len(reply.ResponseActions) == 0 || len(reply.ResponseActions[0].ResponseCallbacks) == 0 {
+		return nil, fmt.Errorf("no callbacks returned by the Home Hub")
+	}
+	return &reply.ResponseActions[0].ResponseCallbacks[0], nil
+}
+
 // ResponseBody represents the body response reply from the Home Hub json-req endpoint
 type ResponseBody struct {
 	Reply *Reply `json:"reply"`
@@ -25,6 +79,10 @@ type replyError struct {
 	Description string `json:"description"`
 }
 
+func (e replyError) isAuthenticationError() bool {
+	return e.Description == invalidSessionError || e.Description == authenticationError
+}
+
 // ResponseAction represents a response action from the Home Hub
 type ResponseAction struct {
 	UID               int                `json:"uid"`
@@ -52,12 +110,20 @@ type responseEvent struct {
 
 // DeviceDetail represents a device that has connected to the Home Hub at some point in time
 type DeviceDetail struct {
-	UID             int    `json:"uid,omitempty"`
-	Alias           string `json:"Alias,omitempty"`
-	PhysicalAddress string `json:"PhysAddress,omitempty"`
-	IPAddress       string `json:"IPAddress,omitempty"`
-	HostName        string `json:"HostName,omitempty"`
-	Active          bool   `json:"Active,omitempty"`
-	InterfaceType   string `json:"InterfaceType,omitempty"`
-	UserHostName    string `json:"UserHostName,omitempty"`
+	UID                int     `json:"uid,omitempty"`
+	Alias              string  `json:"Alias,omitempty"`
+	PhysicalAddress    string  `json:"PhysAddress,omitempty"`
+	IPAddress          string  `json:"IPAddress,omitempty"`
+	AddressSource      string  `json:"AddressSource,omitempty"`
+	HostName           string  `json:"HostName,omitempty"`
+	Active             bool    `json:"Active,omitempty"`
+	InterfaceType      string  `json:"InterfaceType,omitempty"`
+	UserHostName       string  `json:"UserHostName,omitempty"`
+	Layer1Interface    string  `json:"Layer1Interface,omitempty"`
+	Layer3Interface    string  `json:"Layer3Interface,omitempty"`
+	VendorClassID      string  `json:"VendorClassID,omitempty"`
+	DetectedDeviceType string  `json:"DetectedDeviceType,omitempty"`
+	LeaseTimeRemaining Counter `json:"LeaseTimeRemaining,omitempty"`
+	FirstSeen          string  `json:"FirstSeen,omitempty"`
+	LastConnection     string  `json:"LastConnection,omitempty"`
 }
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Value is the result of reading a single XPath from the Home Hub. Error is set if the Home Hub could not
+// return a value for the XPath
+type Value struct {
+	Value interface{}
+	Error error
+}
+
+// MarshalJSON encodes the value, or the error if the XPath could not be read
+func (v Value) MarshalJSON() ([]byte, error) {
+	if v.Error != nil {
+		return json.Marshal(map[string]string{"error": v.Error.Error()})
+	}
+	return json.Marshal(map[string]interface{}{"value": v.Value})
+}
+
+// XPathError is returned when the Home Hub rejects a request for an XPath
+type XPathError struct {
+	XPath       string
+	Code        int
+	Description string
+}
+
+func (e *XPathError) Error() string {
+	return fmt.Sprintf("%s: %s (%d)", e.XPath, e.Description, e.Code)
+}
+
+// isSuccess returns true if the result description reports that no error occurred
+func isSuccess(description string) bool {
+	return description == "" || description == "Ok" || strings.HasSuffix(description, "_NO_ERR")
+}
+
+// GetValues reads arbitrary XPaths from the Home Hub in a single request. The returned map is keyed by the
+// requested XPath. An error is only returned if the request itself failed. XPaths that the Home Hub rejects
+// are reported with an XPathError
+func (client *HubClient) GetValues(ctx context.Context, xpaths ...string) (map[string]Value, error) {
+	options := &interfaceOptions{CapabilityFlags: capabilityFlags{Interface: true}}
+
+	actions := make([]action, 0, len(xpaths))
+	for i, xpath := range xpaths {
+		actions = append(actions, action{
+			ID:               i,
+			Method:           "getValue",
+			XPath:            xpath,
+			InterfaceOptions: options,
+		})
+	}
+
+	client.mutex.Lock()
+	response := client.sendActions(ctx, actions)
+	client.mutex.Unlock()
+
+	// When single actions fail, the Home Hub also reports an error for the whole reply. The request itself only
+	// failed if none of the actions report an error of their own
+	if response.Error != nil && !response.actionFailed() {
+		return nil, response.Error
+	}
+
+	if response.ResponseBody.Reply == nil {
+		return nil, fmt.Errorf("no reply returned by the Home Hub")
+	}
+
+	values := make(map[string]Value, len(xpaths))
+	for _, responseAction := range response.ResponseBody.Reply.ResponseActions {
+		if responseAction.ID < 0 || responseAction.ID >= len(xpaths) {
+			continue
+		}
+
+		xpath := xpaths[responseAction.ID]
+		switch {
+		case !isSuccess(responseAction.ReplyError.Description):
+			values[xpath] = Value{Error: &XPathError{xpath, responseAction.ReplyError.Code, responseAction.ReplyError.Description}}
+		case len(responseAction.ResponseCallbacks) == 0:
+			values[xpath] = Value{Error: &XPathError{XPath: xpath, Description: "no value returned"}}
+		default:
+			callback := responseAction.ResponseCallbacks[0]
+			if !isSuccess(callback.Result.Description) {
+				values[xpath] = Value{Error: &XPathError{xpath, callback.Result.Code, callback.Result.Description}}
+			} else {
+				values[xpath] = Value{Value: callback.Parameters.Value}
+			}
+		}
+	}
+
+	for _, xpath := range xpaths {
+		if _, ok := values[xpath]; !ok {
+			values[xpath] = Value{Error: &XPathError{XPath: xpath, Description: "no value returned"}}
+		}
+	}
+
+	return values, nil
+}
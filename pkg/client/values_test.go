@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestGetValues(t *testing.T) {
+	hub, server := newHub()
+	defer server.Close()
+
+	if err := hub.SetValue("Device/DeviceInfo/ModelName", "Smart Hub 2"); err != nil {
+		t.Fatal(err)
+	}
+
+	homehub := New(server.URL, "admin", "secret")
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	values, err := homehub.GetValues(context.Background(), "Device/DeviceInfo/ModelName", "Device/Unknown")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if value := values["Device/DeviceInfo/ModelName"]; value.Error != nil || value.Value != "Smart Hub 2" {
+		t.Fatalf("Unexpected value for Device/DeviceInfo/ModelName: %+v", value)
+	}
+
+	var xpathError *XPathError
+	if !errors.As(values["Device/Unknown"].Error, &xpathError) || xpathError.Description != "XMO_UNKNOWN_PATH_ERR" {
+		t.Fatalf("Expected XPathError for Device/Unknown. Got %v", values["Device/Unknown"].Error)
+	}
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := `{"Device/DeviceInfo/ModelName":{"value":"Smart Hub 2"},"Device/Unknown":{"error":"Device/Unknown: XMO_UNKNOWN_PATH_ERR (16777236)"}}`
+	if string(data) != expected {
+		t.Fatalf("Expected JSON %s. Got %s", expected, data)
+	}
+}
@@ -0,0 +1,86 @@
+package client
+
+// WiFiRadio represents an entry from the Home Hub Device/WiFi/Radios table
+type WiFiRadio struct {
+	UID                              int            `json:"uid"`
+	Alias                            string         `json:"Alias"`
+	Enable                           bool           `json:"Enable"`
+	Status                           string         `json:"Status"`
+	OperatingFrequencyBand           string         `json:"OperatingFrequencyBand"`
+	Channel                          Counter        `json:"Channel"`
+	CurrentOperatingChannelBandwidth string         `json:"CurrentOperatingChannelBandwidth"`
+	TransmitPower                    Counter        `json:"TransmitPower"`
+	Stats                            WiFiRadioStats `json:"Stats"`
+}
+
+// WiFiRadioStats holds the statistics of a Wi-Fi radio
+type WiFiRadioStats struct {
+	Noise Counter `json:"Noise"`
+}
+
+// WiFiSSID represents an entry from the Home Hub Device/WiFi/SSIDs table
+type WiFiSSID struct {
+	UID         int              `json:"uid"`
+	Alias       string           `json:"Alias"`
+	Enable      bool             `json:"Enable"`
+	Status      string           `json:"Status"`
+	SSID        string           `json:"SSID"`
+	LowerLayers string           `json:"LowerLayers"`
+	Stats       IPInterfaceStats `json:"Stats"`
+}
+
+// RadioUID returns the uid of the radio that the SSID is broadcast on, or 0 if it is unknown
+func (s WiFiSSID) RadioUID() int {
+	if kind, uid := lowerLayer(s.LowerLayers); kind == "WiFi.Radio" {
+		return uid
+	}
+	return 0
+}
+
+// WiFiAccessPoint represents an entry from the Home Hub Device/WiFi/AccessPoints table
+type WiFiAccessPoint struct {
+	UID                             int                    `json:"uid"`
+	Alias                           string                 `json:"Alias"`
+	Enable                          bool                   `json:"Enable"`
+	SSIDReference                   string                 `json:"SSIDReference"`
+	AssociatedDeviceNumberOfEntries Counter                `json:"AssociatedDeviceNumberOfEntries"`
+	AssociatedDevices               []WiFiAssociatedDevice `json:"AssociatedDevices"`
+}
+
+// SSIDUID returns the uid of the SSID served by the access point, or 0 if it is unknown
+func (a WiFiAccessPoint) SSIDUID() int {
+	if kind, uid := lowerLayer(a.SSIDReference); kind == "WiFi.SSID" {
+		return uid
+	}
+	return 0
+}
+
+// WiFiAssociatedDevice represents a client associated with a Wi-Fi access point
+type WiFiAssociatedDevice struct {
+	MACAddress           string  `json:"MACAddress"`
+	Active               bool    `json:"Active"`
+	SignalStrength       Counter `json:"SignalStrength"`
+	LastDataDownlinkRate Counter `json:"LastDataDownlinkRate"`
+	LastDataUplinkRate   Counter `json:"LastDataUplinkRate"`
+}
+
+// DecodeWiFiRadios decodes the value returned by the Home Hub for the WiFiRadios XPath
+func DecodeWiFiRadios(value interface{}) ([]WiFiRadio, error) {
+	var radios []WiFiRadio
+	err := decodeValue(value, &radios)
+	return radios, err
+}
+
+// DecodeWiFiSSIDs decodes the value returned by the Home Hub for the WiFiSSIDs XPath
+func DecodeWiFiSSIDs(value interface{}) ([]WiFiSSID, error) {
+	var ssids []WiFiSSID
+	err := decodeValue(value, &ssids)
+	return ssids, err
+}
+
+// DecodeWiFiAccessPoints decodes the value returned by the Home Hub for the WiFiAccessPoints XPath
+func DecodeWiFiAccessPoints(value interface{}) ([]WiFiAccessPoint, error) {
+	var accessPoints []WiFiAccessPoint
+	err := decodeValue(value, &accessPoints)
+	return accessPoints, err
+}
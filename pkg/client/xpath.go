@@ -15,6 +15,98 @@ const (
 	UploadedBytes string = "Device/IP/Interfaces/Interface[@uid='3']/Stats/BytesSent"
 	// UploadRate string constant for the UpstreamCurrRate request XPath expression
 	UploadRate string = "Device/DSL/Channels/Channel[@uid='1']/UpstreamCurrRate"
+	// IPInterfaces string constant for the IP Interfaces request XPath expression
+	IPInterfaces string = "Device/IP/Interfaces"
+	// WiFiRadios string constant for the Wi-Fi Radios request XPath expression
+	WiFiRadios string = "Device/WiFi/Radios"
+	// WiFiSSIDs string constant for the Wi-Fi SSIDs request XPath expression
+	WiFiSSIDs string = "Device/WiFi/SSIDs"
+	// WiFiAccessPoints string constant for the Wi-Fi AccessPoints request XPath expression
+	WiFiAccessPoints string = "Device/WiFi/AccessPoints"
 	// UpTime string constant for the UpTime request XPath expression
 	UpTime string = "Device/DeviceInfo/UpTime"
+	// DSLStatus string constant for the DSL line Status request XPath expression
+	DSLStatus string = "Device/DSL/Lines/Line[@uid='1']/Status"
+	// DSLDownstreamNoiseMargin string constant for the DownstreamNoiseMargin request XPath expression
+	DSLDownstreamNoiseMargin string = "Device/DSL/Lines/Line[@uid='1']/DownstreamNoiseMargin"
+	// DSLUpstreamNoiseMargin string constant for the UpstreamNoiseMargin request XPath expression
+	DSLUpstreamNoiseMargin string = "Device/DSL/Lines/Line[@uid='1']/UpstreamNoiseMargin"
+	// DSLDownstreamAttenuation string constant for the DownstreamAttenuation request XPath expression
+	DSLDownstreamAttenuation string = "Device/DSL/Lines/Line[@uid='1']/DownstreamAttenuation"
+	// DSLUpstreamAttenuation string constant for the UpstreamAttenuation request XPath expression
+	DSLUpstreamAttenuation string = "Device/DSL/Lines/Line[@uid='1']/UpstreamAttenuation"
+	// DSLDownstreamMaxRate string constant for the DownstreamMaxBitRate request XPath expression
+	DSLDownstreamMaxRate string = "Device/DSL/Lines/Line[@uid='1']/DownstreamMaxBitRate"
+	// DSLUpstreamMaxRate string constant for the UpstreamMaxBitRate request XPath expression
+	DSLUpstreamMaxRate string = "Device/DSL/Lines/Line[@uid='1']/UpstreamMaxBitRate"
+	// DSLErroredSeconds string constant for the DSL line ErroredSecs request XPath expression
+	DSLErroredSeconds string = "Device/DSL/Lines/Line[@uid='1']/Stats/Total/ErroredSecs"
+	// DSLSeverelyErroredSeconds string constant for the DSL line SeverelyErroredSecs request XPath expression
+	DSLSeverelyErroredSeconds string = "Device/DSL/Lines/Line[@uid='1']/Stats/Total/SeverelyErroredSecs"
+	// DSLResyncs string constant for the DSL line LinkRetrain request XPath expression
+	DSLResyncs string = "Device/DSL/Lines/Line[@uid='1']/Stats/Total/LinkRetrain"
+	// DSLDownstreamCRCErrors string constant for the DSL channel XTURCRCErrors request XPath expression
+	DSLDownstreamCRCErrors string = "Device/DSL/Channels/Channel[@uid='1']/Stats/Total/XTURCRCErrors"
+	// DSLUpstreamCRCErrors string constant for the DSL channel XTUCCRCErrors request XPath expression
+	DSLUpstreamCRCErrors string = "Device/DSL/Channels/Channel[@uid='1']/Stats/Total/XTUCCRCErrors"
+	// DSLDownstreamFECErrors string constant for the DSL channel XTURFECErrors request XPath expression
+	DSLDownstreamFECErrors string = "Device/DSL/Channels/Channel[@uid='1']/Stats/Total/XTURFECErrors"
+	// DSLUpstreamFECErrors string constant for the DSL channel XTUCFECErrors request XPath expression
+	DSLUpstreamFECErrors string = "Device/DSL/Channels/Channel[@uid='1']/Stats/Total/XTUCFECErrors"
+	// DSLDownstreamHECErrors string constant for the DSL channel XTURHECErrors request XPath expression
+	DSLDownstreamHECErrors string = "Device/DSL/Channels/Channel[@uid='1']/Stats/Total/XTURHECErrors"
+	// DSLUpstreamHECErrors string constant for the DSL channel XTUCHECErrors request XPath expression
+	DSLUpstreamHECErrors string = "Device/DSL/Channels/Channel[@uid='1']/Stats/Total/XTUCHECErrors"
+	// WANConnectionStatus string constant for the PPP interface ConnectionStatus request XPath expression
+	WANConnectionStatus string = "Device/PPP/Interfaces/Interface[@uid='1']/ConnectionStatus"
+	// WANLastConnectionError string constant for the PPP interface LastConnectionError request XPath expression
+	WANLastConnectionError string = "Device/PPP/Interfaces/Interface[@uid='1']/LastConnectionError"
+	// WANConnectionUptime string constant for the PPP interface LastChange request XPath expression
+	WANConnectionUptime string = "Device/PPP/Interfaces/Interface[@uid='1']/LastChange"
+	// WANDNSServers string constant for the PPP interface IPCP DNSServers request XPath expression
+	WANDNSServers string = "Device/PPP/Interfaces/Interface[@uid='1']/IPCP/DNSServers"
+	// WANIPv4Address string constant for the WAN IP interface IPv4Address request XPath expression
+	WANIPv4Address string = "Device/IP/Interfaces/Interface[@uid='3']/IPv4Addresses/IPv4Address[@uid='1']/IPAddress"
+	// WANIPv6Address string constant for the WAN IP interface IPv6Address request XPath expression
+	WANIPv6Address string = "Device/IP/Interfaces/Interface[@uid='3']/IPv6Addresses/IPv6Address[@uid='1']/IPAddress"
 )
+
+// dslChannels is the XPath expression for the DSL channels table, used to discover the active DSL channel
+const dslChannels string = "Device/DSL/Channels"
+
+// dslXPaths are the DSL line quality XPath expressions requested alongside the summary statistics
+var dslXPaths = []string{
+	DSLStatus,
+	DSLDownstreamNoiseMargin,
+	DSLUpstreamNoiseMargin,
+	DSLDownstreamAttenuation,
+	DSLUpstreamAttenuation,
+	DSLDownstreamMaxRate,
+	DSLUpstreamMaxRate,
+	DSLErroredSeconds,
+	DSLSeverelyErroredSeconds,
+	DSLResyncs,
+	DSLDownstreamCRCErrors,
+	DSLUpstreamCRCErrors,
+	DSLDownstreamFECErrors,
+	DSLUpstreamFECErrors,
+	DSLDownstreamHECErrors,
+	DSLUpstreamHECErrors,
+}
+
+// wanXPaths are the WAN connection XPath expressions requested alongside the summary statistics
+var wanXPaths = []string{
+	WANConnectionStatus,
+	WANLastConnectionError,
+	WANConnectionUptime,
+	WANDNSServers,
+	WANIPv4Address,
+	WANIPv6Address,
+}
+
+// wifiXPaths are the Wi-Fi radio, SSID and access point XPath expressions requested alongside the summary statistics
+var wifiXPaths = []string{
+	WiFiRadios,
+	WiFiSSIDs,
+	WiFiAccessPoints,
+}
@@ -0,0 +1,565 @@
+// Package config loads the exporter configuration file
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Metric types supported for user defined metrics
+const (
+	MetricTypeGauge   = "gauge"
+	MetricTypeCounter = "counter"
+	MetricTypeInfo    = "info"
+)
+
+// InfoValueLabel is the label holding the value of info metrics
+const InfoValueLabel = "value"
+
+// Value transforms supported for user defined metrics
+const (
+	TransformNone      = ""
+	TransformKbpsToBps = "kbps_to_bps"
+	TransformMbpsToBps = "mbps_to_bps"
+	TransformScale     = "scale"
+	TransformEnum      = "enum"
+)
+
+var (
+	metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNamePattern  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	md5Pattern        = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+	macAddressPattern = regexp.MustCompile(`^[0-9a-fA-F]{2}(:[0-9a-fA-F]{2}){5}$`)
+)
+
+// Config is the exporter configuration file
+type Config struct {
+	Hub       HubConfig       `yaml:"hub"`
+	Polling   PollingConfig   `yaml:"polling"`
+	Bandwidth BandwidthConfig `yaml:"bandwidth"`
+	Presence  PresenceConfig  `yaml:"presence"`
+	Web       WebConfig       `yaml:"web"`
+	// Notifications are sent to webhooks when devices join or leave the network
+	Notifications NotificationsConfig `yaml:"notifications"`
+	// MQTT publishes the metrics of every poll to an MQTT broker
+	MQTT MQTTConfig `yaml:"mqtt"`
+	// Labels are added to every metric exported for the Home Hub
+	Labels  map[string]string `yaml:"labels"`
+	Metrics []Metric          `yaml:"metrics"`
+	// Collectors enables or disables collectors by name. Collectors that are not listed are enabled
+	Collectors map[string]bool `yaml:"collectors"`
+	// Targets are additional Home Hubs that can be scraped through the /probe endpoint
+	Targets []Target `yaml:"targets"`
+}
+
+// HubConfig configures the connection to the Home Hub
+type HubConfig struct {
+	Address  string `yaml:"address"`
+	Scheme   string `yaml:"scheme"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// PasswordFormat is plain when the password is plain text, or md5 when it is the MD5 hash of the password
+	PasswordFormat string `yaml:"password_format"`
+	// PasswordFile is read when the configuration is loaded, so that a changed password is picked up on reload
+	PasswordFile       string        `yaml:"password_file"`
+	Timeout            time.Duration `yaml:"timeout"`
+	CAFile             string        `yaml:"ca_file"`
+	InsecureSkipVerify bool          `yaml:"insecure_skip_verify"`
+	ProxyURL           string        `yaml:"proxy_url"`
+	WANInterface       int           `yaml:"wan_interface"`
+	DSLChannel         int           `yaml:"dsl_channel"`
+}
+
+// Target is a Home Hub that is scraped through the /probe endpoint
+type Target struct {
+	Name      string `yaml:"name"`
+	HubConfig `yaml:",inline"`
+}
+
+// Hub returns the connection settings for the target. Settings that the target leaves empty are inherited
+// from defaults, apart from the address and interface overrides which are specific to each Home Hub
+func (t *Target) Hub(defaults HubConfig) HubConfig {
+	hub := t.HubConfig
+	if hub.Scheme == "" {
+		hub.Scheme = defaults.Scheme
+	}
+	if hub.Username == "" {
+		hub.Username = defaults.Username
+	}
+	if hub.Password == "" {
+		hub.Password, hub.PasswordFormat = defaults.Password, defaults.PasswordFormat
+	}
+	if hub.Timeout == 0 {
+		hub.Timeout = defaults.Timeout
+	}
+	if hub.CAFile == "" {
+		hub.CAFile = defaults.CAFile
+	}
+	if hub.ProxyURL == "" {
+		hub.ProxyURL = defaults.ProxyURL
+	}
+	hub.InsecureSkipVerify = hub.InsecureSkipVerify || defaults.InsecureSkipVerify
+	hub.PasswordFile = ""
+	return hub
+}
+
+// Target returns the target with the given name
+func (c *Config) Target(name string) (*Target, bool) {
+	for i := range c.Targets {
+		if c.Targets[i].Name == name {
+			return &c.Targets[i], true
+		}
+	}
+	return nil, false
+}
+
+// PollingConfig configures background polling of the Home Hub
+type PollingConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	MaxAge   time.Duration `yaml:"max_age"`
+}
+
+// BandwidthConfig configures how device bandwidth statistics are stored
+type BandwidthConfig struct {
+	StateFile string `yaml:"state_file"`
+	// RetentionDays is the number of days before the most recent day that statistics are kept for. Defaults to 365
+	RetentionDays int `yaml:"retention_days"`
+}
+
+// PresenceConfig configures how the history of when devices were connected is stored
+type PresenceConfig struct {
+	StateFile string `yaml:"state_file"`
+}
+
+// NotificationsConfig configures the webhooks that device events are sent to
+type NotificationsConfig struct {
+	// AllowlistFile lists the MAC addresses of known devices, one per line. Known devices do not cause
+	// notifications when they join, leave or change
+	AllowlistFile string `yaml:"allowlist_file"`
+	// Allowlist holds the upper case MAC addresses read from AllowlistFile
+	Allowlist map[string]bool `yaml:"-"`
+	// DedupWindow is how long a repeated event for the same device is suppressed
+	DedupWindow time.Duration `yaml:"dedup_window"`
+	Webhooks    []Webhook     `yaml:"webhooks"`
+}
+
+// Webhook is an HTTP endpoint that device events are posted to
+type Webhook struct {
+	URL string `yaml:"url"`
+	// Format is json, slack or ntfy
+	Format string `yaml:"format"`
+	// Events limits the event types that are sent. All events are sent by default
+	Events  []string      `yaml:"events"`
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxRetries is nil when unset, so that max_retries: 0 can turn retries off
+	MaxRetries *int `yaml:"max_retries"`
+}
+
+// MQTTConfig configures the MQTT broker that metrics are published to. Publishing is disabled when no broker
+// is configured
+type MQTTConfig struct {
+	// Broker is the broker URL, e.g. tcp://localhost:1883 or ssl://localhost:8883
+	Broker   string `yaml:"broker"`
+	ClientID string `yaml:"client_id"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// PasswordFile is read when the configuration is loaded, so that a changed password is picked up on reload
+	PasswordFile       string `yaml:"password_file"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	TopicPrefix        string `yaml:"topic_prefix"`
+	// QoS is the quality of service level of published messages, 0 or 1
+	QoS int `yaml:"qos"`
+	// Retain makes the broker retain the latest state of every metric
+	Retain bool `yaml:"retain"`
+	// DiscoveryPrefix is the topic prefix of Home Assistant discovery messages
+	DiscoveryPrefix  string        `yaml:"discovery_prefix"`
+	DisableDiscovery bool          `yaml:"disable_discovery"`
+	Timeout          time.Duration `yaml:"timeout"`
+}
+
+// WebConfig configures the HTTP server. Changes only take effect after a restart
+type WebConfig struct {
+	ListenAddress string `yaml:"listen_address"`
+	MetricsPath   string `yaml:"metrics_path"`
+}
+
+// Metric declares a metric whose value is read from a Home Hub XPath
+type Metric struct {
+	// Name is the full Prometheus metric name
+	Name string `yaml:"name"`
+	Help string `yaml:"help"`
+	// XPath is the Home Hub data model path to read
+	XPath string `yaml:"xpath"`
+	// Type is one of gauge, counter or info
+	Type string `yaml:"type"`
+	// ValueField is the field holding the value of each entry when the XPath returns a list
+	ValueField string `yaml:"value_field"`
+	// Transform converts the value returned by the Home Hub into a float
+	Transform string `yaml:"transform"`
+	// Scale is the factor applied by the scale transform
+	Scale float64 `yaml:"scale"`
+	// Mapping maps string values to floats for the enum transform
+	Mapping map[string]float64 `yaml:"mapping"`
+	// Labels maps label names to the fields of each entry holding their values when the XPath returns a list
+	Labels map[string]string `yaml:"labels"`
+}
+
+// Load reads and validates the configuration file. A password_file is read relative to the directory
+// of the configuration file
+func Load(file string) (*Config, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(file)
+	if err := config.Hub.ReadPasswordFile(dir); err != nil {
+		return nil, fmt.Errorf("hub: %s", err)
+	}
+
+	for i := range config.Targets {
+		if err := config.Targets[i].ReadPasswordFile(dir); err != nil {
+			return nil, fmt.Errorf("target %s: %s", config.Targets[i].Name, err)
+		}
+	}
+
+	if err := config.Notifications.ReadAllowlistFile(dir); err != nil {
+		return nil, fmt.Errorf("notifications: %s", err)
+	}
+
+	if err := config.MQTT.ReadPasswordFile(dir); err != nil {
+		return nil, fmt.Errorf("mqtt: %s", err)
+	}
+	return config, nil
+}
+
+// ReadAllowlistFile sets the allowlist from the allowlist file, if there is one. Blank lines and lines starting
+// with # are ignored. A relative allowlist file is read from dir
+func (n *NotificationsConfig) ReadAllowlistFile(dir string) error {
+	if n.AllowlistFile == "" {
+		return nil
+	}
+
+	allowlistFile := n.AllowlistFile
+	if !filepath.IsAbs(allowlistFile) {
+		allowlistFile = filepath.Join(dir, allowlistFile)
+	}
+
+	data, err := ioutil.ReadFile(allowlistFile)
+	if err != nil {
+		return err
+	}
+
+	n.Allowlist = make(map[string]bool)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !macAddressPattern.MatchString(line) {
+			return fmt.Errorf("%s:%d: invalid MAC address %q", n.AllowlistFile, i+1, line)
+		}
+		n.Allowlist[strings.ToUpper(line)] = true
+	}
+	return nil
+}
+
+// ReadPasswordFile sets the password from the password file, if there is one. A relative password file is
+// read from dir
+func (h *HubConfig) ReadPasswordFile(dir string) error {
+	password, err := readPasswordFile(dir, h.PasswordFile, h.Password)
+	if err != nil {
+		return err
+	}
+	h.Password = password
+	return nil
+}
+
+// ReadPasswordFile sets the password from the password file, if there is one. A relative password file is
+// read from dir
+func (m *MQTTConfig) ReadPasswordFile(dir string) error {
+	password, err := readPasswordFile(dir, m.PasswordFile, m.Password)
+	if err != nil {
+		return err
+	}
+	m.Password = password
+	return nil
+}
+
+// readPasswordFile returns the contents of passwordFile without a trailing newline, or password if there is
+// no password file
+func readPasswordFile(dir string, passwordFile string, password string) (string, error) {
+	if passwordFile == "" {
+		return password, nil
+	}
+
+	if password != "" {
+		return "", fmt.Errorf("password and password_file are mutually exclusive")
+	}
+
+	if !filepath.IsAbs(passwordFile) {
+		passwordFile = filepath.Join(dir, passwordFile)
+	}
+
+	data, err := ioutil.ReadFile(passwordFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Parse decodes and validates a YAML configuration
+func Parse(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Validate checks that the configuration is complete and consistent
+func (c *Config) Validate() error {
+	if err := c.Hub.validate(); err != nil {
+		return fmt.Errorf("hub: %s", err)
+	}
+
+	if c.Polling.Interval < 0 || c.Polling.MaxAge < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+
+	if c.Bandwidth.RetentionDays < 0 {
+		return fmt.Errorf("bandwidth: retention_days must not be negative")
+	}
+
+	targets := make(map[string]bool)
+	for i, target := range c.Targets {
+		if target.Name == "" {
+			return fmt.Errorf("target %d: name is required", i+1)
+		}
+
+		if targets[target.Name] {
+			return fmt.Errorf("target %s: duplicate target name", target.Name)
+		}
+		targets[target.Name] = true
+
+		if target.Address == "" {
+			return fmt.Errorf("target %s: address is required", target.Name)
+		}
+
+		if err := target.HubConfig.validate(); err != nil {
+			return fmt.Errorf("target %s: %s", target.Name, err)
+		}
+	}
+
+	if err := c.Notifications.validate(); err != nil {
+		return fmt.Errorf("notifications: %s", err)
+	}
+
+	if err := c.MQTT.validate(); err != nil {
+		return fmt.Errorf("mqtt: %s", err)
+	}
+
+	for label := range c.Labels {
+		if !labelNamePattern.MatchString(label) {
+			return fmt.Errorf("labels: invalid label name %q", label)
+		}
+	}
+
+	names := make(map[string]bool)
+	for i := range c.Metrics {
+		metric := &c.Metrics[i]
+		if err := metric.validate(); err != nil {
+			return fmt.Errorf("metric %d (%s): %s", i+1, metric.Name, err)
+		}
+
+		if names[metric.Name] {
+			return fmt.Errorf("metric %d (%s): duplicate metric name", i+1, metric.Name)
+		}
+		names[metric.Name] = true
+
+		for label := range metric.Labels {
+			if _, ok := c.Labels[label]; ok {
+				return fmt.Errorf("metric %d (%s): label %s is already added to every metric by the labels section", i+1, metric.Name, label)
+			}
+		}
+	}
+	return nil
+}
+
+func (h *HubConfig) validate() error {
+	switch h.Scheme {
+	case "", "http", "https":
+	default:
+		return fmt.Errorf("invalid scheme %q. Must be http or https", h.Scheme)
+	}
+
+	if h.Timeout < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+
+	switch h.PasswordFormat {
+	case "", "plain":
+	case "md5":
+		if h.Password != "" && !md5Pattern.MatchString(h.Password) {
+			return fmt.Errorf("password is not an MD5 hash")
+		}
+	default:
+		return fmt.Errorf("invalid password format %q. Must be plain or md5", h.PasswordFormat)
+	}
+	return nil
+}
+
+func (n *NotificationsConfig) validate() error {
+	if n.DedupWindow < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+
+	for i, webhook := range n.Webhooks {
+		if err := webhook.validate(); err != nil {
+			return fmt.Errorf("webhook %d: %s", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (w *Webhook) validate() error {
+	webhookURL, err := url.Parse(w.URL)
+	if err != nil || (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") || webhookURL.Host == "" {
+		return fmt.Errorf("invalid url %q", w.URL)
+	}
+
+	switch w.Format {
+	case "", "json", "slack", "ntfy":
+	default:
+		return fmt.Errorf("invalid format %q. Must be json, slack or ntfy", w.Format)
+	}
+
+	for _, event := range w.Events {
+		switch event {
+		case "device_joined", "device_left", "device_changed":
+		default:
+			return fmt.Errorf("invalid event %q. Must be device_joined, device_left or device_changed", event)
+		}
+	}
+
+	if w.Timeout < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+
+	if w.MaxRetries != nil && *w.MaxRetries < 0 {
+		return fmt.Errorf("max_retries must not be negative")
+	}
+	return nil
+}
+
+func (m *MQTTConfig) validate() error {
+	if m.Broker != "" {
+		brokerURL, err := url.Parse(m.Broker)
+		if err != nil || brokerURL.Host == "" {
+			return fmt.Errorf("invalid broker %q", m.Broker)
+		}
+
+		switch brokerURL.Scheme {
+		case "tcp", "mqtt", "ssl", "tls", "mqtts":
+		default:
+			return fmt.Errorf("invalid broker scheme %q. Must be tcp, mqtt, ssl, tls or mqtts", brokerURL.Scheme)
+		}
+	}
+
+	if m.QoS != 0 && m.QoS != 1 {
+		return fmt.Errorf("invalid qos %d. Must be 0 or 1", m.QoS)
+	}
+
+	if (m.CertFile == "") != (m.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file must be set together")
+	}
+
+	if m.Timeout < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+
+	for _, prefix := range []string{m.TopicPrefix, m.DiscoveryPrefix} {
+		if strings.ContainsAny(prefix, "+#") {
+			return fmt.Errorf("invalid topic prefix %q. Must not contain wildcards", prefix)
+		}
+	}
+	return nil
+}
+
+func (m *Metric) validate() error {
+	if !metricNamePattern.MatchString(m.Name) {
+		return fmt.Errorf("invalid metric name %q", m.Name)
+	}
+
+	if m.XPath == "" {
+		return fmt.Errorf("xpath is required")
+	}
+
+	if m.Help == "" {
+		m.Help = fmt.Sprintf("Value of the Home Hub %s XPath", m.XPath)
+	}
+
+	switch m.Type {
+	case "":
+		m.Type = MetricTypeGauge
+	case MetricTypeGauge, MetricTypeCounter, MetricTypeInfo:
+	default:
+		return fmt.Errorf("unknown type %q. Must be gauge, counter or info", m.Type)
+	}
+
+	switch m.Transform {
+	case TransformNone, TransformKbpsToBps, TransformMbpsToBps:
+	case TransformScale:
+		if m.Scale == 0 {
+			return fmt.Errorf("the scale transform requires a non zero scale")
+		}
+	case TransformEnum:
+		if len(m.Mapping) == 0 {
+			return fmt.Errorf("the enum transform requires a mapping")
+		}
+	default:
+		return fmt.Errorf("unknown transform %q", m.Transform)
+	}
+
+	if m.Type == MetricTypeInfo && m.Transform != TransformNone {
+		return fmt.Errorf("info metrics do not support transforms")
+	}
+
+	for label, field := range m.Labels {
+		if !labelNamePattern.MatchString(label) {
+			return fmt.Errorf("invalid label name %q", label)
+		}
+		if field == "" {
+			return fmt.Errorf("label %s has no field", label)
+		}
+		if m.Type == MetricTypeInfo && label == InfoValueLabel {
+			return fmt.Errorf("label %s is reserved for the value of info metrics", label)
+		}
+	}
+
+	// Labels make the XPath list valued, and only info metrics can be exported without a value for each entry
+	if m.Type != MetricTypeInfo && len(m.Labels) > 0 && m.ValueField == "" {
+		return fmt.Errorf("value_field is required for %s metrics with labels", m.Type)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	config, err := Parse([]byte(`
+metrics:
+  - name: bt_homehub_model_info
+    xpath: Device/DeviceInfo/ModelName
+    type: info
+  - name: bt_homehub_ethernet_port_up
+    help: Whether the Ethernet port is up
+    xpath: Device/Ethernet/Interfaces
+    value_field: Status
+    transform: enum
+    mapping:
+      Up: 1
+      Down: 0
+    labels:
+      port: Alias
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(config.Metrics) != 2 {
+		t.Fatalf("Expected 2 metrics. Got %d", len(config.Metrics))
+	}
+
+	if config.Metrics[0].Help == "" {
+		t.Fatal("Expected a default help text")
+	}
+
+	if config.Metrics[1].Type != MetricTypeGauge {
+		t.Fatalf("Expected metric type to default to gauge. Got %s", config.Metrics[1].Type)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := map[string]string{
+		"invalid metric name":                 "metrics:\n  - name: bt-homehub\n    xpath: Device/DeviceInfo/UpTime",
+		"xpath is required":                   "metrics:\n  - name: bt_homehub_uptime",
+		"unknown type":                        "metrics:\n  - name: bt_homehub_uptime\n    xpath: Device/DeviceInfo/UpTime\n    type: histogram",
+		"unknown transform":                   "metrics:\n  - name: bt_homehub_uptime\n    xpath: Device/DeviceInfo/UpTime\n    transform: double",
+		"non zero scale":                      "metrics:\n  - name: bt_homehub_uptime\n    xpath: Device/DeviceInfo/UpTime\n    transform: scale",
+		"requires a mapping":                  "metrics:\n  - name: bt_homehub_uptime\n    xpath: Device/DeviceInfo/UpTime\n    transform: enum",
+		"invalid label name":                  "metrics:\n  - name: bt_homehub_uptime\n    xpath: Device/Hosts/Hosts\n    labels:\n      host-name: HostName",
+		"invalid scheme":                      "hub:\n  scheme: ftp",
+		"labels: invalid label":               "labels:\n  hub-name: home",
+		"duplicate metric":                    "metrics:\n  - name: bt_homehub_uptime\n    xpath: Device/DeviceInfo/UpTime\n  - name: bt_homehub_uptime\n    xpath: Device/DeviceInfo/UpTime",
+		"already added to every":              "labels:\n  port: lan\nmetrics:\n  - name: bt_homehub_port_up\n    xpath: Device/Ethernet/Interfaces\n    value_field: Status\n    labels:\n      port: Alias",
+		"retention_days must not be negative": "bandwidth:\n  retention_days: -1",
+		"value_field is required":             "metrics:\n  - name: bt_homehub_port_up\n    xpath: Device/Ethernet/Interfaces\n    labels:\n      port: Alias",
+		"reserved for the value":              "metrics:\n  - name: bt_homehub_port_info\n    xpath: Device/Ethernet/Interfaces\n    type: info\n    value_field: Status\n    labels:\n      value: Alias",
+		"field xpaht not found":               "metrics:\n  - name: bt_homehub_uptime\n    xpaht: Device/DeviceInfo/UpTime",
+		"name is required":                    "targets:\n  - address: 192.168.1.254",
+		"address is required":                 "targets:\n  - name: home",
+		"duplicate target":                    "targets:\n  - name: home\n    address: 192.168.1.254\n  - name: home\n    address: 192.168.2.254",
+		"target home: invalid":                "targets:\n  - name: home\n    address: 192.168.1.254\n    scheme: ftp",
+		"not an MD5 hash":                     "hub:\n  password: secret\n  password_format: md5",
+		"invalid password":                    "hub:\n  password_format: sha1",
+		"webhook 1: invalid url":              "notifications:\n  webhooks:\n    - url: ftp://example.com",
+		"invalid format":                      "notifications:\n  webhooks:\n    - url: https://example.com\n      format: xml",
+		"invalid event":                       "notifications:\n  webhooks:\n    - url: https://example.com\n      events: [device_moved]",
+		"max_retries must not":                "notifications:\n  webhooks:\n    - url: https://example.com\n      max_retries: -1",
+		"invalid broker scheme":               "mqtt:\n  broker: http://localhost:1883",
+		"invalid qos":                         "mqtt:\n  broker: tcp://localhost:1883\n  qos: 2",
+		"set together":                        "mqtt:\n  broker: ssl://localhost:8883\n  cert_file: client.crt",
+		"invalid topic prefix":                "mqtt:\n  broker: tcp://localhost:1883\n  topic_prefix: homehub/#",
+	}
+
+	for expected, data := range tests {
+		_, err := Parse([]byte(data))
+		if err == nil {
+			t.Fatalf("Expected error containing %q", expected)
+		}
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected error containing %q. Got %s", expected, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "password"), []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	configFile := filepath.Join(dir, "config.yml")
+	data := `
+hub:
+  address: 192.168.1.1
+  scheme: https
+  username: admin
+  password_file: password
+  timeout: 5s
+polling:
+  interval: 1m
+web:
+  listen_address: :9999
+labels:
+  hub: home
+notifications:
+  allowlist_file: allowlist
+  webhooks:
+    - url: https://ntfy.sh/homehub
+      format: ntfy
+    - url: https://example.com/hook
+      max_retries: 0
+mqtt:
+  broker: tcp://localhost:1883
+  username: exporter
+  password_file: password
+  retain: true
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "allowlist"), []byte("# Phones\naa:bb:cc:dd:ee:01\n\nAA:BB:CC:DD:EE:02\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(configFile, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := Load(configFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if config.Hub.Password != "secret" {
+		t.Fatalf("Expected password to be read from password_file. Got %q", config.Hub.Password)
+	}
+
+	if config.Hub.Timeout != 5*time.Second || config.Polling.Interval != time.Minute {
+		t.Fatalf("Unexpected durations: %s, %s", config.Hub.Timeout, config.Polling.Interval)
+	}
+
+	if config.Web.ListenAddress != ":9999" || config.Labels["hub"] != "home" {
+		t.Fatalf("Unexpected configuration: %+v", config)
+	}
+
+	if config.MQTT.Broker != "tcp://localhost:1883" || config.MQTT.Password != "secret" || !config.MQTT.Retain {
+		t.Fatalf("Unexpected MQTT configuration: %+v", config.MQTT)
+	}
+
+	// An explicit max_retries: 0 turns retries off, while an unset max_retries keeps the default
+	webhooks := config.Notifications.Webhooks
+	if len(webhooks) != 2 || webhooks[0].MaxRetries != nil || webhooks[1].MaxRetries == nil || *webhooks[1].MaxRetries != 0 {
+		t.Fatalf("Unexpected webhooks: %+v", webhooks)
+	}
+
+	allowlist := config.Notifications.Allowlist
+	if len(allowlist) != 2 || !allowlist["AA:BB:CC:DD:EE:01"] || !allowlist["AA:BB:CC:DD:EE:02"] {
+		t.Fatalf("Unexpected allowlist: %v", allowlist)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "allowlist"), []byte("aa:bb:cc:dd:ee\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(configFile); err == nil || !strings.Contains(err.Error(), "invalid MAC address") {
+		t.Fatalf("Expected invalid MAC address error. Got %v", err)
+	}
+
+	if err := ioutil.WriteFile(configFile, []byte(data+"  extra: label\nunknown: true\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(configFile); err == nil {
+		t.Fatal("Expected error loading configuration with unknown fields")
+	}
+
+	if err := ioutil.WriteFile(configFile, []byte("hub:\n  password: a\n  password_file: password\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(configFile); err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Fatalf("Expected password and password_file to be mutually exclusive. Got %v", err)
+	}
+}
+
+func TestTargets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "office-password"), []byte("office-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	configFile := filepath.Join(dir, "config.yml")
+	data := `
+hub:
+  username: admin
+  password: secret
+  scheme: https
+  timeout: 5s
+  wan_interface: 3
+targets:
+  - name: home
+    address: 192.168.1.254
+  - name: office
+    address: 10.0.0.254
+    scheme: http
+    password_file: office-password
+`
+	if err := ioutil.WriteFile(configFile, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := Load(configFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if _, ok := config.Target("shop"); ok {
+		t.Fatal("Expected target shop to not exist")
+	}
+
+	home, ok := config.Target("home")
+	if !ok {
+		t.Fatal("Expected target home to exist")
+	}
+
+	hub := home.Hub(config.Hub)
+	if hub.Address != "192.168.1.254" || hub.Scheme != "https" || hub.Username != "admin" || hub.Password != "secret" || hub.Timeout != 5*time.Second {
+		t.Fatalf("Expected target home to inherit hub settings. Got %+v", hub)
+	}
+
+	if hub.WANInterface != 0 {
+		t.Fatalf("Expected the WAN interface override to not be inherited. Got %d", hub.WANInterface)
+	}
+
+	office, _ := config.Target("office")
+	hub = office.Hub(config.Hub)
+	if hub.Address != "10.0.0.254" || hub.Scheme != "http" || hub.Password != "office-secret" {
+		t.Fatalf("Expected target office settings to take precedence. Got %+v", hub)
+	}
+}
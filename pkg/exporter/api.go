@@ -0,0 +1,85 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+)
+
+// BandwidthHandler returns a handler that serves the daily bandwidth statistics collected for each device.
+// The optional query parameters are:
+//
+//	mac    - only return statistics for the device with this MAC address
+//	from   - only return statistics on or after this date (YYYY-MM-DD)
+//	to     - only return statistics on or before this date (YYYY-MM-DD)
+//	format - json (default) or csv
+func (e *Exporter) BandwidthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		from, err := parseQueryDate(query.Get("from"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid from date: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		to, err := parseQueryDate(query.Get("to"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid to date: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		records := e.bandwidth.records(strings.ToUpper(query.Get("mac")), from, to)
+
+		switch query.Get("format") {
+		case "", "json":
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(records); err != nil {
+				slog.ErrorContext(r.Context(), "Error writing bandwidth statistics", "format", "json", "err", err)
+			}
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv")
+			if err := writeBandwidthCSV(w, records); err != nil {
+				slog.ErrorContext(r.Context(), "Error writing bandwidth statistics", "format", "csv", "err", err)
+			}
+		default:
+			http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		}
+	})
+}
+
+func parseQueryDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(client.BandwidthRecordDateFormat, value)
+}
+
+func writeBandwidthCSV(w http.ResponseWriter, records []client.BandwidthRecord) error {
+	writer := csv.NewWriter(w)
+
+	//nolint:golint,errcheck
+	writer.Write([]string{"serial_number", "mac_address", "date", "downloaded_megabytes", "uploaded_megabytes"})
+
+	for _, record := range records {
+		row := []string{
+			record.SerialNumber,
+			record.MACAddress,
+			record.Date.Format(client.BandwidthRecordDateFormat),
+			strconv.FormatFloat(record.Downloaded, 'f', -1, 64),
+			strconv.FormatFloat(record.Uploaded, 'f', -1, 64),
+		}
+		//nolint:golint,errcheck
+		writer.Write(append(row, record.Extra...))
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
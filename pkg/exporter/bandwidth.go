@@ -0,0 +1,334 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultBandwidthRetention is the number of days before the most recent day that bandwidth statistics are kept for
+const defaultBandwidthRetention = 365
+
+// bandwidthStore accumulates daily bandwidth statistics for each device, so that only new days need to be
+// fetched from the Home Hub. The statistics can optionally be persisted to a file to survive restarts
+type bandwidthStore struct {
+	mutex sync.Mutex
+	file  string
+	// retention is the number of days before the most recent day that are kept. Older days are dropped, so that
+	// the history does not grow forever. All days are kept if it is 0
+	retention int
+	days      map[string]map[string]*client.BandwidthRecord
+	totals    map[string]*deviceBandwidthStatistics
+}
+
+type bandwidthState struct {
+	Days map[string]map[string]bandwidthUsage `json:"days"`
+}
+
+type bandwidthUsage struct {
+	SerialNumber string   `json:"serialNumber,omitempty"`
+	Downloaded   float64  `json:"downloaded"`
+	Uploaded     float64  `json:"uploaded"`
+	Extra        []string `json:"extra,omitempty"`
+}
+
+func newBandwidthStore(file string, retention int) (*bandwidthStore, error) {
+	store := &bandwidthStore{
+		file:      file,
+		retention: retention,
+		days:      make(map[string]map[string]*client.BandwidthRecord),
+		totals:    make(map[string]*deviceBandwidthStatistics),
+	}
+
+	if file == "" {
+		return store, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return store, err
+	}
+
+	var state bandwidthState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return store, err
+	}
+
+	for day, devices := range state.Days {
+		date, err := time.Parse(client.BandwidthRecordDateFormat, day)
+		if err != nil {
+			return store, err
+		}
+
+		store.days[day] = make(map[string]*client.BandwidthRecord)
+		for macAddress, usage := range devices {
+			store.days[day][macAddress] = &client.BandwidthRecord{
+				SerialNumber: usage.SerialNumber,
+				MACAddress:   macAddress,
+				Date:         date,
+				Downloaded:   usage.Downloaded,
+				Uploaded:     usage.Uploaded,
+				Extra:        usage.Extra,
+			}
+		}
+	}
+	store.prune()
+	store.updateTotals()
+
+	return store, nil
+}
+
+// LatestBandwidthDay returns the most recent day held in the bandwidth state file, or the zero time if there is no
+// state. Clients can resume downloading statistics from this day rather than downloading the full history again
+func LatestBandwidthDay(file string) (time.Time, error) {
+	store, err := newBandwidthStore(file, 0)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return store.latestDay(), nil
+}
+
+// update merges the statistics from a bandwidth monitoring file into the store. Any day present in the
+// file replaces the previously stored statistics for that day
+func (s *bandwidthStore) update(statistics string) {
+	days := make(map[string]map[string]*client.BandwidthRecord)
+
+	for _, record := range client.ParseBandwidthRecords(statistics) {
+		record := record
+		day := record.Date.Format(client.BandwidthRecordDateFormat)
+
+		devices := days[day]
+		if devices == nil {
+			devices = make(map[string]*client.BandwidthRecord)
+			days[day] = devices
+		}
+
+		if existing := devices[record.MACAddress]; existing != nil {
+			existing.Downloaded += record.Downloaded
+			existing.Uploaded += record.Uploaded
+		} else {
+			devices[record.MACAddress] = &record
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for day, devices := range days {
+		s.days[day] = devices
+	}
+	s.prune()
+	s.updateTotals()
+}
+
+// prune drops the days that are more than the retention period before the most recent day. The most recent day is
+// used rather than the current time, so that the Home Hub's clock decides which days are kept. The caller must hold
+// the mutex
+func (s *bandwidthStore) prune() {
+	if s.retention <= 0 {
+		return
+	}
+
+	oldest := s.latest().AddDate(0, 0, -s.retention).Format(client.BandwidthRecordDateFormat)
+	for day := range s.days {
+		if day < oldest {
+			delete(s.days, day)
+		}
+	}
+}
+
+// deviceTotals returns the total bandwidth statistics for each device across all stored days
+func (s *bandwidthStore) deviceTotals() map[string]*deviceBandwidthStatistics {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	totals := make(map[string]*deviceBandwidthStatistics, len(s.totals))
+	for macAddress, statistics := range s.totals {
+		total := *statistics
+		totals[macAddress] = &total
+	}
+	return totals
+}
+
+// day returns the bandwidth statistics for each device on the given date
+func (s *bandwidthStore) day(date time.Time) map[string]client.BandwidthRecord {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	records := make(map[string]client.BandwidthRecord)
+	for macAddress, record := range s.days[date.Format(client.BandwidthRecordDateFormat)] {
+		records[macAddress] = *record
+	}
+	return records
+}
+
+// latestDay returns the most recent day in the store, or the zero time if the store is empty
+func (s *bandwidthStore) latestDay() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.latest()
+}
+
+// latest returns the most recent day in the store. The caller must hold the mutex
+func (s *bandwidthStore) latest() time.Time {
+	var latest time.Time
+	for _, devices := range s.days {
+		for _, record := range devices {
+			if record.Date.After(latest) {
+				latest = record.Date
+			}
+			break
+		}
+	}
+	return latest
+}
+
+// records returns the stored daily statistics between from and to inclusive, ordered by date and MAC address.
+// If macAddress is not empty, only records for that device are returned. Zero from and to dates are unbounded
+func (s *bandwidthStore) records(macAddress string, from time.Time, to time.Time) []client.BandwidthRecord {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	records := []client.BandwidthRecord{}
+	for _, devices := range s.days {
+		for _, record := range devices {
+			if macAddress != "" && record.MACAddress != macAddress {
+				continue
+			}
+			if !from.IsZero() && record.Date.Before(from) {
+				continue
+			}
+			if !to.IsZero() && record.Date.After(to) {
+				continue
+			}
+			records = append(records, *record)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Date.Equal(records[j].Date) {
+			return records[i].MACAddress < records[j].MACAddress
+		}
+		return records[i].Date.Before(records[j].Date)
+	})
+
+	return records
+}
+
+// save writes the stored statistics to the state file, if one is configured
+func (s *bandwidthStore) save() error {
+	if s.file == "" {
+		return nil
+	}
+
+	s.mutex.Lock()
+	state := bandwidthState{Days: make(map[string]map[string]bandwidthUsage, len(s.days))}
+	for day, devices := range s.days {
+		state.Days[day] = make(map[string]bandwidthUsage, len(devices))
+		for macAddress, record := range devices {
+			state.Days[day][macAddress] = bandwidthUsage{
+				SerialNumber: record.SerialNumber,
+				Downloaded:   record.Downloaded,
+				Uploaded:     record.Uploaded,
+				Extra:        record.Extra,
+			}
+		}
+	}
+	s.mutex.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeStateFile(s.file, data)
+}
+
+// writeStateFile writes to a temporary file first so that a crash cannot leave a truncated state file behind
+func writeStateFile(file string, data []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), file)
+}
+
+func (s *bandwidthStore) updateTotals() {
+	s.totals = make(map[string]*deviceBandwidthStatistics)
+	for _, devices := range s.days {
+		for macAddress, record := range devices {
+			total := s.totals[macAddress]
+			if total == nil {
+				total = &deviceBandwidthStatistics{macAddress: macAddress}
+				s.totals[macAddress] = total
+			}
+			total.downloaded += record.Downloaded
+			total.uploaded += record.Uploaded
+		}
+	}
+}
+
+// collectBandwidth downloads new bandwidth statistics from the Home Hub. Device usage is only reported for
+// connected devices, so it is omitted if the summary statistics could not be retrieved
+func (e *Exporter) collectBandwidth(ctx context.Context, s *summary, channel chan<- prometheus.Metric) error {
+	bandwidthStatistics := e.client.GetBandwidthStatistics(ctx)
+	if bandwidthStatistics.Error != nil {
+		return bandwidthStatistics.Error
+	}
+
+	parseStart := time.Now()
+	e.bandwidth.update(bandwidthStatistics.Body)
+	channel <- prometheus.MustNewConstMetric(e.metricDescriptions["bandwidthFileSize"], prometheus.GaugeValue, float64(len(bandwidthStatistics.Body)))
+	channel <- prometheus.MustNewConstMetric(e.metricDescriptions["bandwidthParseDuration"], prometheus.GaugeValue, time.Since(parseStart).Seconds())
+
+	if err := e.bandwidth.save(); err != nil {
+		slog.ErrorContext(ctx, "Error saving bandwidth statistics", "file", e.bandwidthStateFile, "err", err)
+	}
+
+	if s.err != nil {
+		return nil
+	}
+
+	// Devices that could not be decoded are reported by the devices collector
+	devices, _ := e.connectedDevices(s)
+
+	today := e.bandwidth.day(time.Now())
+	for macAddress, statistics := range e.bandwidth.deviceTotals() {
+		device := devices[macAddress]
+		if device == nil {
+			continue
+		}
+
+		labelValues := []string{device.hostName, device.ipAddress, device.macAddress}
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["deviceUploadedMegabytes"], prometheus.GaugeValue, statistics.uploaded, labelValues...)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["deviceDownloadedMegabytes"], prometheus.GaugeValue, statistics.downloaded, labelValues...)
+
+		usage := today[device.macAddress]
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["deviceUploadedTodayMegabytes"], prometheus.GaugeValue, usage.Uploaded, labelValues...)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["deviceDownloadedTodayMegabytes"], prometheus.GaugeValue, usage.Downloaded, labelValues...)
+	}
+	return nil
+}
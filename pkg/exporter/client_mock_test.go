@@ -5,6 +5,7 @@
 package exporter
 
 import (
+	context "context"
 	reflect "reflect"
 
 	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
@@ -36,37 +37,85 @@ func (m *MockClient) EXPECT() *MockClientMockRecorder {
 }
 
 // Login mocks base method
-func (m *MockClient) Login() *client.Response {
-	ret := m.ctrl.Call(m, "Login")
+func (m *MockClient) Login(ctx context.Context) *client.Response {
+	ret := m.ctrl.Call(m, "Login", ctx)
 	ret0, _ := ret[0].(*client.Response)
 	return ret0
 }
 
 // Login indicates an expected call of Login
-func (mr *MockClientMockRecorder) Login() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Login", reflect.TypeOf((*MockClient)(nil).Login))
+func (mr *MockClientMockRecorder) Login(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Login", reflect.TypeOf((*MockClient)(nil).Login), ctx)
 }
 
 // GetSummaryStatistics mocks base method
-func (m *MockClient) GetSummaryStatistics() *client.Response {
-	ret := m.ctrl.Call(m, "GetSummaryStatistics")
+func (m *MockClient) GetSummaryStatistics(ctx context.Context, xpaths ...string) *client.Response {
+	varargs := []interface{}{ctx}
+	for _, a := range xpaths {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetSummaryStatistics", varargs...)
 	ret0, _ := ret[0].(*client.Response)
 	return ret0
 }
 
 // GetSummaryStatistics indicates an expected call of GetSummaryStatistics
-func (mr *MockClientMockRecorder) GetSummaryStatistics() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSummaryStatistics", reflect.TypeOf((*MockClient)(nil).GetSummaryStatistics))
+func (mr *MockClientMockRecorder) GetSummaryStatistics(ctx interface{}, xpaths ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{ctx}, xpaths...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSummaryStatistics", reflect.TypeOf((*MockClient)(nil).GetSummaryStatistics), varargs...)
 }
 
 // GetBandwidthStatistics mocks base method
-func (m *MockClient) GetBandwidthStatistics() *client.Response {
-	ret := m.ctrl.Call(m, "GetBandwidthStatistics")
+func (m *MockClient) GetBandwidthStatistics(ctx context.Context) *client.Response {
+	ret := m.ctrl.Call(m, "GetBandwidthStatistics", ctx)
 	ret0, _ := ret[0].(*client.Response)
 	return ret0
 }
 
 // GetBandwidthStatistics indicates an expected call of GetBandwidthStatistics
-func (mr *MockClientMockRecorder) GetBandwidthStatistics() *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBandwidthStatistics", reflect.TypeOf((*MockClient)(nil).GetBandwidthStatistics))
+func (mr *MockClientMockRecorder) GetBandwidthStatistics(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBandwidthStatistics", reflect.TypeOf((*MockClient)(nil).GetBandwidthStatistics), ctx)
+}
+
+// GetDevices mocks base method
+func (m *MockClient) GetDevices(ctx context.Context) ([]client.DeviceDetail, error) {
+	ret := m.ctrl.Call(m, "GetDevices", ctx)
+	ret0, _ := ret[0].([]client.DeviceDetail)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDevices indicates an expected call of GetDevices
+func (mr *MockClientMockRecorder) GetDevices(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDevices", reflect.TypeOf((*MockClient)(nil).GetDevices), ctx)
+}
+
+// GetValues mocks base method
+func (m *MockClient) GetValues(ctx context.Context, xpaths ...string) (map[string]client.Value, error) {
+	varargs := []interface{}{ctx}
+	for _, a := range xpaths {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetValues", varargs...)
+	ret0, _ := ret[0].(map[string]client.Value)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetValues indicates an expected call of GetValues
+func (mr *MockClientMockRecorder) GetValues(ctx interface{}, xpaths ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{ctx}, xpaths...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetValues", reflect.TypeOf((*MockClient)(nil).GetValues), varargs...)
+}
+
+// Relogins mocks base method
+func (m *MockClient) Relogins() int {
+	ret := m.ctrl.Call(m, "Relogins")
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// Relogins indicates an expected call of Relogins
+func (mr *MockClientMockRecorder) Relogins() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Relogins", reflect.TypeOf((*MockClient)(nil).Relogins))
 }
@@ -0,0 +1,175 @@
+package exporter
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collector collects a group of related metrics. Collectors that depend on the summary statistics fail
+// without being run if the summary statistics could not be retrieved
+type collector struct {
+	name    string
+	summary bool
+	collect func(e *Exporter, ctx context.Context, s *summary, channel chan<- prometheus.Metric) error
+}
+
+// collectors are run in this order on every scrape
+var collectors = []collector{
+	{"system", true, (*Exporter).collectSystem},
+	{"wan", true, (*Exporter).collectWAN},
+	{"dsl", true, (*Exporter).collectDSL},
+	{"interface", true, (*Exporter).collectInterfaces},
+	{"wifi", true, (*Exporter).collectWiFi},
+	{"devices", true, (*Exporter).collectDevices},
+	{"presence", true, (*Exporter).collectPresence},
+	{"bandwidth", false, (*Exporter).collectBandwidth},
+	{"custom", true, (*Exporter).collectCustom},
+}
+
+// CollectorNames returns the names of the collectors that can be enabled with WithCollectors
+func CollectorNames() []string {
+	names := make([]string, 0, len(collectors))
+	for _, c := range collectors {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// UsesSummaryStatistics returns whether the named collector reads the summary statistics. These collectors share a
+// single request to the Home Hub, so they all fail when that request fails
+func UsesSummaryStatistics(name string) bool {
+	for _, c := range collectors {
+		if c.name == name {
+			return c.summary
+		}
+	}
+	return false
+}
+
+// summary holds the values returned by a summary statistics request keyed by XPath. The tables that are used
+// by more than one collector are decoded on first use
+type summary struct {
+	err     error
+	values  map[string]interface{}
+	devices map[string]*device
+	hosts   map[string]*device
+	wifi    *wifiStatus
+}
+
+func newSummary(response *client.Response) *summary {
+	s := &summary{
+		err:    response.Error,
+		values: make(map[string]interface{}),
+	}
+
+	if response.Error != nil || response.ResponseBody.Reply == nil {
+		return s
+	}
+
+	for _, action := range response.ResponseBody.Reply.ResponseActions {
+		if len(action.ResponseCallbacks) == 0 {
+			continue
+		}
+		s.values[action.ResponseCallbacks[0].XPath] = action.ResponseCallbacks[0].Parameters.Value
+	}
+	return s
+}
+
+// wifiStatus returns the Wi-Fi radios, SSIDs and access points
+func (s *summary) wifiStatus() *wifiStatus {
+	if s.wifi == nil {
+		s.wifi = &wifiStatus{}
+		for xpath, value := range s.values {
+			s.wifi.update(xpath, value)
+		}
+	}
+	return s.wifi
+}
+
+// connectedDevices returns the active WiFi and Ethernet devices keyed by MAC address. Malformed entries are
+// skipped and counted as parse errors. Inactive devices that the Home Hub still knows about are kept in s.hosts
+func (e *Exporter) connectedDevices(s *summary) (map[string]*device, error) {
+	if s.devices != nil {
+		return s.devices, nil
+	}
+
+	s.devices = make(map[string]*device)
+	s.hosts = make(map[string]*device)
+
+	value, ok := s.values[client.ConnectedDevices]
+	if !ok {
+		return s.devices, nil
+	}
+
+	deviceDetails, malformed, err := client.DecodeDevices(value)
+	if err != nil {
+		malformed++
+	}
+	e.addParseErrors(malformed)
+
+	for _, deviceDetail := range deviceDetails {
+		device := newDevice(deviceDetail)
+		if device.deviceType != "WiFi" && device.deviceType != "Ethernet" {
+			continue
+		}
+
+		s.hosts[device.macAddress] = device
+		if device.active {
+			s.devices[device.macAddress] = device
+		}
+	}
+	return s.devices, err
+}
+
+// runCollectors runs each enabled collector, reporting whether it succeeded and how long it took
+func (e *Exporter) runCollectors(ctx context.Context, s *summary, channel chan<- prometheus.Metric) {
+	for _, c := range e.collectors {
+		start := time.Now()
+
+		err := s.err
+		if !c.summary || err == nil {
+			err = c.collect(e, ctx, s, channel)
+			if err != nil {
+				slog.WarnContext(ctx, "Error collecting metrics", "collector", c.name, "err", err)
+			}
+		}
+
+		success := 1.0
+		if err != nil {
+			success = 0
+		}
+
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["collectorSuccess"], prometheus.GaugeValue, success, c.name)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["collectorDuration"], prometheus.GaugeValue, time.Since(start).Seconds(), c.name)
+	}
+}
+
+func (e *Exporter) collectSystem(ctx context.Context, s *summary, channel chan<- prometheus.Metric) error {
+	if uptime, ok := toFloat(s.values[client.UpTime]); ok {
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["uptime"], prometheus.GaugeValue, uptime)
+	}
+
+	if firmware, ok := s.values[client.FirmwareVersion].(string); ok {
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["build"], prometheus.GaugeValue, 1, firmware)
+	}
+	return nil
+}
+
+func (e *Exporter) collectDevices(ctx context.Context, s *summary, channel chan<- prometheus.Metric) error {
+	devices, err := e.connectedDevices(s)
+	if err != nil {
+		return err
+	}
+
+	for macAddress, wifiClient := range s.wifiStatus().clients() {
+		if device := devices[macAddress]; device != nil && device.deviceType == "WiFi" {
+			e.collectWiFiDevice(device, wifiClient, channel)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// customMetric is a user defined metric read from a Home Hub XPath
+type customMetric struct {
+	config      config.Metric
+	description *prometheus.Desc
+	valueType   prometheus.ValueType
+	labelNames  []string
+	labelFields []string
+}
+
+func newCustomMetric(metric config.Metric) *customMetric {
+	m := &customMetric{
+		config:    metric,
+		valueType: prometheus.GaugeValue,
+	}
+
+	if metric.Type == config.MetricTypeCounter {
+		m.valueType = prometheus.CounterValue
+	}
+
+	for labelName := range metric.Labels {
+		m.labelNames = append(m.labelNames, labelName)
+	}
+	sort.Strings(m.labelNames)
+
+	for _, labelName := range m.labelNames {
+		m.labelFields = append(m.labelFields, metric.Labels[labelName])
+	}
+
+	labelNames := m.labelNames
+	if metric.Type == config.MetricTypeInfo && (!m.listValued() || metric.ValueField != "") {
+		labelNames = append(append([]string{}, labelNames...), config.InfoValueLabel)
+	}
+
+	m.description = prometheus.NewDesc(metric.Name, metric.Help, labelNames, nil)
+	return m
+}
+
+// CheckCustomMetrics returns an error if the user defined metrics cannot be exported alongside the built in metrics
+// and the constant labels, for example because a metric name is already used by the exporter. The metrics must have
+// been validated
+func CheckCustomMetrics(metrics []config.Metric, labels map[string]string) error {
+	var builtIn descriptionCollector
+	for _, description := range createMetricDescriptions() {
+		builtIn = append(builtIn, description)
+	}
+
+	registerer := prometheus.WrapRegistererWith(labels, prometheus.NewRegistry())
+	if err := registerer.Register(builtIn); err != nil {
+		return err
+	}
+	if err := registerer.Register(client.NewMetrics()); err != nil {
+		return err
+	}
+
+	for _, metric := range metrics {
+		if err := registerer.Register(descriptionCollector{newCustomMetric(metric).description}); err != nil {
+			return fmt.Errorf("metric %s: %s", metric.Name, err)
+		}
+	}
+	return nil
+}
+
+// descriptionCollector describes metrics without collecting them
+type descriptionCollector []*prometheus.Desc
+
+func (c descriptionCollector) Describe(channel chan<- *prometheus.Desc) {
+	for _, description := range c {
+		channel <- description
+	}
+}
+
+func (c descriptionCollector) Collect(chan<- prometheus.Metric) {}
+
+// newCustomMetrics creates the user defined metrics grouped by XPath, along with the XPaths in configuration order
+func newCustomMetrics(metrics []config.Metric) (map[string][]*customMetric, []string) {
+	customMetrics := make(map[string][]*customMetric)
+	var xpaths []string
+
+	for _, metric := range metrics {
+		if _, present := customMetrics[metric.XPath]; !present {
+			xpaths = append(xpaths, metric.XPath)
+		}
+		customMetrics[metric.XPath] = append(customMetrics[metric.XPath], newCustomMetric(metric))
+	}
+	return customMetrics, xpaths
+}
+
+// collectCustom emits the user defined metrics for the XPaths returned by the Home Hub
+func (e *Exporter) collectCustom(ctx context.Context, s *summary, channel chan<- prometheus.Metric) error {
+	for xpath, customMetrics := range e.customMetrics {
+		value, ok := s.values[xpath]
+		if !ok {
+			continue
+		}
+
+		for _, customMetric := range customMetrics {
+			e.addParseErrors(customMetric.collect(value, channel))
+		}
+	}
+	return nil
+}
+
+// listValued returns true if the XPath is expected to return a list of entries rather than a single value
+func (m *customMetric) listValued() bool {
+	return m.config.ValueField != "" || len(m.config.Labels) > 0
+}
+
+// collect emits the metric for the value returned by the Home Hub and returns the number of values that
+// could not be converted
+func (m *customMetric) collect(value interface{}, channel chan<- prometheus.Metric) int {
+	if !m.listValued() {
+		if m.config.Type == config.MetricTypeInfo {
+			channel <- prometheus.MustNewConstMetric(m.description, prometheus.GaugeValue, 1, stringValue(value))
+			return 0
+		}
+
+		floatValue, ok := m.transform(value)
+		if !ok {
+			return 1
+		}
+		channel <- prometheus.MustNewConstMetric(m.description, m.valueType, floatValue)
+		return 0
+	}
+
+	entries, ok := value.([]interface{})
+	if !ok {
+		return 1
+	}
+
+	parseErrors := 0
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		fields, ok := entry.(map[string]interface{})
+		if !ok {
+			parseErrors++
+			continue
+		}
+
+		labelValues := make([]string, 0, len(m.labelFields)+1)
+		for _, field := range m.labelFields {
+			labelValues = append(labelValues, stringValue(fieldValue(fields, field)))
+		}
+
+		var metric prometheus.Metric
+		if m.config.Type == config.MetricTypeInfo {
+			if m.config.ValueField != "" {
+				labelValues = append(labelValues, stringValue(fieldValue(fields, m.config.ValueField)))
+			}
+			metric = prometheus.MustNewConstMetric(m.description, prometheus.GaugeValue, 1, labelValues...)
+		} else {
+			floatValue, ok := m.transform(fieldValue(fields, m.config.ValueField))
+			if !ok {
+				parseErrors++
+				continue
+			}
+			metric = prometheus.MustNewConstMetric(m.description, m.valueType, floatValue, labelValues...)
+		}
+
+		// Entries with identical labels cannot be exported more than once
+		key := strings.Join(labelValues, "\xff")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		channel <- metric
+	}
+	return parseErrors
+}
+
+// transform converts a value returned by the Home Hub to a float as configured for the metric
+func (m *customMetric) transform(value interface{}) (float64, bool) {
+	if m.config.Transform == config.TransformEnum {
+		floatValue, ok := m.config.Mapping[stringValue(value)]
+		return floatValue, ok
+	}
+
+	floatValue, ok := toFloat(value)
+	if !ok {
+		return 0, false
+	}
+
+	switch m.config.Transform {
+	case config.TransformKbpsToBps:
+		floatValue *= 1000
+	case config.TransformMbpsToBps:
+		floatValue *= 1000000
+	case config.TransformScale:
+		floatValue *= m.config.Scale
+	}
+	return floatValue, true
+}
+
+// fieldValue returns the value of a field, where nested fields are separated by /
+func fieldValue(fields map[string]interface{}, field string) interface{} {
+	var value interface{} = fields
+	for _, name := range strings.Split(field, "/") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+func stringValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
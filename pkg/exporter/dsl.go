@@ -0,0 +1,64 @@
+package exporter
+
+import (
+	"context"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dslMetric describes how a DSL line quality value returned by the Home Hub maps to a Prometheus metric
+type dslMetric struct {
+	description string
+	valueType   prometheus.ValueType
+	direction   string
+	scale       float64
+}
+
+// Noise margin and attenuation are reported by the Home Hub in tenths of a dB
+var dslMetrics = map[string]dslMetric{
+	client.DSLDownstreamNoiseMargin:  {"dslNoiseMargin", prometheus.GaugeValue, "downstream", 0.1},
+	client.DSLUpstreamNoiseMargin:    {"dslNoiseMargin", prometheus.GaugeValue, "upstream", 0.1},
+	client.DSLDownstreamAttenuation:  {"dslAttenuation", prometheus.GaugeValue, "downstream", 0.1},
+	client.DSLUpstreamAttenuation:    {"dslAttenuation", prometheus.GaugeValue, "upstream", 0.1},
+	client.DSLDownstreamMaxRate:      {"dslMaxRate", prometheus.GaugeValue, "downstream", 1},
+	client.DSLUpstreamMaxRate:        {"dslMaxRate", prometheus.GaugeValue, "upstream", 1},
+	client.DSLErroredSeconds:         {"dslErroredSeconds", prometheus.CounterValue, "", 1},
+	client.DSLSeverelyErroredSeconds: {"dslSeverelyErroredSeconds", prometheus.CounterValue, "", 1},
+	client.DSLResyncs:                {"dslResyncs", prometheus.CounterValue, "", 1},
+	client.DSLDownstreamCRCErrors:    {"dslCRCErrors", prometheus.CounterValue, "downstream", 1},
+	client.DSLUpstreamCRCErrors:      {"dslCRCErrors", prometheus.CounterValue, "upstream", 1},
+	client.DSLDownstreamFECErrors:    {"dslFECErrors", prometheus.CounterValue, "downstream", 1},
+	client.DSLUpstreamFECErrors:      {"dslFECErrors", prometheus.CounterValue, "upstream", 1},
+	client.DSLDownstreamHECErrors:    {"dslHECErrors", prometheus.CounterValue, "downstream", 1},
+	client.DSLUpstreamHECErrors:      {"dslHECErrors", prometheus.CounterValue, "upstream", 1},
+}
+
+func (m dslMetric) newConstMetric(metricDescriptions map[string]*prometheus.Desc, value float64) prometheus.Metric {
+	var labelValues []string
+	if m.direction != "" {
+		labelValues = append(labelValues, m.direction)
+	}
+	return prometheus.MustNewConstMetric(metricDescriptions[m.description], m.valueType, value*m.scale, labelValues...)
+}
+
+func (e *Exporter) collectDSL(ctx context.Context, s *summary, channel chan<- prometheus.Metric) error {
+	if status, ok := s.values[client.DSLStatus].(string); ok {
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["dslStatus"], prometheus.GaugeValue, 1, status)
+	}
+
+	if downloadRate, ok := toFloat(s.values[client.DownloadRate]); ok {
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["downloadRateMbps"], prometheus.GaugeValue, downloadRate)
+	}
+	if uploadRate, ok := toFloat(s.values[client.UploadRate]); ok {
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["uploadRateMbps"], prometheus.GaugeValue, uploadRate)
+	}
+
+	for xpath, metric := range dslMetrics {
+		if value, ok := toFloat(s.values[xpath]); ok {
+			channel <- metric.newConstMetric(e.metricDescriptions, value)
+		}
+	}
+	return nil
+}
@@ -1,12 +1,13 @@
 package exporter
 
 import (
-	"log"
-	"reflect"
-	"strconv"
-	"strings"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/notify"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -15,14 +16,69 @@ import (
 type Exporter struct {
 	client             client.Client
 	metricDescriptions map[string]*prometheus.Desc
+	pollInterval       time.Duration
+	maxSnapshotAge     time.Duration
+	bandwidthStateFile string
+	bandwidthRetention int
+	bandwidth          *bandwidthStore
+	presenceStateFile  string
+	presence           *presenceStore
+	notifier           *notify.Notifier
+	publisher          Publisher
+	customMetrics      map[string][]*customMetric
+	customXPaths       []string
+	collectors         []collector
+	clientMetrics      *client.Metrics
+
+	// running tracks the background polling goroutine started by Start and any scrape in progress when polling is
+	// disabled
+	running sync.WaitGroup
+
+	mutex              sync.Mutex
+	stopped            <-chan struct{}
+	inflight           *inflightScrape
+	snapshot           *snapshot
+	lastSuccessfulPoll time.Time
+	wanSeen            bool
+	wanConnected       bool
+	wanUptime          float64
+	wanReconnections   float64
+	parseErrors        float64
 }
 
 // New creates an instance of a Home Hub exporter
-func New(client client.Client) *Exporter {
-	return &Exporter{
+func New(client client.Client, options ...Option) *Exporter {
+	e := &Exporter{
 		client:             client,
 		metricDescriptions: createMetricDescriptions(),
+		collectors:         collectors,
+	}
+
+	for _, option := range options {
+		option(e)
+	}
+
+	if e.bandwidthRetention <= 0 {
+		e.bandwidthRetention = defaultBandwidthRetention
+	}
+
+	bandwidth, err := newBandwidthStore(e.bandwidthStateFile, e.bandwidthRetention)
+	if err != nil {
+		slog.Error("Error loading bandwidth statistics", "file", e.bandwidthStateFile, "err", err)
+	}
+	e.bandwidth = bandwidth
+
+	presence, err := newPresenceStore(e.presenceStateFile)
+	if err != nil {
+		slog.Error("Error loading device presence", "file", e.presenceStateFile, "err", err)
+	}
+	e.presence = presence
+
+	if e.pollInterval > 0 && e.maxSnapshotAge <= 0 {
+		e.maxSnapshotAge = defaultMaxSnapshotAgeIntervals * e.pollInterval
 	}
+
+	return e
 }
 
 // Describe - loops through the API metrics and passes them to prometheus.Describe
@@ -30,88 +86,96 @@ func (e *Exporter) Describe(channel chan<- *prometheus.Desc) {
 	for _, metricDescription := range e.metricDescriptions {
 		channel <- metricDescription
 	}
+
+	for _, customMetrics := range e.customMetrics {
+		for _, customMetric := range customMetrics {
+			channel <- customMetric.description
+		}
+	}
+
+	if e.clientMetrics != nil {
+		e.clientMetrics.Describe(channel)
+	}
 }
 
 // Collect function, called on by Prometheus Client library
 // This function is called when a scrape is performed on the /metrics page
 func (e *Exporter) Collect(channel chan<- prometheus.Metric) {
-	var devices = make(map[string]*device)
+	e.collectCached(context.Background(), channel)
+}
 
-	summaryStatistics := e.client.GetSummaryStatistics()
-	bandwidthStatistics := e.client.GetBandwidthStatistics()
+// WithContext returns a collector that uses ctx for requests made to the Home Hub, so that
+// scrape timeouts and cancellation are propagated to the router
+func (e *Exporter) WithContext(ctx context.Context) prometheus.Collector {
+	return &contextCollector{exporter: e, ctx: ctx}
+}
 
-	if summaryStatistics.Error != nil || bandwidthStatistics.Error != nil {
-		log.Println("Error fetching metrics from Home Hub")
-		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["up"], prometheus.GaugeValue, 0)
-		return
-	}
+type contextCollector struct {
+	exporter *Exporter
+	ctx      context.Context
+}
 
-	for _, action := range summaryStatistics.ResponseBody.Reply.ResponseActions {
-		value := reflect.ValueOf(action.ResponseCallbacks[0].Parameters.Value)
-
-		switch action.ResponseCallbacks[0].XPath {
-		case client.ConnectedDevices:
-			deviceDetails := value.Interface().([]interface{})
-			for _, v := range deviceDetails {
-				device := newDevice(v.(map[string]interface{}))
-				if device.active && (device.deviceType == "WiFi" || device.deviceType == "Ethernet") {
-					devices[device.macAddress] = device
-				}
-			}
-		case client.DownloadedBytes:
-			floatValue, err := strconv.ParseFloat(value.String(), 64)
-			if err == nil {
-				channel <- prometheus.MustNewConstMetric(e.metricDescriptions["downloadBytes"], prometheus.GaugeValue, floatValue)
-			}
-		case client.DownloadRate:
-			channel <- prometheus.MustNewConstMetric(e.metricDescriptions["downloadRateMbps"], prometheus.GaugeValue, value.Float())
-		case client.FirmwareVersion:
-			channel <- prometheus.MustNewConstMetric(e.metricDescriptions["build"], prometheus.GaugeValue, 1, value.String())
-		case client.UploadedBytes:
-			floatValue, err := strconv.ParseFloat(value.String(), 64)
-			if err == nil {
-				channel <- prometheus.MustNewConstMetric(e.metricDescriptions["uploadBytes"], prometheus.GaugeValue, floatValue)
-			}
-		case client.UploadRate:
-			channel <- prometheus.MustNewConstMetric(e.metricDescriptions["uploadRateMbps"], prometheus.GaugeValue, value.Float())
-		case client.UpTime:
-			channel <- prometheus.MustNewConstMetric(e.metricDescriptions["uptime"], prometheus.GaugeValue, value.Float())
-		}
+func (c *contextCollector) Describe(channel chan<- *prometheus.Desc) {
+	c.exporter.Describe(channel)
+}
+
+func (c *contextCollector) Collect(channel chan<- prometheus.Metric) {
+	c.exporter.collectCached(c.ctx, channel)
+}
+
+// collect fetches metrics from the Home Hub and returns true if the Home Hub could be scraped successfully.
+// Collectors that fail do not prevent the metrics of the other collectors from being reported
+func (e *Exporter) collect(ctx context.Context, channel chan<- prometheus.Metric) bool {
+	var xpaths []string
+	if e.collectorEnabled("custom") {
+		xpaths = e.customXPaths
 	}
 
-	for _, line := range strings.Split(bandwidthStatistics.Body, "\n") {
-		statistics := newDeviceBandwidthStatistics(line)
-		if statistics == nil {
-			continue
-		}
+	s := newSummary(e.client.GetSummaryStatistics(ctx, xpaths...))
+	if s.err != nil {
+		slog.ErrorContext(ctx, "Error fetching metrics from Home Hub", "err", s.err)
+	}
 
-		device := devices[statistics.macAddress]
-		if device == nil {
-			continue
-		}
+	e.runCollectors(ctx, s, channel)
 
-		if device.bandwidthStatistics == nil {
-			if device.active {
-				device.bandwidthStatistics = statistics
-			}
-		} else {
-			device.bandwidthStatistics.downloaded += statistics.downloaded
-			device.bandwidthStatistics.uploaded += statistics.uploaded
-		}
+	up := 1.0
+	if s.err != nil {
+		up = 0
 	}
 
-	for _, device := range devices {
-		if device.bandwidthStatistics != nil {
-			channel <- prometheus.MustNewConstMetric(e.metricDescriptions["deviceUploadedMegabytes"], prometheus.GaugeValue, device.bandwidthStatistics.uploaded, device.hostName, device.ipAddress, device.macAddress)
-			channel <- prometheus.MustNewConstMetric(e.metricDescriptions["deviceDownloadedMegabytes"], prometheus.GaugeValue, device.bandwidthStatistics.downloaded, device.hostName, device.ipAddress, device.macAddress)
+	channel <- prometheus.MustNewConstMetric(e.metricDescriptions["relogins"], prometheus.CounterValue, float64(e.client.Relogins()))
+	channel <- prometheus.MustNewConstMetric(e.metricDescriptions["parseErrors"], prometheus.CounterValue, e.totalParseErrors())
+	channel <- prometheus.MustNewConstMetric(e.metricDescriptions["up"], prometheus.GaugeValue, up)
+	return s.err == nil
+}
+
+func (e *Exporter) collectorEnabled(name string) bool {
+	for _, c := range e.collectors {
+		if c.name == name {
+			return true
 		}
 	}
+	return false
+}
+
+// addParseErrors counts malformed entries returned by the Home Hub
+func (e *Exporter) addParseErrors(count int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.parseErrors += float64(count)
+}
 
-	channel <- prometheus.MustNewConstMetric(e.metricDescriptions["up"], prometheus.GaugeValue, 1)
+func (e *Exporter) totalParseErrors() float64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.parseErrors
 }
 
 func createMetricDescriptions() map[string]*prometheus.Desc {
 	deviceLabels := []string{"host_name", "ip_address", "mac_address"}
+	wifiDeviceLabels := []string{"host_name", "ip_address", "mac_address", "band", "ssid"}
+	directionLabels := []string{"direction"}
+	collectorLabels := []string{"collector"}
 
 	metricDescriptions := make(map[string]*prometheus.Desc)
 	metricDescriptions["uptime"] = prometheus.NewDesc(
@@ -124,6 +188,24 @@ func createMetricDescriptions() map[string]*prometheus.Desc {
 		prometheus.BuildFQName("bt", "homehub", "device_uploaded_megabytes"), "Total megabytes downloaded by the device", deviceLabels, nil)
 	metricDescriptions["deviceDownloadedMegabytes"] = prometheus.NewDesc(
 		prometheus.BuildFQName("bt", "homehub", "device_downloaded_megabytes"), "Total megabytes uploaded by the device", deviceLabels, nil)
+	metricDescriptions["deviceUploadedTodayMegabytes"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "device_uploaded_today_megabytes"), "Megabytes uploaded by the device today", deviceLabels, nil)
+	metricDescriptions["deviceDownloadedTodayMegabytes"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "device_downloaded_today_megabytes"), "Megabytes downloaded by the device today", deviceLabels, nil)
+	metricDescriptions["deviceConnected"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "device_connected"), "Whether the device is connected to the router", deviceLabels, nil)
+	metricDescriptions["deviceConnections"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "device_connections_total"), "Number of times the device has connected to the router", deviceLabels, nil)
+	metricDescriptions["deviceFirstSeen"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "device_first_seen_timestamp_seconds"), "Unix timestamp of when the device was first seen connected to the router", deviceLabels, nil)
+	metricDescriptions["deviceLastSeen"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "device_last_seen_timestamp_seconds"), "Unix timestamp of when the device was last seen connected to the router", deviceLabels, nil)
+	metricDescriptions["deviceSignal"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "device_signal_dbm"), "Wi-Fi signal strength of the device", wifiDeviceLabels, nil)
+	metricDescriptions["deviceTxRate"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "device_tx_rate_mbps"), "Data rate most recently used to transmit to the device over Wi-Fi", wifiDeviceLabels, nil)
+	metricDescriptions["deviceRxRate"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "device_rx_rate_mbps"), "Data rate most recently used to receive from the device over Wi-Fi", wifiDeviceLabels, nil)
 	metricDescriptions["build"] = prometheus.NewDesc(
 		prometheus.BuildFQName("bt", "homehub", "build_info"), "Route build information", []string{"firmware"}, nil)
 	metricDescriptions["up"] = prometheus.NewDesc(
@@ -132,5 +214,89 @@ func createMetricDescriptions() map[string]*prometheus.Desc {
 		prometheus.BuildFQName("bt", "homehub", "download_bytes_total"), "Bytes downloaded from the internet", nil, nil)
 	metricDescriptions["uploadBytes"] = prometheus.NewDesc(
 		prometheus.BuildFQName("bt", "homehub", "upload_bytes_total"), "Bytes uploaded to the internet", nil, nil)
+	metricDescriptions["relogins"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "relogins_total"), "Number of times the exporter logged in again after the router session expired", nil, nil)
+	metricDescriptions["bandwidthFileSize"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "bandwidth_file_size_bytes"), "Size of the most recently downloaded bandwidth monitoring file", nil, nil)
+	metricDescriptions["bandwidthParseDuration"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "bandwidth_parse_duration_seconds"), "Time taken to parse the most recently downloaded bandwidth monitoring file", nil, nil)
+	metricDescriptions["dslStatus"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "dsl_line_status"), "Status of the DSL line", []string{"status"}, nil)
+	metricDescriptions["dslNoiseMargin"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "dsl_noise_margin_db"), "Signal to noise ratio margin of the DSL line", directionLabels, nil)
+	metricDescriptions["dslAttenuation"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "dsl_attenuation_db"), "Attenuation of the DSL line", directionLabels, nil)
+	metricDescriptions["dslMaxRate"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "dsl_max_attainable_rate_kbps"), "Maximum attainable rate of the DSL line", directionLabels, nil)
+	metricDescriptions["dslErroredSeconds"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "dsl_errored_seconds_total"), "Number of seconds with errors on the DSL line", nil, nil)
+	metricDescriptions["dslSeverelyErroredSeconds"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "dsl_severely_errored_seconds_total"), "Number of seconds with severe errors on the DSL line", nil, nil)
+	metricDescriptions["dslResyncs"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "dsl_resyncs_total"), "Number of times the DSL line has resynchronised", nil, nil)
+	metricDescriptions["dslCRCErrors"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "dsl_crc_errors_total"), "Number of CRC errors on the DSL channel", directionLabels, nil)
+	metricDescriptions["dslFECErrors"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "dsl_fec_errors_total"), "Number of FEC errors on the DSL channel", directionLabels, nil)
+	metricDescriptions["dslHECErrors"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "dsl_hec_errors_total"), "Number of HEC errors on the DSL channel", directionLabels, nil)
+	metricDescriptions["wanUp"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wan_up"), "Whether the WAN connection is connected", nil, nil)
+	metricDescriptions["wanConnectionStatus"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wan_connection_status"), "Status of the WAN connection", []string{"status"}, nil)
+	metricDescriptions["wanLastConnectionError"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wan_last_connection_error"), "Reason for the last WAN connection failure", []string{"error"}, nil)
+	metricDescriptions["wanUptime"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wan_connection_uptime_seconds"), "Time since the WAN connection was established", nil, nil)
+	metricDescriptions["wanInfo"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wan_info"), "WAN connection addresses assigned by the ISP", []string{"ipv4_address", "ipv6_address", "dns_servers"}, nil)
+	metricDescriptions["wanReconnections"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wan_reconnections_total"), "Number of times the WAN connection has been re-established since the exporter started", nil, nil)
+	metricDescriptions["interfaceUp"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "interface_up"), "Whether the IP interface is up", interfaceLabels, nil)
+	metricDescriptions["interfaceReceiveBytes"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "interface_receive_bytes_total"), "Number of bytes received on the IP interface", interfaceLabels, nil)
+	metricDescriptions["interfaceTransmitBytes"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "interface_transmit_bytes_total"), "Number of bytes transmitted on the IP interface", interfaceLabels, nil)
+	metricDescriptions["interfaceReceivePackets"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "interface_receive_packets_total"), "Number of packets received on the IP interface", interfaceLabels, nil)
+	metricDescriptions["interfaceTransmitPackets"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "interface_transmit_packets_total"), "Number of packets transmitted on the IP interface", interfaceLabels, nil)
+	metricDescriptions["interfaceReceiveErrors"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "interface_receive_errors_total"), "Number of receive errors on the IP interface", interfaceLabels, nil)
+	metricDescriptions["interfaceTransmitErrors"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "interface_transmit_errors_total"), "Number of transmit errors on the IP interface", interfaceLabels, nil)
+	metricDescriptions["wifiRadioEnabled"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wifi_radio_enabled"), "Whether the Wi-Fi radio is enabled", radioLabels, nil)
+	metricDescriptions["wifiRadioChannel"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wifi_radio_channel"), "Current channel of the Wi-Fi radio", radioLabels, nil)
+	metricDescriptions["wifiRadioBandwidth"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wifi_radio_bandwidth_mhz"), "Operating channel bandwidth of the Wi-Fi radio", radioLabels, nil)
+	metricDescriptions["wifiRadioTransmitPower"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wifi_radio_transmit_power_percent"), "Transmit power of the Wi-Fi radio as a percentage of its maximum", radioLabels, nil)
+	metricDescriptions["wifiRadioNoise"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wifi_radio_noise_dbm"), "Average noise received by the Wi-Fi radio", radioLabels, nil)
+	metricDescriptions["wifiSSIDAssociatedClients"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wifi_ssid_associated_clients"), "Number of clients associated with the SSID", ssidLabels, nil)
+	metricDescriptions["wifiSSIDReceiveBytes"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wifi_ssid_receive_bytes_total"), "Number of bytes received on the SSID", ssidLabels, nil)
+	metricDescriptions["wifiSSIDTransmitBytes"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wifi_ssid_transmit_bytes_total"), "Number of bytes transmitted on the SSID", ssidLabels, nil)
+	metricDescriptions["wifiSSIDReceivePackets"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wifi_ssid_receive_packets_total"), "Number of packets received on the SSID", ssidLabels, nil)
+	metricDescriptions["wifiSSIDTransmitPackets"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wifi_ssid_transmit_packets_total"), "Number of packets transmitted on the SSID", ssidLabels, nil)
+	metricDescriptions["wifiSSIDReceiveErrors"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wifi_ssid_receive_errors_total"), "Number of receive errors on the SSID", ssidLabels, nil)
+	metricDescriptions["wifiSSIDTransmitErrors"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "wifi_ssid_transmit_errors_total"), "Number of transmit errors on the SSID", ssidLabels, nil)
+	metricDescriptions["parseErrors"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "parse_errors_total"), "Number of malformed entries returned by the router that were skipped", nil, nil)
+	metricDescriptions["collectorSuccess"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "scrape_collector_success"), "Whether the collector succeeded", collectorLabels, nil)
+	metricDescriptions["collectorDuration"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "scrape_collector_duration_seconds"), "Time taken by the collector", collectorLabels, nil)
+	metricDescriptions["lastSuccessfulPoll"] = prometheus.NewDesc(
+		prometheus.BuildFQName("bt", "homehub", "last_successful_poll_timestamp_seconds"), "Unix timestamp of the last successful collection of metrics from the router", nil, nil)
 	return metricDescriptions
 }
@@ -2,17 +2,30 @@ package exporter
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/config"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/notify"
 
 	gomock "github.com/golang/mock/gomock"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 )
 
 func TestMetricsScrapeSuccess(t *testing.T) {
@@ -23,8 +36,9 @@ func TestMetricsScrapeSuccess(t *testing.T) {
 	defer ctrl.Finish()
 	defer prometheus.Unregister(exporter)
 
-	client.EXPECT().GetSummaryStatistics().Return(createSummaryStatisticsResponse())
-	client.EXPECT().GetBandwidthStatistics().Return(createBandwidthStatisticsResponse())
+	client.EXPECT().GetSummaryStatistics(gomock.Any()).Return(createSummaryStatisticsResponse())
+	client.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(createBandwidthStatisticsResponse())
+	client.EXPECT().Relogins().Return(2)
 
 	response, err := scrapeMetrics(exporter, t.Name())
 	if err != nil {
@@ -38,28 +52,145 @@ func TestMetricsScrapeSuccess(t *testing.T) {
 	defer response.Body.Close()
 	scanner := bufio.NewScanner(response.Body)
 
-	expectedMetrics := `bt_homehub_build_info{firmware="ABC123"} 1
+	expectedMetrics := `bt_homehub_bandwidth_file_size_bytes 1287
+	bt_homehub_build_info{firmware="ABC123"} 1
+	bt_homehub_device_connected{host_name="Alias 3",ip_address="192.168.1.3",mac_address="AA:BB:CC:DD:EE:F3"} 1
+	bt_homehub_device_connected{host_name="Host Name 1",ip_address="192.168.1.1",mac_address="AA:BB:CC:DD:EE:F1"} 1
+	bt_homehub_device_connected{host_name="Host Name 2",ip_address="192.168.1.2",mac_address="AA:BB:CC:DD:EE:F2"} 1
+	bt_homehub_device_connected{host_name="Host Name 4",ip_address="192.168.1.4",mac_address="AA:BB:CC:DD:EE:F4"} 1
+	bt_homehub_device_connected{host_name="User Host Name 5",ip_address="192.168.1.5",mac_address="AA:BB:CC:DD:EE:F5"} 1
+	bt_homehub_device_connected{host_name="User Host Name 6",ip_address="192.168.1.6",mac_address="AA:BB:CC:DD:EE:F6"} 1
+	bt_homehub_device_connections_total{host_name="Alias 3",ip_address="192.168.1.3",mac_address="AA:BB:CC:DD:EE:F3"} 1
+	bt_homehub_device_connections_total{host_name="Host Name 1",ip_address="192.168.1.1",mac_address="AA:BB:CC:DD:EE:F1"} 1
+	bt_homehub_device_connections_total{host_name="Host Name 2",ip_address="192.168.1.2",mac_address="AA:BB:CC:DD:EE:F2"} 1
+	bt_homehub_device_connections_total{host_name="Host Name 4",ip_address="192.168.1.4",mac_address="AA:BB:CC:DD:EE:F4"} 1
+	bt_homehub_device_connections_total{host_name="User Host Name 5",ip_address="192.168.1.5",mac_address="AA:BB:CC:DD:EE:F5"} 1
+	bt_homehub_device_connections_total{host_name="User Host Name 6",ip_address="192.168.1.6",mac_address="AA:BB:CC:DD:EE:F6"} 1
+	bt_homehub_device_connected{host_name="Host Name 8",ip_address="192.168.1.8",mac_address="AA:BB:CC:DD:EE:F8"} 0
+	bt_homehub_device_connected{host_name="Host Name 9",ip_address="192.168.1.9",mac_address="AA:BB:CC:DD:EE:F9"} 0
+	bt_homehub_device_connected{host_name="Host Name 10",ip_address="192.168.1.10",mac_address="AA:BB:CC:DD:EE:F10"} 0
+	bt_homehub_device_connections_total{host_name="Host Name 8",ip_address="192.168.1.8",mac_address="AA:BB:CC:DD:EE:F8"} 0
+	bt_homehub_device_connections_total{host_name="Host Name 9",ip_address="192.168.1.9",mac_address="AA:BB:CC:DD:EE:F9"} 0
+	bt_homehub_device_connections_total{host_name="Host Name 10",ip_address="192.168.1.10",mac_address="AA:BB:CC:DD:EE:F10"} 0
 	bt_homehub_device_downloaded_megabytes{host_name="Alias 3",ip_address="192.168.1.3",mac_address="AA:BB:CC:DD:EE:F3"} 1000
 	bt_homehub_device_downloaded_megabytes{host_name="Host Name 1",ip_address="192.168.1.1",mac_address="AA:BB:CC:DD:EE:F1"} 600
 	bt_homehub_device_downloaded_megabytes{host_name="Host Name 2",ip_address="192.168.1.2",mac_address="AA:BB:CC:DD:EE:F2"} 300
 	bt_homehub_device_downloaded_megabytes{host_name="Host Name 4",ip_address="192.168.1.4",mac_address="AA:BB:CC:DD:EE:F4"} 300
 	bt_homehub_device_downloaded_megabytes{host_name="User Host Name 5",ip_address="192.168.1.5",mac_address="AA:BB:CC:DD:EE:F5"} 100
 	bt_homehub_device_downloaded_megabytes{host_name="User Host Name 6",ip_address="192.168.1.6",mac_address="AA:BB:CC:DD:EE:F6"} 1000
+	bt_homehub_device_downloaded_today_megabytes{host_name="Alias 3",ip_address="192.168.1.3",mac_address="AA:BB:CC:DD:EE:F3"} 0
+	bt_homehub_device_downloaded_today_megabytes{host_name="Host Name 1",ip_address="192.168.1.1",mac_address="AA:BB:CC:DD:EE:F1"} 0
+	bt_homehub_device_downloaded_today_megabytes{host_name="Host Name 2",ip_address="192.168.1.2",mac_address="AA:BB:CC:DD:EE:F2"} 0
+	bt_homehub_device_downloaded_today_megabytes{host_name="Host Name 4",ip_address="192.168.1.4",mac_address="AA:BB:CC:DD:EE:F4"} 0
+	bt_homehub_device_downloaded_today_megabytes{host_name="User Host Name 5",ip_address="192.168.1.5",mac_address="AA:BB:CC:DD:EE:F5"} 0
+	bt_homehub_device_downloaded_today_megabytes{host_name="User Host Name 6",ip_address="192.168.1.6",mac_address="AA:BB:CC:DD:EE:F6"} 0
 	bt_homehub_device_uploaded_megabytes{host_name="Alias 3",ip_address="192.168.1.3",mac_address="AA:BB:CC:DD:EE:F3"} 100
 	bt_homehub_device_uploaded_megabytes{host_name="Host Name 1",ip_address="192.168.1.1",mac_address="AA:BB:CC:DD:EE:F1"} 60
 	bt_homehub_device_uploaded_megabytes{host_name="Host Name 2",ip_address="192.168.1.2",mac_address="AA:BB:CC:DD:EE:F2"} 30
 	bt_homehub_device_uploaded_megabytes{host_name="Host Name 4",ip_address="192.168.1.4",mac_address="AA:BB:CC:DD:EE:F4"} 30
 	bt_homehub_device_uploaded_megabytes{host_name="User Host Name 5",ip_address="192.168.1.5",mac_address="AA:BB:CC:DD:EE:F5"} 10
 	bt_homehub_device_uploaded_megabytes{host_name="User Host Name 6",ip_address="192.168.1.6",mac_address="AA:BB:CC:DD:EE:F6"} 100
+	bt_homehub_device_uploaded_today_megabytes{host_name="Alias 3",ip_address="192.168.1.3",mac_address="AA:BB:CC:DD:EE:F3"} 0
+	bt_homehub_device_uploaded_today_megabytes{host_name="Host Name 1",ip_address="192.168.1.1",mac_address="AA:BB:CC:DD:EE:F1"} 0
+	bt_homehub_device_uploaded_today_megabytes{host_name="Host Name 2",ip_address="192.168.1.2",mac_address="AA:BB:CC:DD:EE:F2"} 0
+	bt_homehub_device_uploaded_today_megabytes{host_name="Host Name 4",ip_address="192.168.1.4",mac_address="AA:BB:CC:DD:EE:F4"} 0
+	bt_homehub_device_uploaded_today_megabytes{host_name="User Host Name 5",ip_address="192.168.1.5",mac_address="AA:BB:CC:DD:EE:F5"} 0
+	bt_homehub_device_uploaded_today_megabytes{host_name="User Host Name 6",ip_address="192.168.1.6",mac_address="AA:BB:CC:DD:EE:F6"} 0
+	bt_homehub_device_rx_rate_mbps{band="2.4GHz",host_name="User Host Name 5",ip_address="192.168.1.5",mac_address="AA:BB:CC:DD:EE:F5",ssid="BTHub"} 65
+	bt_homehub_device_signal_dbm{band="2.4GHz",host_name="User Host Name 5",ip_address="192.168.1.5",mac_address="AA:BB:CC:DD:EE:F5",ssid="BTHub"} -71
+	bt_homehub_device_tx_rate_mbps{band="2.4GHz",host_name="User Host Name 5",ip_address="192.168.1.5",mac_address="AA:BB:CC:DD:EE:F5",ssid="BTHub"} 72.2
 	bt_homehub_download_bytes_total 654321
 	bt_homehub_download_rate_mbps 123.45
+	bt_homehub_dsl_attenuation_db{direction="downstream"} 18.5
+	bt_homehub_dsl_attenuation_db{direction="upstream"} 12
+	bt_homehub_dsl_crc_errors_total{direction="downstream"} 1234
+	bt_homehub_dsl_crc_errors_total{direction="upstream"} 56
+	bt_homehub_dsl_errored_seconds_total 12
+	bt_homehub_dsl_fec_errors_total{direction="downstream"} 98765
+	bt_homehub_dsl_fec_errors_total{direction="upstream"} 43
+	bt_homehub_dsl_hec_errors_total{direction="downstream"} 7
+	bt_homehub_dsl_hec_errors_total{direction="upstream"} 0
+	bt_homehub_dsl_line_status{status="Up"} 1
+	bt_homehub_dsl_max_attainable_rate_kbps{direction="downstream"} 79999
+	bt_homehub_dsl_max_attainable_rate_kbps{direction="upstream"} 20000
+	bt_homehub_dsl_noise_margin_db{direction="downstream"} 6.2
+	bt_homehub_dsl_noise_margin_db{direction="upstream"} 5.5
+	bt_homehub_dsl_resyncs_total 2
+	bt_homehub_dsl_severely_errored_seconds_total 3
+	bt_homehub_interface_receive_bytes_total{interface="4"} 0
+	bt_homehub_interface_receive_bytes_total{interface="IP_BR_LAN"} 2000
+	bt_homehub_interface_receive_bytes_total{interface="IP_DATA"} 654321
+	bt_homehub_interface_receive_errors_total{interface="4"} 0
+	bt_homehub_interface_receive_errors_total{interface="IP_BR_LAN"} 1
+	bt_homehub_interface_receive_errors_total{interface="IP_DATA"} 3
+	bt_homehub_interface_receive_packets_total{interface="4"} 0
+	bt_homehub_interface_receive_packets_total{interface="IP_BR_LAN"} 20
+	bt_homehub_interface_receive_packets_total{interface="IP_DATA"} 400
+	bt_homehub_interface_transmit_bytes_total{interface="4"} 0
+	bt_homehub_interface_transmit_bytes_total{interface="IP_BR_LAN"} 1000
+	bt_homehub_interface_transmit_bytes_total{interface="IP_DATA"} 123456
+	bt_homehub_interface_transmit_errors_total{interface="4"} 0
+	bt_homehub_interface_transmit_errors_total{interface="IP_BR_LAN"} 0
+	bt_homehub_interface_transmit_errors_total{interface="IP_DATA"} 2
+	bt_homehub_interface_transmit_packets_total{interface="4"} 0
+	bt_homehub_interface_transmit_packets_total{interface="IP_BR_LAN"} 10
+	bt_homehub_interface_transmit_packets_total{interface="IP_DATA"} 300
+	bt_homehub_interface_up{interface="4"} 0
+	bt_homehub_interface_up{interface="IP_BR_LAN"} 1
+	bt_homehub_interface_up{interface="IP_DATA"} 1
+	bt_homehub_parse_errors_total 0
+	bt_homehub_relogins_total 2
+	bt_homehub_scrape_collector_success{collector="bandwidth"} 1
+	bt_homehub_scrape_collector_success{collector="custom"} 1
+	bt_homehub_scrape_collector_success{collector="devices"} 1
+	bt_homehub_scrape_collector_success{collector="dsl"} 1
+	bt_homehub_scrape_collector_success{collector="interface"} 1
+	bt_homehub_scrape_collector_success{collector="presence"} 1
+	bt_homehub_scrape_collector_success{collector="system"} 1
+	bt_homehub_scrape_collector_success{collector="wan"} 1
+	bt_homehub_scrape_collector_success{collector="wifi"} 1
 	bt_homehub_up 1
 	bt_homehub_upload_bytes_total 123456
 	bt_homehub_upload_rate_mbps 543.21
-	bt_homehub_uptime_seconds 9.8765421e+07`
+	bt_homehub_uptime_seconds 9.8765421e+07
+	bt_homehub_wan_connection_status{status="Connected"} 1
+	bt_homehub_wan_connection_uptime_seconds 3600
+	bt_homehub_wan_info{dns_servers="1.1.1.1,8.8.8.8",ipv4_address="81.2.3.4",ipv6_address="2a00::1"} 1
+	bt_homehub_wan_last_connection_error{error="ERROR_NONE"} 1
+	bt_homehub_wan_reconnections_total 0
+	bt_homehub_wan_up 1
+	bt_homehub_wifi_radio_bandwidth_mhz{band="2.4GHz",radio="1"} 20
+	bt_homehub_wifi_radio_channel{band="2.4GHz",radio="1"} 6
+	bt_homehub_wifi_radio_channel{band="5GHz",radio="2"} 36
+	bt_homehub_wifi_radio_enabled{band="2.4GHz",radio="1"} 1
+	bt_homehub_wifi_radio_enabled{band="5GHz",radio="2"} 0
+	bt_homehub_wifi_radio_noise_dbm{band="2.4GHz",radio="1"} -92
+	bt_homehub_wifi_radio_noise_dbm{band="5GHz",radio="2"} -95
+	bt_homehub_wifi_radio_transmit_power_percent{band="2.4GHz",radio="1"} 100
+	bt_homehub_wifi_radio_transmit_power_percent{band="5GHz",radio="2"} 75
+	bt_homehub_wifi_ssid_associated_clients{band="2.4GHz",radio="1",ssid="BTHub",uid="1"} 3
+	bt_homehub_wifi_ssid_associated_clients{band="5GHz",radio="2",ssid="BTHub",uid="2"} 0
+	bt_homehub_wifi_ssid_receive_bytes_total{band="2.4GHz",radio="1",ssid="BTHub",uid="1"} 4000
+	bt_homehub_wifi_ssid_receive_bytes_total{band="5GHz",radio="2",ssid="BTHub",uid="2"} 0
+	bt_homehub_wifi_ssid_receive_errors_total{band="2.4GHz",radio="1",ssid="BTHub",uid="1"} 2
+	bt_homehub_wifi_ssid_receive_errors_total{band="5GHz",radio="2",ssid="BTHub",uid="2"} 0
+	bt_homehub_wifi_ssid_receive_packets_total{band="2.4GHz",radio="1",ssid="BTHub",uid="1"} 40
+	bt_homehub_wifi_ssid_receive_packets_total{band="5GHz",radio="2",ssid="BTHub",uid="2"} 0
+	bt_homehub_wifi_ssid_transmit_bytes_total{band="2.4GHz",radio="1",ssid="BTHub",uid="1"} 5000
+	bt_homehub_wifi_ssid_transmit_bytes_total{band="5GHz",radio="2",ssid="BTHub",uid="2"} 0
+	bt_homehub_wifi_ssid_transmit_errors_total{band="2.4GHz",radio="1",ssid="BTHub",uid="1"} 1
+	bt_homehub_wifi_ssid_transmit_errors_total{band="5GHz",radio="2",ssid="BTHub",uid="2"} 0
+	bt_homehub_wifi_ssid_transmit_packets_total{band="2.4GHz",radio="1",ssid="BTHub",uid="1"} 50
+	bt_homehub_wifi_ssid_transmit_packets_total{band="5GHz",radio="2",ssid="BTHub",uid="2"} 0`
 
 	for scanner.Scan() {
 		line := scanner.Text()
+		if strings.HasPrefix(line, "bt_homehub_last_successful_poll_timestamp_seconds") || strings.HasPrefix(line, "bt_homehub_bandwidth_parse_duration_seconds") ||
+			strings.HasPrefix(line, "bt_homehub_scrape_collector_duration_seconds") || strings.HasPrefix(line, "bt_homehub_device_first_seen_timestamp_seconds") ||
+			strings.HasPrefix(line, "bt_homehub_device_last_seen_timestamp_seconds") {
+			continue
+		}
+
 		if strings.HasPrefix(line, "bt_homehub") {
 			if !containsLine(expectedMetrics, line) {
 				t.Fatalf("Unexpected metric encountered: %s", line)
@@ -79,8 +210,9 @@ func TestMetricsScrapeFailure(t *testing.T) {
 	bandwidthStatsResponse := createBandwidthStatisticsResponse()
 	bandwidthStatsResponse.Error = errors.New("Scrape error")
 
-	client.EXPECT().GetSummaryStatistics().Return(bandwidthStatsResponse)
-	client.EXPECT().GetBandwidthStatistics().Return(createBandwidthStatisticsResponse())
+	client.EXPECT().GetSummaryStatistics(gomock.Any()).Return(bandwidthStatsResponse)
+	client.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(createBandwidthStatisticsResponse())
+	client.EXPECT().Relogins().Return(0)
 
 	response, err := scrapeMetrics(exporter, t.Name())
 	if err != nil {
@@ -94,14 +226,26 @@ func TestMetricsScrapeFailure(t *testing.T) {
 	defer response.Body.Close()
 	scanner := bufio.NewScanner(response.Body)
 
-	expectedMetrics := `bt_homehub_build_info{firmware="ABC123"} 1
-	bt_homehub_download_rate_mbps 123.45
-	bt_homehub_up 0
-	bt_homehub_upload_rate_mbps 543.21
-	bt_homehub_uptime_seconds 9.8765421e+07`
+	expectedMetrics := `bt_homehub_bandwidth_file_size_bytes 1287
+	bt_homehub_parse_errors_total 0
+	bt_homehub_relogins_total 0
+	bt_homehub_scrape_collector_success{collector="bandwidth"} 1
+	bt_homehub_scrape_collector_success{collector="custom"} 0
+	bt_homehub_scrape_collector_success{collector="devices"} 0
+	bt_homehub_scrape_collector_success{collector="dsl"} 0
+	bt_homehub_scrape_collector_success{collector="interface"} 0
+	bt_homehub_scrape_collector_success{collector="presence"} 0
+	bt_homehub_scrape_collector_success{collector="system"} 0
+	bt_homehub_scrape_collector_success{collector="wan"} 0
+	bt_homehub_scrape_collector_success{collector="wifi"} 0
+	bt_homehub_up 0`
 
 	for scanner.Scan() {
 		line := scanner.Text()
+		if strings.HasPrefix(line, "bt_homehub_bandwidth_parse_duration_seconds") || strings.HasPrefix(line, "bt_homehub_scrape_collector_duration_seconds") {
+			continue
+		}
+
 		if strings.HasPrefix(line, "bt_homehub") {
 			if !containsLine(expectedMetrics, line) {
 				t.Fatalf("Unexpected metric encountered: %s", line)
@@ -110,6 +254,969 @@ func TestMetricsScrapeFailure(t *testing.T) {
 	}
 }
 
+func TestBandwidthFailureKeepsSummaryMetrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockClient := NewMockClient(ctrl)
+	exporter := New(mockClient)
+
+	defer ctrl.Finish()
+
+	bandwidthStatistics := createBandwidthStatisticsResponse()
+	bandwidthStatistics.Error = errors.New("bandwidth monitoring unavailable")
+
+	mockClient.EXPECT().GetSummaryStatistics(gomock.Any()).Return(createSummaryStatisticsResponse())
+	mockClient.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(bandwidthStatistics)
+	mockClient.EXPECT().Relogins().Return(0)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+
+	metricFamilies, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Error occurred gathering metrics: %s", err)
+	}
+
+	values := make(map[string]float64)
+	for _, metricFamily := range metricFamilies {
+		for _, metric := range metricFamily.GetMetric() {
+			name := metricFamily.GetName()
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "collector" {
+					name += "/" + label.GetValue()
+				}
+			}
+			values[name] = metric.GetGauge().GetValue()
+		}
+	}
+
+	if values["bt_homehub_up"] != 1 || values["bt_homehub_uptime_seconds"] != 98765421 {
+		t.Fatalf("Expected summary metrics to be reported. Got %v", values)
+	}
+
+	if values["bt_homehub_scrape_collector_success/bandwidth"] != 0 || values["bt_homehub_scrape_collector_success/system"] != 1 {
+		t.Fatalf("Expected only the bandwidth collector to fail. Got %v", values)
+	}
+
+	if _, present := values["bt_homehub_bandwidth_file_size_bytes"]; present {
+		t.Fatal("Expected bandwidth metrics to be omitted")
+	}
+}
+
+func TestDisabledCollectors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockClient := NewMockClient(ctrl)
+	exporter := New(mockClient, WithCollectors("system", "wan"))
+
+	defer ctrl.Finish()
+
+	// The bandwidth statistics are not requested when the bandwidth collector is disabled
+	mockClient.EXPECT().GetSummaryStatistics(gomock.Any()).Return(createSummaryStatisticsResponse())
+	mockClient.EXPECT().Relogins().Return(0)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+
+	metricFamilies, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Error occurred gathering metrics: %s", err)
+	}
+
+	var collectors []string
+	for _, metricFamily := range metricFamilies {
+		if strings.HasPrefix(metricFamily.GetName(), "bt_homehub_dsl") || strings.HasPrefix(metricFamily.GetName(), "bt_homehub_wifi") {
+			t.Fatalf("Unexpected metric from a disabled collector: %s", metricFamily.GetName())
+		}
+
+		if metricFamily.GetName() == "bt_homehub_scrape_collector_success" {
+			for _, metric := range metricFamily.GetMetric() {
+				collectors = append(collectors, metric.GetLabel()[0].GetValue())
+			}
+		}
+	}
+
+	if strings.Join(collectors, ",") != "system,wan" {
+		t.Fatalf("Expected only the system and wan collectors to run. Got %v", collectors)
+	}
+}
+
+func TestClientMetricsAreReported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockClient := NewMockClient(ctrl)
+	exporter := New(mockClient, WithClientMetrics(client.NewMetrics()), WithPollInterval(time.Hour))
+
+	defer ctrl.Finish()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+
+	// Client metrics are reported even before the first poll of the Home Hub
+	if value := gatherValue(t, registry, "bt_homehub_client_session_age_seconds"); value != 0 {
+		t.Fatalf("Expected a session age of 0 before logging in. Got %f", value)
+	}
+}
+
+func TestMetricsScrapeWithContext(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	exporter := New(client)
+
+	defer ctrl.Finish()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client.EXPECT().GetSummaryStatistics(ctx).Return(createSummaryStatisticsResponse())
+	client.EXPECT().GetBandwidthStatistics(ctx).Return(createBandwidthStatisticsResponse())
+	client.EXPECT().Relogins().Return(0)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter.WithContext(ctx))
+
+	if _, err := registry.Gather(); err != nil {
+		t.Fatalf("Error occurred gathering metrics: %s", err)
+	}
+}
+
+func TestMetricsPolling(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	exporter := New(client, WithPollInterval(time.Hour))
+
+	defer ctrl.Finish()
+
+	polled := make(chan struct{})
+	client.EXPECT().GetSummaryStatistics(gomock.Any()).Return(createSummaryStatisticsResponse()).Times(1)
+	client.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(createBandwidthStatisticsResponse()).Times(1)
+	client.EXPECT().Relogins().DoAndReturn(func() int {
+		close(polled)
+		return 0
+	}).Times(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+
+	if value := gatherValue(t, registry, "bt_homehub_up"); value != 0 {
+		t.Fatalf("Expected bt_homehub_up 0 before the first poll. Got %f", value)
+	}
+
+	exporter.Start(ctx)
+	<-polled
+
+	// Wait for the poll to complete and the snapshot to be stored
+	for i := 0; i < 100 && gatherValue(t, registry, "bt_homehub_up") != 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < 3; i++ {
+		if value := gatherValue(t, registry, "bt_homehub_up"); value != 1 {
+			t.Fatalf("Expected bt_homehub_up 1 from the polled snapshot. Got %f", value)
+		}
+
+		if value := gatherValue(t, registry, "bt_homehub_last_successful_poll_timestamp_seconds"); value <= 0 {
+			t.Fatalf("Expected last successful poll timestamp to be set. Got %f", value)
+		}
+	}
+}
+
+func TestWaitForPollingToStop(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockClient := NewMockClient(ctrl)
+	exporter := New(mockClient, WithPollInterval(time.Hour))
+
+	defer ctrl.Finish()
+
+	polling := make(chan struct{})
+	release := make(chan struct{})
+	mockClient.EXPECT().GetSummaryStatistics(gomock.Any()).DoAndReturn(func(ctx context.Context, xpaths ...string) *client.Response {
+		close(polling)
+		<-release
+		return createSummaryStatisticsResponse()
+	}).Times(1)
+	mockClient.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(createBandwidthStatisticsResponse()).Times(1)
+	mockClient.EXPECT().Relogins().Return(0).Times(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exporter.Start(ctx)
+	<-polling
+	cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		exporter.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("Expected Wait to block while a poll is in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Wait to return once polling stopped")
+	}
+}
+
+func TestWaitForScrapeToFinish(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockClient := NewMockClient(ctrl)
+	exporter := New(mockClient)
+
+	defer ctrl.Finish()
+
+	scraping := make(chan struct{})
+	release := make(chan struct{})
+	mockClient.EXPECT().GetSummaryStatistics(gomock.Any()).DoAndReturn(func(ctx context.Context, xpaths ...string) *client.Response {
+		close(scraping)
+		<-release
+		return createSummaryStatisticsResponse()
+	}).Times(1)
+	mockClient.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(createBandwidthStatisticsResponse()).Times(1)
+	mockClient.EXPECT().Relogins().Return(0).Times(1)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exporter.Start(ctx)
+
+	go registry.Gather() //nolint:errcheck
+	<-scraping
+	cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		exporter.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("Expected Wait to block while a scrape is in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Wait to return once the scrape finished")
+	}
+
+	// The Home Hub is no longer queried once the exporter has been stopped
+	if value := gatherValue(t, registry, "bt_homehub_up"); value != 0 {
+		t.Fatalf("Expected bt_homehub_up to be 0 after the exporter was stopped. Got %f", value)
+	}
+}
+
+type publishedMetrics struct {
+	families []*dto.MetricFamily
+}
+
+func (p *publishedMetrics) Publish(families []*dto.MetricFamily) {
+	p.families = families
+}
+
+func TestPollPublishesMetrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	publisher := &publishedMetrics{}
+	exporter := New(client, WithPollInterval(time.Hour), WithPublisher(publisher))
+
+	defer ctrl.Finish()
+
+	client.EXPECT().GetSummaryStatistics(gomock.Any()).Return(createSummaryStatisticsResponse()).Times(1)
+	client.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(createBandwidthStatisticsResponse()).Times(1)
+	client.EXPECT().Relogins().Return(0).Times(1)
+
+	exporter.poll(context.Background())
+
+	values := make(map[string]float64)
+	for _, family := range publisher.families {
+		if len(family.GetMetric()) > 0 {
+			values[family.GetName()] = family.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+
+	if values["bt_homehub_up"] != 1 {
+		t.Fatalf("Expected bt_homehub_up 1 to be published. Got %v", values)
+	}
+
+	if values["bt_homehub_last_successful_poll_timestamp_seconds"] <= 0 {
+		t.Fatalf("Expected the last successful poll timestamp to be published. Got %v", values)
+	}
+
+	if _, ok := values["bt_homehub_device_connected"]; !ok {
+		t.Fatalf("Expected device metrics to be published. Got %v", values)
+	}
+}
+
+func TestMetricsPollingStaleSnapshot(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	exporter := New(client, WithPollInterval(time.Hour), WithMaxSnapshotAge(time.Millisecond))
+
+	defer ctrl.Finish()
+
+	client.EXPECT().GetSummaryStatistics(gomock.Any()).Return(createSummaryStatisticsResponse())
+	client.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(createBandwidthStatisticsResponse())
+	client.EXPECT().Relogins().Return(0)
+
+	exporter.poll(context.Background())
+	time.Sleep(5 * time.Millisecond)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+
+	if value := gatherValue(t, registry, "bt_homehub_up"); value != 0 {
+		t.Fatalf("Expected bt_homehub_up 0 for a stale snapshot. Got %f", value)
+	}
+
+	if value := gatherValue(t, registry, "bt_homehub_uptime_seconds"); value != -1 {
+		t.Fatalf("Expected stale metrics to be dropped. Got bt_homehub_uptime_seconds %f", value)
+	}
+
+	if value := gatherValue(t, registry, "bt_homehub_last_successful_poll_timestamp_seconds"); value <= 0 {
+		t.Fatalf("Expected last successful poll timestamp to be set. Got %f", value)
+	}
+}
+
+func TestConcurrentScrapesShareHubRequest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockClient := NewMockClient(ctrl)
+	exporter := New(mockClient)
+
+	defer ctrl.Finish()
+
+	release := make(chan struct{})
+	mockClient.EXPECT().GetSummaryStatistics(gomock.Any()).DoAndReturn(func(ctx context.Context) *client.Response {
+		<-release
+		return createSummaryStatisticsResponse()
+	}).Times(1)
+	mockClient.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(createBandwidthStatisticsResponse()).Times(1)
+	mockClient.EXPECT().Relogins().Return(0).Times(1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(exporter)
+			if value := gatherValue(t, registry, "bt_homehub_up"); value != 1 {
+				t.Errorf("Expected bt_homehub_up 1. Got %f", value)
+			}
+		}()
+	}
+
+	// Give the scrapes time to queue up behind the in-flight request
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+}
+
+func TestIncrementalBandwidthStatistics(t *testing.T) {
+	stateFile, err := ioutil.TempFile("", "homehub-bandwidth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateFile.Close()
+	os.Remove(stateFile.Name())
+	defer os.Remove(stateFile.Name())
+
+	ctrl := gomock.NewController(t)
+	mockClient := NewMockClient(ctrl)
+	exporter := New(mockClient, WithBandwidthStateFile(stateFile.Name()))
+
+	defer ctrl.Finish()
+
+	// The second download only contains the days since the previous download, with updated figures for 2016-12-30
+	secondDownload := &client.Response{
+		Body: `FAKE+SERIAL+NUMBER,AA:BB:CC:DD:EE:F1,2016-12-30,700,70
+		FAKE+SERIAL+NUMBER,AA:BB:CC:DD:EE:F2,2016-12-30,300,30
+		FAKE+SERIAL+NUMBER,AA:BB:CC:DD:EE:F1,2016-12-31,50,5`,
+	}
+
+	gomock.InOrder(
+		mockClient.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(createBandwidthStatisticsResponse()),
+		mockClient.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(secondDownload),
+	)
+	mockClient.EXPECT().GetSummaryStatistics(gomock.Any()).Return(createSummaryStatisticsResponse()).Times(2)
+	mockClient.EXPECT().Relogins().Return(0).Times(2)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+
+	if value := gatherDeviceValue(t, registry, "bt_homehub_device_downloaded_megabytes", "AA:BB:CC:DD:EE:F1"); value != 600 {
+		t.Fatalf("Expected 600 megabytes downloaded. Got %f", value)
+	}
+
+	if value := gatherDeviceValue(t, registry, "bt_homehub_device_downloaded_megabytes", "AA:BB:CC:DD:EE:F1"); value != 750 {
+		t.Fatalf("Expected 750 megabytes downloaded. Got %f", value)
+	}
+
+	store, err := newBandwidthStore(stateFile.Name(), 0)
+	if err != nil {
+		t.Fatalf("Error loading bandwidth state: %s", err)
+	}
+
+	totals := store.deviceTotals()
+	if totals["AA:BB:CC:DD:EE:F1"].downloaded != 750 || totals["AA:BB:CC:DD:EE:F2"].downloaded != 300 {
+		t.Fatalf("Unexpected persisted bandwidth totals: %v %v", totals["AA:BB:CC:DD:EE:F1"], totals["AA:BB:CC:DD:EE:F2"])
+	}
+
+	// After a restart, downloads resume from the last persisted day
+	latest, err := LatestBandwidthDay(stateFile.Name())
+	if err != nil {
+		t.Fatalf("Error loading bandwidth state: %s", err)
+	}
+	if expected := time.Date(2016, time.December, 31, 0, 0, 0, 0, time.UTC); !latest.Equal(expected) {
+		t.Fatalf("Expected latest bandwidth day %s. Got %s", expected, latest)
+	}
+}
+
+func TestBandwidthRetention(t *testing.T) {
+	stateFile, err := ioutil.TempFile("", "bandwidth-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateFile.Close()
+	defer os.Remove(stateFile.Name())
+
+	ctrl := gomock.NewController(t)
+	mockClient := NewMockClient(ctrl)
+	exporter := New(mockClient, WithBandwidthStateFile(stateFile.Name()), WithBandwidthRetention(2))
+
+	defer ctrl.Finish()
+
+	// Days are dropped once they are more than 2 days before the most recent day
+	firstDownload := &client.Response{
+		Body: `FAKE+SERIAL+NUMBER,AA:BB:CC:DD:EE:F1,2016-12-25,1000,100
+		FAKE+SERIAL+NUMBER,AA:BB:CC:DD:EE:F1,2016-12-28,100,10
+		FAKE+SERIAL+NUMBER,AA:BB:CC:DD:EE:F1,2016-12-29,200,20`,
+	}
+	secondDownload := &client.Response{
+		Body: `FAKE+SERIAL+NUMBER,AA:BB:CC:DD:EE:F1,2016-12-30,300,30
+		FAKE+SERIAL+NUMBER,AA:BB:CC:DD:EE:F1,2016-12-31,400,40`,
+	}
+
+	gomock.InOrder(
+		mockClient.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(firstDownload),
+		mockClient.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(secondDownload),
+	)
+	mockClient.EXPECT().GetSummaryStatistics(gomock.Any()).Return(createSummaryStatisticsResponse()).Times(2)
+	mockClient.EXPECT().Relogins().Return(0).Times(2)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+
+	if value := gatherDeviceValue(t, registry, "bt_homehub_device_downloaded_megabytes", "AA:BB:CC:DD:EE:F1"); value != 300 {
+		t.Fatalf("Expected 300 megabytes downloaded on the retained days. Got %f", value)
+	}
+
+	if value := gatherDeviceValue(t, registry, "bt_homehub_device_downloaded_megabytes", "AA:BB:CC:DD:EE:F1"); value != 900 {
+		t.Fatalf("Expected 900 megabytes downloaded on the retained days. Got %f", value)
+	}
+
+	store, err := newBandwidthStore(stateFile.Name(), 0)
+	if err != nil {
+		t.Fatalf("Error loading bandwidth state: %s", err)
+	}
+
+	var days []string
+	for _, record := range store.records("", time.Time{}, time.Time{}) {
+		days = append(days, record.Date.Format(client.BandwidthRecordDateFormat))
+	}
+	if expected := "2016-12-29,2016-12-30,2016-12-31"; strings.Join(days, ",") != expected {
+		t.Fatalf("Expected the state file to hold %s. Got %s", expected, strings.Join(days, ","))
+	}
+
+	// A state file written with a longer retention is pruned when it is loaded
+	store, err = newBandwidthStore(stateFile.Name(), 1)
+	if err != nil {
+		t.Fatalf("Error loading bandwidth state: %s", err)
+	}
+	if total := store.deviceTotals()["AA:BB:CC:DD:EE:F1"].downloaded; total != 700 {
+		t.Fatalf("Expected 700 megabytes downloaded on the retained days. Got %f", total)
+	}
+}
+
+func TestBandwidthHistory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockClient := NewMockClient(ctrl)
+	exporter := New(mockClient)
+
+	defer ctrl.Finish()
+
+	now := time.Now()
+	today := now.Format(client.BandwidthRecordDateFormat)
+	yesterday := now.AddDate(0, 0, -1).Format(client.BandwidthRecordDateFormat)
+	twoDaysAgo := now.AddDate(0, 0, -2).Format(client.BandwidthRecordDateFormat)
+	bandwidthStatistics := &client.Response{
+		Body: `FAKE+SERIAL+NUMBER,AA:BB:CC:DD:EE:F1,` + twoDaysAgo + `,100,10
+		FAKE+SERIAL+NUMBER,AA:BB:CC:DD:EE:F2,` + twoDaysAgo + `,200,20
+		FAKE+SERIAL+NUMBER,AA:BB:CC:DD:EE:F1,` + yesterday + `,300,30
+		FAKE+SERIAL+NUMBER,AA:BB:CC:DD:EE:F1,` + today + `,40,4`,
+	}
+
+	mockClient.EXPECT().GetSummaryStatistics(gomock.Any()).Return(createSummaryStatisticsResponse())
+	mockClient.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(bandwidthStatistics)
+	mockClient.EXPECT().Relogins().Return(0)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+
+	if value := gatherDeviceValue(t, registry, "bt_homehub_device_downloaded_today_megabytes", "AA:BB:CC:DD:EE:F1"); value != 40 {
+		t.Fatalf("Expected 40 megabytes downloaded today. Got %f", value)
+	}
+
+	recorder := httptest.NewRecorder()
+	exporter.BandwidthHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/api/bandwidth?mac=aa:bb:cc:dd:ee:f1&from="+twoDaysAgo+"&to="+yesterday, nil))
+
+	var records []client.BandwidthRecord
+	if err := json.NewDecoder(recorder.Body).Decode(&records); err != nil {
+		t.Fatalf("Error decoding bandwidth history: %s", err)
+	}
+
+	if len(records) != 2 || records[0].Date.Format(client.BandwidthRecordDateFormat) != twoDaysAgo || records[1].Downloaded != 300 {
+		t.Fatalf("Unexpected bandwidth history: %v", records)
+	}
+
+	recorder = httptest.NewRecorder()
+	exporter.BandwidthHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/api/bandwidth?from="+twoDaysAgo+"&to="+twoDaysAgo+"&format=csv", nil))
+
+	expectedCSV := `serial_number,mac_address,date,downloaded_megabytes,uploaded_megabytes
+FAKE+SERIAL+NUMBER,AA:BB:CC:DD:EE:F1,` + twoDaysAgo + `,100,10
+FAKE+SERIAL+NUMBER,AA:BB:CC:DD:EE:F2,` + twoDaysAgo + `,200,20
+`
+	if recorder.Body.String() != expectedCSV {
+		t.Fatalf("Unexpected bandwidth history CSV:\n%s", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	exporter.BandwidthHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/api/bandwidth?from=yesterday", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status code 400 for an invalid date. Got %d", recorder.Code)
+	}
+}
+
+func TestWANReconnections(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockClient := NewMockClient(ctrl)
+	exporter := New(mockClient)
+
+	defer ctrl.Finish()
+
+	reconnected := createSummaryStatisticsResponse()
+	setResponseValue(reconnected, client.WANConnectionUptime, 60.0)
+
+	disconnected := createSummaryStatisticsResponse()
+	setResponseValue(disconnected, client.WANConnectionStatus, "Disconnected")
+
+	gomock.InOrder(
+		mockClient.EXPECT().GetSummaryStatistics(gomock.Any()).Return(createSummaryStatisticsResponse()),
+		mockClient.EXPECT().GetSummaryStatistics(gomock.Any()).Return(reconnected),
+		mockClient.EXPECT().GetSummaryStatistics(gomock.Any()).Return(disconnected),
+		mockClient.EXPECT().GetSummaryStatistics(gomock.Any()).Return(createSummaryStatisticsResponse()),
+	)
+	mockClient.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(createBandwidthStatisticsResponse()).Times(4)
+	mockClient.EXPECT().Relogins().Return(0).Times(4)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+
+	for i, expected := range []float64{0, 1, 1, 2} {
+		if value := gatherValue(t, registry, "bt_homehub_wan_reconnections_total"); value != expected {
+			t.Fatalf("Expected %f WAN reconnections after scrape %d. Got %f", expected, i+1, value)
+		}
+	}
+}
+
+func TestDevicePresence(t *testing.T) {
+	stateFile, err := ioutil.TempFile("", "homehub-presence")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateFile.Close()
+	os.Remove(stateFile.Name())
+	defer os.Remove(stateFile.Name())
+
+	ctrl := gomock.NewController(t)
+	mockClient := NewMockClient(ctrl)
+	exporter := New(mockClient, WithPresenceStateFile(stateFile.Name()), WithCollectors("presence"))
+
+	defer ctrl.Finish()
+
+	disconnected := createSummaryStatisticsResponse()
+	devices := createDevices()
+	devices[0].(map[string]interface{})["Active"] = false
+	setResponseValue(disconnected, client.ConnectedDevices, devices[:8])
+
+	gomock.InOrder(
+		mockClient.EXPECT().GetSummaryStatistics(gomock.Any()).Return(createSummaryStatisticsResponse()),
+		mockClient.EXPECT().GetSummaryStatistics(gomock.Any()).Return(disconnected),
+		mockClient.EXPECT().GetSummaryStatistics(gomock.Any()).Return(createSummaryStatisticsResponse()),
+	)
+	mockClient.EXPECT().Relogins().Return(0).Times(3)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+
+	if value := gatherDeviceValue(t, registry, "bt_homehub_device_connected", "AA:BB:CC:DD:EE:F1"); value != 1 {
+		t.Fatalf("Expected device to be connected. Got %f", value)
+	}
+
+	if value := gatherDeviceValue(t, registry, "bt_homehub_device_connected", "AA:BB:CC:DD:EE:F1"); value != 0 {
+		t.Fatalf("Expected device to be disconnected. Got %f", value)
+	}
+
+	if value := gatherDeviceValue(t, registry, "bt_homehub_device_connected", "AA:BB:CC:DD:EE:F1"); value != 1 {
+		t.Fatalf("Expected device to be connected again. Got %f", value)
+	}
+
+	// Inactive devices that have never been seen connected are tracked without first and last seen times
+	presence := exporter.presence.snapshot()
+	if inactive := presence["AA:BB:CC:DD:EE:F8"]; inactive.Connected || inactive.Connections != 0 || !inactive.LastSeen.IsZero() || inactive.HostName != "Host Name 8" {
+		t.Fatalf("Expected inactive device to be disconnected. Got %+v", inactive)
+	}
+
+	if !presence["AA:BB:CC:DD:EE:F2"].Connected || presence["AA:BB:CC:DD:EE:F2"].Connections != 1 {
+		t.Fatalf("Expected device to have stayed connected. Got %+v", presence["AA:BB:CC:DD:EE:F2"])
+	}
+
+	if presence["AA:BB:CC:DD:EE:F1"].Connections != 2 || !presence["AA:BB:CC:DD:EE:F1"].FirstSeen.Before(presence["AA:BB:CC:DD:EE:F1"].LastSeen) {
+		t.Fatalf("Expected device to have reconnected. Got %+v", presence["AA:BB:CC:DD:EE:F1"])
+	}
+
+	store, err := newPresenceStore(stateFile.Name())
+	if err != nil {
+		t.Fatalf("Error loading presence state: %s", err)
+	}
+
+	persisted := store.snapshot()
+	if persisted["AA:BB:CC:DD:EE:F1"].Connections != 2 || !persisted["AA:BB:CC:DD:EE:F1"].FirstSeen.Equal(presence["AA:BB:CC:DD:EE:F1"].FirstSeen) {
+		t.Fatalf("Unexpected persisted presence: %+v", persisted["AA:BB:CC:DD:EE:F1"])
+	}
+}
+
+func TestDevicePresenceDisconnected(t *testing.T) {
+	store, err := newPresenceStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connected := &device{macAddress: "AA:BB:CC:DD:EE:F1", hostName: "tablet", ipAddress: "192.168.1.10", active: true}
+	start := time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	store.update(start, map[string]*device{connected.macAddress: connected})
+	store.update(start.Add(time.Minute), map[string]*device{})
+	store.update(start.Add(2*time.Minute), map[string]*device{connected.macAddress: connected})
+	store.update(start.Add(3*time.Minute), map[string]*device{})
+
+	presence := store.snapshot()[connected.macAddress]
+	if presence.Connected || presence.Connections != 2 {
+		t.Fatalf("Expected a disconnected device with 2 connections. Got %+v", presence)
+	}
+
+	if !presence.FirstSeen.Equal(start) || !presence.LastSeen.Equal(start.Add(2*time.Minute)) || presence.HostName != "tablet" {
+		t.Fatalf("Unexpected device presence: %+v", presence)
+	}
+}
+
+func TestDeviceEvents(t *testing.T) {
+	store, err := newPresenceStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tablet := &device{macAddress: "AA:BB:CC:DD:EE:F1", hostName: "tablet", ipAddress: "192.168.1.10", active: true}
+	phone := &device{macAddress: "AA:BB:CC:DD:EE:F2", hostName: "phone", ipAddress: "192.168.1.11", active: true}
+	moved := &device{macAddress: tablet.macAddress, hostName: "tablet", ipAddress: "192.168.1.12", active: true}
+	start := time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	// Devices present on the first update are not reported as having joined
+	if events := store.update(start, map[string]*device{tablet.macAddress: tablet}); len(events) != 0 {
+		t.Fatalf("Expected no events for the first update. Got %+v", events)
+	}
+
+	events := store.update(start.Add(time.Minute), map[string]*device{tablet.macAddress: moved, phone.macAddress: phone})
+	sort.Slice(events, func(i, j int) bool { return events[i].Type < events[j].Type })
+	if len(events) != 2 || events[0].Type != notify.EventDeviceChanged || events[1].Type != notify.EventDeviceJoined {
+		t.Fatalf("Expected changed and joined events. Got %+v", events)
+	}
+
+	if events[0].PreviousIPAddress != "192.168.1.10" || events[0].IPAddress != "192.168.1.12" || events[1].MACAddress != phone.macAddress {
+		t.Fatalf("Unexpected events: %+v", events)
+	}
+
+	events = store.update(start.Add(2*time.Minute), map[string]*device{phone.macAddress: phone})
+	if len(events) != 1 || events[0].Type != notify.EventDeviceLeft || events[0].MACAddress != tablet.macAddress || events[0].HostName != "tablet" {
+		t.Fatalf("Expected a left event. Got %+v", events)
+	}
+
+	// Known devices reconnecting do not cause events
+	if events := store.update(start.Add(3*time.Minute), map[string]*device{tablet.macAddress: moved, phone.macAddress: phone}); len(events) != 0 {
+		t.Fatalf("Expected no events. Got %+v", events)
+	}
+}
+
+func TestInactiveDeviceEvents(t *testing.T) {
+	store, err := newPresenceStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tablet := &device{macAddress: "AA:BB:CC:DD:EE:F1", hostName: "tablet", ipAddress: "192.168.1.10", active: true}
+	stale := &device{macAddress: "AA:BB:CC:DD:EE:F2", hostName: "phone", ipAddress: "192.168.1.11", active: false}
+	start := time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	store.update(start, map[string]*device{tablet.macAddress: tablet})
+
+	// Inactive hosts the Home Hub still lists are not reported as having joined
+	if events := store.update(start.Add(time.Minute), map[string]*device{tablet.macAddress: tablet, stale.macAddress: stale}); len(events) != 0 {
+		t.Fatalf("Expected no events for an inactive device. Got %+v", events)
+	}
+
+	connected := &device{macAddress: stale.macAddress, hostName: "phone", ipAddress: "192.168.1.12", active: true}
+	events := store.update(start.Add(2*time.Minute), map[string]*device{tablet.macAddress: tablet, connected.macAddress: connected})
+	if len(events) != 1 || events[0].Type != notify.EventDeviceJoined || events[0].MACAddress != connected.macAddress || events[0].IPAddress != "192.168.1.12" {
+		t.Fatalf("Expected a joined event once the device connects. Got %+v", events)
+	}
+}
+
+func TestMalformedDevicesAreSkipped(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockClient := NewMockClient(ctrl)
+	exporter := New(mockClient)
+
+	defer ctrl.Finish()
+
+	devices := createDevices()
+	devices[0].(map[string]interface{})["HostName"] = nil
+	devices[1].(map[string]interface{})["Active"] = "yes"
+	devices = append(devices, nil, "not a host", map[string]interface{}{"Active": true})
+
+	summaryStatistics := createSummaryStatisticsResponse()
+	setResponseValue(summaryStatistics, client.ConnectedDevices, devices)
+
+	// Each gather triggers a scrape of the hub
+	mockClient.EXPECT().GetSummaryStatistics(gomock.Any()).Return(summaryStatistics).Times(4)
+	mockClient.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(createBandwidthStatisticsResponse()).Times(4)
+	mockClient.EXPECT().Relogins().Return(0).Times(4)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+
+	if value := gatherValue(t, registry, "bt_homehub_parse_errors_total"); value != 4 {
+		t.Fatalf("Expected 4 parse errors. Got %f", value)
+	}
+
+	if value := gatherValue(t, registry, "bt_homehub_up"); value != 1 {
+		t.Fatalf("Expected bt_homehub_up to be 1. Got %f", value)
+	}
+
+	if value := gatherDeviceValue(t, registry, "bt_homehub_device_downloaded_megabytes", "AA:BB:CC:DD:EE:F1"); value != 600 {
+		t.Fatalf("Expected device with a null host name to be exported. Got %f", value)
+	}
+
+	if value := gatherDeviceValue(t, registry, "bt_homehub_device_downloaded_megabytes", "AA:BB:CC:DD:EE:F2"); value != -1 {
+		t.Fatalf("Expected malformed device to be skipped. Got %f", value)
+	}
+}
+
+func TestCustomMetrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockClient := NewMockClient(ctrl)
+
+	defer ctrl.Finish()
+
+	customConfig, err := config.Parse([]byte(`
+metrics:
+  - name: bt_homehub_model_info
+    xpath: Device/DeviceInfo/ModelName
+    type: info
+  - name: bt_homehub_dsl_current_rate_bps
+    xpath: Device/DSL/Channels/Channel[@uid='1']/DownstreamCurrRate
+    transform: kbps_to_bps
+  - name: bt_homehub_ethernet_port_up
+    xpath: Device/Ethernet/Interfaces
+    value_field: Status
+    transform: enum
+    mapping:
+      Up: 1
+      Down: 0
+    labels:
+      port: Alias
+  - name: bt_homehub_ethernet_port_received_bytes_total
+    xpath: Device/Ethernet/Interfaces
+    type: counter
+    value_field: Stats/BytesReceived
+    labels:
+      port: Alias
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error parsing config: %s", err)
+	}
+
+	exporter := New(mockClient, WithCustomMetrics(customConfig.Metrics))
+
+	// The download rate is already part of the summary statistics, so the client requests it only once
+	summaryStatistics := createSummaryStatisticsResponse()
+	setResponseValue(summaryStatistics, client.DownloadRate, 80000.0)
+	summaryStatistics.ResponseBody.Reply.ResponseActions = append(summaryStatistics.ResponseBody.Reply.ResponseActions,
+		newResponseAction("Device/DeviceInfo/ModelName", "Smart Hub 2"),
+		newResponseAction("Device/Ethernet/Interfaces", []interface{}{
+			map[string]interface{}{"Alias": "LAN1", "Status": "Up", "Stats": map[string]interface{}{"BytesReceived": "1024"}},
+			map[string]interface{}{"Alias": "LAN2", "Status": "Down", "Stats": map[string]interface{}{"BytesReceived": 0.0}},
+			map[string]interface{}{"Alias": "LAN3", "Status": "Unknown", "Stats": map[string]interface{}{"BytesReceived": "invalid"}},
+		}))
+
+	mockClient.EXPECT().GetSummaryStatistics(gomock.Any(), "Device/DeviceInfo/ModelName", "Device/DSL/Channels/Channel[@uid='1']/DownstreamCurrRate", "Device/Ethernet/Interfaces").Return(summaryStatistics)
+	mockClient.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(createBandwidthStatisticsResponse())
+	mockClient.EXPECT().Relogins().Return(0)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+
+	recorder := httptest.NewRecorder()
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+
+	for _, expected := range []string{
+		`bt_homehub_model_info{value="Smart Hub 2"} 1`,
+		`bt_homehub_dsl_current_rate_bps 8e+07`,
+		`bt_homehub_download_rate_mbps 80000`,
+		`bt_homehub_ethernet_port_up{port="LAN1"} 1`,
+		`bt_homehub_ethernet_port_up{port="LAN2"} 0`,
+		`bt_homehub_ethernet_port_received_bytes_total{port="LAN1"} 1024`,
+		`bt_homehub_ethernet_port_received_bytes_total{port="LAN2"} 0`,
+		`bt_homehub_parse_errors_total 2`,
+	} {
+		if !containsLine(body, expected) {
+			t.Fatalf("Expected metric %s. Got:\n%s", expected, body)
+		}
+	}
+
+	if strings.Contains(body, `port="LAN3"`) {
+		t.Fatalf("Expected entries with values that cannot be converted to be skipped. Got:\n%s", body)
+	}
+}
+
+func TestCheckCustomMetrics(t *testing.T) {
+	valid := []config.Metric{{Name: "bt_homehub_model_info", XPath: "Device/DeviceInfo/ModelName", Type: config.MetricTypeInfo}}
+	if err := CheckCustomMetrics(valid, map[string]string{"hub": "home"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	builtIn := []config.Metric{{Name: "bt_homehub_uptime_seconds", Help: "Uptime of the router", XPath: "Device/DeviceInfo/UpTime", Type: config.MetricTypeGauge}}
+	if err := CheckCustomMetrics(builtIn, nil); err == nil {
+		t.Fatal("Expected an error for a metric named after a built in metric")
+	}
+}
+
+func TestWiFiRadiosOnTheSameBand(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockClient := NewMockClient(ctrl)
+	exporter := New(mockClient)
+
+	defer ctrl.Finish()
+
+	// A tri-band hub has two 5GHz radios, and its guest network shares a name with the main SSID
+	summaryStatistics := createSummaryStatisticsResponse()
+	setResponseValue(summaryStatistics, client.WiFiRadios, append(createWiFiRadios(), map[string]interface{}{
+		"uid": 3.0, "Alias": "RADIO5G2", "Enable": true, "OperatingFrequencyBand": "5GHz", "Channel": 100.0,
+	}))
+	setResponseValue(summaryStatistics, client.WiFiSSIDs, append(createWiFiSSIDs(),
+		map[string]interface{}{"uid": 4.0, "SSID": "BTHub", "LowerLayers": "Device.WiFi.Radio.3", "Stats": map[string]interface{}{"BytesSent": 300.0}},
+		map[string]interface{}{"uid": 5.0, "SSID": "BTHub", "LowerLayers": "Device.WiFi.Radio.1", "Stats": map[string]interface{}{"BytesSent": 400.0}},
+	))
+
+	mockClient.EXPECT().GetSummaryStatistics(gomock.Any()).Return(summaryStatistics)
+	mockClient.EXPECT().GetBandwidthStatistics(gomock.Any()).Return(createBandwidthStatisticsResponse())
+	mockClient.EXPECT().Relogins().Return(0)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+
+	expected := `
+	# HELP bt_homehub_wifi_radio_channel Current channel of the Wi-Fi radio
+	# TYPE bt_homehub_wifi_radio_channel gauge
+	bt_homehub_wifi_radio_channel{band="2.4GHz",radio="1"} 6
+	bt_homehub_wifi_radio_channel{band="5GHz",radio="2"} 36
+	bt_homehub_wifi_radio_channel{band="5GHz",radio="3"} 100
+	# HELP bt_homehub_wifi_ssid_transmit_bytes_total Number of bytes transmitted on the SSID
+	# TYPE bt_homehub_wifi_ssid_transmit_bytes_total counter
+	bt_homehub_wifi_ssid_transmit_bytes_total{band="2.4GHz",radio="1",ssid="BTHub",uid="1"} 5000
+	bt_homehub_wifi_ssid_transmit_bytes_total{band="2.4GHz",radio="1",ssid="BTHub",uid="5"} 400
+	bt_homehub_wifi_ssid_transmit_bytes_total{band="5GHz",radio="2",ssid="BTHub",uid="2"} 0
+	bt_homehub_wifi_ssid_transmit_bytes_total{band="5GHz",radio="3",ssid="BTHub",uid="4"} 300
+	`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "bt_homehub_wifi_radio_channel", "bt_homehub_wifi_ssid_transmit_bytes_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setResponseValue(response *client.Response, xpath string, value interface{}) {
+	for i, action := range response.ResponseBody.Reply.ResponseActions {
+		if action.ResponseCallbacks[0].XPath == xpath {
+			response.ResponseBody.Reply.ResponseActions[i] = newResponseAction(xpath, value)
+		}
+	}
+}
+
+// gatherDeviceValue returns the value of the named device metric for the given MAC address, or -1 if it is not present
+func gatherDeviceValue(t *testing.T, gatherer prometheus.Gatherer, name string, macAddress string) float64 {
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatalf("Error occurred gathering metrics: %s", err)
+	}
+
+	for _, metricFamily := range metricFamilies {
+		if metricFamily.GetName() != name {
+			continue
+		}
+
+		for _, metric := range metricFamily.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "mac_address" && label.GetValue() == macAddress {
+					if metric.GetGauge() != nil {
+						return metric.GetGauge().GetValue()
+					}
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// gatherValue returns the value of the named unlabelled metric, or -1 if it is not present
+func gatherValue(t *testing.T, gatherer prometheus.Gatherer, name string) float64 {
+	metricFamilies, err := gatherer.Gather()
+	if err != nil {
+		t.Fatalf("Error occurred gathering metrics: %s", err)
+	}
+
+	for _, metricFamily := range metricFamilies {
+		if metricFamily.GetName() != name {
+			continue
+		}
+
+		metric := metricFamily.GetMetric()[0]
+		if metric.GetGauge() != nil {
+			return metric.GetGauge().GetValue()
+		}
+		return metric.GetCounter().GetValue()
+	}
+	return -1
+}
+
 func containsLine(s string, match string) bool {
 	for _, line := range strings.Split(s, "\n") {
 		if strings.TrimSpace(line) == match {
@@ -125,10 +1232,13 @@ func scrapeMetrics(exporter *Exporter, name string) (*http.Response, error) {
 
 	http.Handle("/"+name, promhttp.Handler())
 
-	go func() {
-		//nolint:golint,errcheck
-		http.ListenAndServe(":19092", nil)
-	}()
+	// Listen before serving so that the request below cannot race the server start up
+	if listener, err := net.Listen("tcp", ":19092"); err == nil {
+		go func() {
+			//nolint:golint,errcheck
+			http.Serve(listener, nil)
+		}()
+	}
 
 	return http.Get("http://localhost:19092/" + name)
 }
@@ -181,9 +1291,105 @@ func createResponseActions() []client.ResponseAction {
 	responseActions = append(responseActions, newResponseAction(client.ConnectedDevices, createDevices()))
 	responseActions = append(responseActions, newResponseAction(client.DownloadedBytes, "654321"))
 	responseActions = append(responseActions, newResponseAction(client.UploadedBytes, "123456"))
+	responseActions = append(responseActions, newResponseAction(client.DSLStatus, "Up"))
+	responseActions = append(responseActions, newResponseAction(client.DSLDownstreamNoiseMargin, 62.0))
+	responseActions = append(responseActions, newResponseAction(client.DSLUpstreamNoiseMargin, 55.0))
+	responseActions = append(responseActions, newResponseAction(client.DSLDownstreamAttenuation, 185.0))
+	responseActions = append(responseActions, newResponseAction(client.DSLUpstreamAttenuation, 120.0))
+	responseActions = append(responseActions, newResponseAction(client.DSLDownstreamMaxRate, 79999.0))
+	responseActions = append(responseActions, newResponseAction(client.DSLUpstreamMaxRate, 20000.0))
+	responseActions = append(responseActions, newResponseAction(client.DSLErroredSeconds, 12.0))
+	responseActions = append(responseActions, newResponseAction(client.DSLSeverelyErroredSeconds, 3.0))
+	responseActions = append(responseActions, newResponseAction(client.DSLResyncs, 2.0))
+	responseActions = append(responseActions, newResponseAction(client.DSLDownstreamCRCErrors, "1234"))
+	responseActions = append(responseActions, newResponseAction(client.DSLUpstreamCRCErrors, "56"))
+	responseActions = append(responseActions, newResponseAction(client.DSLDownstreamFECErrors, "98765"))
+	responseActions = append(responseActions, newResponseAction(client.DSLUpstreamFECErrors, "43"))
+	responseActions = append(responseActions, newResponseAction(client.DSLDownstreamHECErrors, 7.0))
+	responseActions = append(responseActions, newResponseAction(client.DSLUpstreamHECErrors, 0.0))
+	responseActions = append(responseActions, newResponseAction(client.WANConnectionStatus, "Connected"))
+	responseActions = append(responseActions, newResponseAction(client.WANLastConnectionError, "ERROR_NONE"))
+	responseActions = append(responseActions, newResponseAction(client.WANConnectionUptime, 3600.0))
+	responseActions = append(responseActions, newResponseAction(client.WANDNSServers, "1.1.1.1, 8.8.8.8"))
+	responseActions = append(responseActions, newResponseAction(client.WANIPv4Address, "81.2.3.4"))
+	responseActions = append(responseActions, newResponseAction(client.WANIPv6Address, "2a00::1"))
+	responseActions = append(responseActions, newResponseAction(client.IPInterfaces, createIPInterfaces()))
+	responseActions = append(responseActions, newResponseAction(client.WiFiRadios, createWiFiRadios()))
+	responseActions = append(responseActions, newResponseAction(client.WiFiSSIDs, createWiFiSSIDs()))
+	responseActions = append(responseActions, newResponseAction(client.WiFiAccessPoints, createWiFiAccessPoints()))
 	return responseActions
 }
 
+func createIPInterfaces() []interface{} {
+	return []interface{}{
+		map[string]interface{}{
+			"uid":         1.0,
+			"Alias":       "IP_BR_LAN",
+			"Status":      "Up",
+			"LowerLayers": "Device.Ethernet.Link.1",
+			"Stats": map[string]interface{}{
+				"BytesSent": 1000.0, "BytesReceived": 2000.0, "PacketsSent": 10.0, "PacketsReceived": 20.0, "ErrorsSent": 0.0, "ErrorsReceived": 1.0,
+			},
+		},
+		map[string]interface{}{
+			"uid":         3.0,
+			"Alias":       "IP_DATA",
+			"Status":      "Up",
+			"LowerLayers": "Device.PPP.Interface.1",
+			"Stats": map[string]interface{}{
+				"BytesSent": "123456", "BytesReceived": "654321", "PacketsSent": "300", "PacketsReceived": "400", "ErrorsSent": "2", "ErrorsReceived": "3",
+			},
+		},
+		map[string]interface{}{
+			"uid":    4.0,
+			"Status": "Down",
+		},
+	}
+}
+
+func createWiFiRadios() []interface{} {
+	return []interface{}{
+		map[string]interface{}{
+			"uid": 1.0, "Alias": "RADIO2G4", "Enable": true, "OperatingFrequencyBand": "2.4GHz", "Channel": 6.0,
+			"CurrentOperatingChannelBandwidth": "20MHz", "TransmitPower": 100.0, "Stats": map[string]interface{}{"Noise": -92.0},
+		},
+		map[string]interface{}{
+			"uid": 2.0, "Alias": "RADIO5G", "Enable": false, "OperatingFrequencyBand": "5GHz", "Channel": "36",
+			"CurrentOperatingChannelBandwidth": "Auto", "TransmitPower": 75.0, "Stats": map[string]interface{}{"Noise": "-95"},
+		},
+	}
+}
+
+func createWiFiSSIDs() []interface{} {
+	return []interface{}{
+		map[string]interface{}{
+			"uid": 1.0, "SSID": "BTHub", "LowerLayers": "Device.WiFi.Radio.1",
+			"Stats": map[string]interface{}{"BytesSent": "5000", "BytesReceived": "4000", "PacketsSent": 50.0, "PacketsReceived": 40.0, "ErrorsSent": 1.0, "ErrorsReceived": 2.0},
+		},
+		map[string]interface{}{
+			"uid": 2.0, "SSID": "BTHub", "LowerLayers": "Device.WiFi.Radio.2",
+			"Stats": map[string]interface{}{"BytesSent": 0.0, "BytesReceived": 0.0},
+		},
+		map[string]interface{}{
+			"uid": 3.0, "SSID": "", "LowerLayers": "Device.WiFi.Radio.1",
+		},
+	}
+}
+
+func createWiFiAccessPoints() []interface{} {
+	return []interface{}{
+		map[string]interface{}{
+			"uid": 1.0, "SSIDReference": "Device.WiFi.SSID.1", "AssociatedDeviceNumberOfEntries": 3.0,
+			"AssociatedDevices": []interface{}{
+				map[string]interface{}{"MACAddress": "aa:bb:cc:dd:ee:f5", "Active": true, "SignalStrength": -71.0, "LastDataDownlinkRate": 72200.0, "LastDataUplinkRate": "65000"},
+				map[string]interface{}{"MACAddress": "AA:BB:CC:DD:EE:F1", "Active": true, "SignalStrength": -40.0},
+				map[string]interface{}{"MACAddress": "AA:BB:CC:DD:EE:F6", "Active": false, "SignalStrength": -80.0},
+			},
+		},
+		map[string]interface{}{"uid": 2.0, "SSIDReference": "Device.WiFi.SSID.2", "AssociatedDeviceNumberOfEntries": 0.0},
+	}
+}
+
 func createDevices() []interface{} {
 	var deviceDetails []interface{}
 
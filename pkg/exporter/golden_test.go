@@ -0,0 +1,104 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/cassette"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+var update = flag.Bool("update", false, "Update the golden files in testdata/golden")
+
+// volatileMetrics are not compared with the golden files, since their values depend on when the test is run
+var volatileMetrics = []string{"duration", "_today_", "timestamp"}
+
+// TestGoldenMetrics replays the Home Hub traffic in testdata/cassettes and compares the metrics that are exported
+// with testdata/golden. Cassettes are created with the record command
+func TestGoldenMetrics(t *testing.T) {
+	cassettes, err := filepath.Glob(filepath.Join("testdata", "cassettes", "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cassettes) == 0 {
+		t.Fatal("No cassettes found in testdata/cassettes")
+	}
+
+	for _, file := range cassettes {
+		name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		t.Run(name, func(t *testing.T) {
+			recorded, err := cassette.Load(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			homehub := client.New("http://homehub", "admin", "password", client.WithTransportWrapper(func(http.RoundTripper) http.RoundTripper {
+				return recorded.Transport()
+			}))
+
+			if response := homehub.Login(context.Background()); response.Error != nil {
+				t.Fatalf("Login failed: %s", response.Error)
+			}
+
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(New(homehub))
+
+			metrics := renderMetrics(t, registry)
+			goldenFile := filepath.Join("testdata", "golden", name+".prom")
+
+			if *update {
+				if err := ioutil.WriteFile(goldenFile, []byte(metrics), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			golden, err := ioutil.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatalf("Unable to read golden file. Run the tests with -update to create it: %s", err)
+			}
+
+			if metrics != string(golden) {
+				t.Fatalf("Metrics do not match %s. Run the tests with -update if the change is expected\n%s", goldenFile, metrics)
+			}
+		})
+	}
+}
+
+// renderMetrics returns the gathered metrics in the Prometheus text format, without volatile metrics
+func renderMetrics(t *testing.T, gatherer prometheus.Gatherer) string {
+	families, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	for _, family := range families {
+		if isVolatileMetric(family.GetName()) {
+			continue
+		}
+
+		if _, err := expfmt.MetricFamilyToText(&buffer, family); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buffer.String()
+}
+
+func isVolatileMetric(name string) bool {
+	for _, volatile := range volatileMetrics {
+		if strings.Contains(name, volatile) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const interfaceUp = "Up"
+
+var interfaceLabels = []string{"interface"}
+
+func (e *Exporter) collectInterfaces(ctx context.Context, s *summary, channel chan<- prometheus.Metric) error {
+	value, ok := s.values[client.IPInterfaces]
+	if !ok {
+		return nil
+	}
+
+	ipInterfaces, err := client.DecodeIPInterfaces(value)
+	if err != nil {
+		return fmt.Errorf("unable to decode IP interfaces: %s", err)
+	}
+
+	for _, ipInterface := range ipInterfaces {
+		label := ipInterface.Label()
+		stats := ipInterface.Stats
+
+		up := 0.0
+		if ipInterface.Status == interfaceUp {
+			up = 1
+		}
+
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["interfaceUp"], prometheus.GaugeValue, up, label)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["interfaceReceiveBytes"], prometheus.CounterValue, float64(stats.BytesReceived), label)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["interfaceTransmitBytes"], prometheus.CounterValue, float64(stats.BytesSent), label)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["interfaceReceivePackets"], prometheus.CounterValue, float64(stats.PacketsReceived), label)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["interfaceTransmitPackets"], prometheus.CounterValue, float64(stats.PacketsSent), label)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["interfaceReceiveErrors"], prometheus.CounterValue, float64(stats.ErrorsReceived), label)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["interfaceTransmitErrors"], prometheus.CounterValue, float64(stats.ErrorsSent), label)
+	}
+	return nil
+}
@@ -3,37 +3,25 @@ package exporter
 import (
 	"strconv"
 	"strings"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
 )
 
 type device struct {
-	macAddress          string
-	ipAddress           string
-	hostName            string
-	deviceType          string
-	active              bool
-	bandwidthStatistics *deviceBandwidthStatistics
+	macAddress string
+	ipAddress  string
+	hostName   string
+	deviceType string
+	active     bool
 }
 
-func newDevice(deviceDetails map[string]interface{}) *device {
-	active := deviceDetails["Active"].(bool)
-	deviceType := deviceDetails["InterfaceType"].(string)
-	ipaddress := deviceDetails["IPAddress"].(string)
-	mac := deviceDetails["PhysAddress"].(string)
-	hostName := ""
-	switch {
-	case deviceDetails["UserHostName"] != "":
-		hostName = deviceDetails["UserHostName"].(string)
-	case deviceDetails["HostName"] != "":
-		hostName = deviceDetails["HostName"].(string)
-	default:
-		hostName = deviceDetails["Alias"].(string)
-	}
+func newDevice(deviceDetail client.DeviceDetail) *device {
 	return &device{
-		active:     active,
-		deviceType: deviceType,
-		macAddress: strings.ToUpper(mac),
-		ipAddress:  ipaddress,
-		hostName:   hostName,
+		active:     deviceDetail.Active,
+		deviceType: deviceDetail.InterfaceType,
+		macAddress: deviceDetail.MACAddress(),
+		ipAddress:  deviceDetail.IPAddress,
+		hostName:   deviceDetail.Name(),
 	}
 }
 
@@ -43,25 +31,21 @@ type deviceBandwidthStatistics struct {
 	downloaded float64
 }
 
-func newDeviceBandwidthStatistics(statistics string) *deviceBandwidthStatistics {
-	statistic := strings.Split(statistics, ",")
-
-	if len(statistic) >= 5 {
-		downloaded, err := strconv.ParseFloat(statistic[3], 64)
-		if err != nil {
-			downloaded = 0
-		}
-
-		uploaded, err := strconv.ParseFloat(statistic[4], 64)
-		if err != nil {
-			uploaded = 0
-		}
-
-		return &deviceBandwidthStatistics{
-			macAddress: strings.ToUpper(statistic[1]),
-			uploaded:   uploaded,
-			downloaded: downloaded,
+// toFloat converts a value returned by the Home Hub to a float. Large counters are returned by the Home Hub as strings
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
 		}
+		return 0, true
+	case string:
+		floatValue, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return floatValue, err == nil
 	}
-	return nil
+	return 0, false
 }
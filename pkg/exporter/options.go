@@ -0,0 +1,106 @@
+package exporter
+
+import (
+	"time"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/config"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/notify"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Option configures optional Exporter behaviour
+type Option func(*Exporter)
+
+// WithPollInterval enables background polling of the Home Hub. Scrapes are served from the most recently
+// polled snapshot rather than triggering requests to the Home Hub
+func WithPollInterval(interval time.Duration) Option {
+	return func(e *Exporter) {
+		e.pollInterval = interval
+	}
+}
+
+// WithMaxSnapshotAge sets how old a polled snapshot may become before it is considered stale and no longer served
+func WithMaxSnapshotAge(age time.Duration) Option {
+	return func(e *Exporter) {
+		e.maxSnapshotAge = age
+	}
+}
+
+// WithBandwidthStateFile persists the accumulated device bandwidth statistics to file, so that they survive restarts
+func WithBandwidthStateFile(file string) Option {
+	return func(e *Exporter) {
+		e.bandwidthStateFile = file
+	}
+}
+
+// WithBandwidthRetention sets the number of days before the most recent day that device bandwidth statistics are
+// kept for. Defaults to 365 days
+func WithBandwidthRetention(days int) Option {
+	return func(e *Exporter) {
+		e.bandwidthRetention = days
+	}
+}
+
+// WithPresenceStateFile persists when each device was first and last seen to file, so that device history
+// survives restarts
+func WithPresenceStateFile(file string) Option {
+	return func(e *Exporter) {
+		e.presenceStateFile = file
+	}
+}
+
+// WithNotifier sends events to notifier when devices join or leave the network, or change their host name or
+// IP address. Events are only detected when the presence collector is enabled
+func WithNotifier(notifier *notify.Notifier) Option {
+	return func(e *Exporter) {
+		e.notifier = notifier
+	}
+}
+
+// Publisher receives the metrics gathered by each scrape of the Home Hub
+type Publisher interface {
+	Publish(families []*dto.MetricFamily)
+}
+
+// WithPublisher passes the metrics of every scrape of the Home Hub to publisher. When polling is enabled,
+// this happens once per poll interval
+func WithPublisher(publisher Publisher) Option {
+	return func(e *Exporter) {
+		e.publisher = publisher
+	}
+}
+
+// WithCollectors enables only the named collectors. By default all collectors are enabled. Names that do not
+// match one of CollectorNames are ignored
+func WithCollectors(names ...string) Option {
+	return func(e *Exporter) {
+		enabled := make(map[string]bool, len(names))
+		for _, name := range names {
+			enabled[name] = true
+		}
+
+		e.collectors = nil
+		for _, c := range collectors {
+			if enabled[c.name] {
+				e.collectors = append(e.collectors, c)
+			}
+		}
+	}
+}
+
+// WithClientMetrics reports the metrics recorded by the client about its requests to the Home Hub. They are
+// always current, even when scrapes are served from a polled snapshot
+func WithClientMetrics(metrics *client.Metrics) Option {
+	return func(e *Exporter) {
+		e.clientMetrics = metrics
+	}
+}
+
+// WithCustomMetrics exports user defined metrics read from Home Hub XPaths. The metrics must have been validated
+func WithCustomMetrics(metrics []config.Metric) Option {
+	return func(e *Exporter) {
+		e.customMetrics, e.customXPaths = newCustomMetrics(metrics)
+	}
+}
@@ -0,0 +1,213 @@
+package exporter
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The maximum age of a polled snapshot, expressed as a number of poll intervals, when no explicit maximum age is configured
+const defaultMaxSnapshotAgeIntervals = 3
+
+type snapshot struct {
+	metrics   []prometheus.Metric
+	timestamp time.Time
+}
+
+type inflightScrape struct {
+	done    chan struct{}
+	metrics []prometheus.Metric
+}
+
+// Start begins polling the Home Hub in the background if a poll interval has been configured. When ctx is cancelled,
+// polling stops and scrapes no longer query the Home Hub
+func (e *Exporter) Start(ctx context.Context) {
+	e.mutex.Lock()
+	e.stopped = ctx.Done()
+	e.mutex.Unlock()
+
+	if e.pollInterval <= 0 {
+		return
+	}
+
+	e.running.Add(1)
+	go func() {
+		defer e.running.Done()
+
+		ticker := time.NewTicker(e.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			e.poll(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Wait blocks until the background polling started by Start, and any scrape of the Home Hub in progress when its
+// context was cancelled, have finished. Once it returns, the exporter no longer writes to the state files
+func (e *Exporter) Wait() {
+	// Scrapes are added to running while the mutex is held, so acquiring it ensures that every scrape started
+	// before the context was cancelled is waited for
+	e.mutex.Lock()
+	e.mutex.Unlock()
+	e.running.Wait()
+}
+
+func (e *Exporter) poll(ctx context.Context) {
+	pollCtx, cancel := context.WithTimeout(ctx, e.pollInterval)
+	defer cancel()
+
+	metrics := e.scrape(pollCtx)
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.snapshot = &snapshot{
+		metrics:   metrics,
+		timestamp: time.Now(),
+	}
+}
+
+// collectCached sends metrics from the latest polled snapshot when polling is enabled. Otherwise the Home Hub
+// is scraped, with concurrent callers sharing the result of a single in-flight scrape
+func (e *Exporter) collectCached(ctx context.Context, channel chan<- prometheus.Metric) {
+	var metrics []prometheus.Metric
+	if e.pollInterval > 0 {
+		metrics = e.snapshotMetrics()
+	} else {
+		metrics = e.sharedScrape(ctx)
+	}
+
+	for _, metric := range metrics {
+		channel <- metric
+	}
+
+	e.mutex.Lock()
+	lastSuccessfulPoll := e.lastSuccessfulPoll
+	e.mutex.Unlock()
+
+	if !lastSuccessfulPoll.IsZero() {
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["lastSuccessfulPoll"], prometheus.GaugeValue, float64(lastSuccessfulPoll.UnixNano())/1e9)
+	}
+
+	if e.clientMetrics != nil {
+		e.clientMetrics.Collect(channel)
+	}
+}
+
+func (e *Exporter) snapshotMetrics() []prometheus.Metric {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.snapshot == nil || time.Since(e.snapshot.timestamp) > e.maxSnapshotAge {
+		return []prometheus.Metric{prometheus.MustNewConstMetric(e.metricDescriptions["up"], prometheus.GaugeValue, 0)}
+	}
+	return e.snapshot.metrics
+}
+
+func (e *Exporter) sharedScrape(ctx context.Context) []prometheus.Metric {
+	e.mutex.Lock()
+	scrape := e.inflight
+	if scrape == nil {
+		// An exporter that has been stopped is being replaced, and must not write to the state files that its
+		// replacement loads
+		select {
+		case <-e.stopped:
+			e.mutex.Unlock()
+			return []prometheus.Metric{prometheus.MustNewConstMetric(e.metricDescriptions["up"], prometheus.GaugeValue, 0)}
+		default:
+		}
+
+		e.running.Add(1)
+		defer e.running.Done()
+
+		scrape = &inflightScrape{done: make(chan struct{})}
+		e.inflight = scrape
+		e.mutex.Unlock()
+
+		scrape.metrics = e.scrape(ctx)
+
+		e.mutex.Lock()
+		e.inflight = nil
+		e.mutex.Unlock()
+		close(scrape.done)
+		return scrape.metrics
+	}
+	e.mutex.Unlock()
+
+	select {
+	case <-scrape.done:
+		return scrape.metrics
+	case <-ctx.Done():
+		return []prometheus.Metric{prometheus.MustNewConstMetric(e.metricDescriptions["up"], prometheus.GaugeValue, 0)}
+	}
+}
+
+// scrape collects all metrics from the Home Hub into a slice so they can be cached or shared
+func (e *Exporter) scrape(ctx context.Context) []prometheus.Metric {
+	var metrics []prometheus.Metric
+
+	channel := make(chan prometheus.Metric)
+	success := make(chan bool, 1)
+	go func() {
+		success <- e.collect(ctx, channel)
+		close(channel)
+	}()
+
+	for metric := range channel {
+		metrics = append(metrics, metric)
+	}
+
+	if <-success {
+		e.mutex.Lock()
+		e.lastSuccessfulPoll = time.Now()
+		e.mutex.Unlock()
+	}
+
+	if e.publisher != nil {
+		e.publish(metrics)
+	}
+
+	return metrics
+}
+
+// publish gathers the scraped metrics, along with the time of the last successful poll, into metric families
+// and passes them to the publisher
+func (e *Exporter) publish(metrics []prometheus.Metric) {
+	e.mutex.Lock()
+	lastSuccessfulPoll := e.lastSuccessfulPoll
+	e.mutex.Unlock()
+
+	if !lastSuccessfulPoll.IsZero() {
+		metrics = append(metrics[:len(metrics):len(metrics)], prometheus.MustNewConstMetric(e.metricDescriptions["lastSuccessfulPoll"], prometheus.GaugeValue, float64(lastSuccessfulPoll.UnixNano())/1e9))
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metricSlice(metrics))
+
+	// Gather returns the families that could be gathered, even if some metrics were invalid
+	families, err := registry.Gather()
+	if err != nil {
+		slog.Error("Error gathering metrics to publish", "err", err)
+	}
+	e.publisher.Publish(families)
+}
+
+// metricSlice is an unchecked collector of a fixed set of metrics
+type metricSlice []prometheus.Metric
+
+func (m metricSlice) Describe(channel chan<- *prometheus.Desc) {
+}
+
+func (m metricSlice) Collect(channel chan<- prometheus.Metric) {
+	for _, metric := range m {
+		channel <- metric
+	}
+}
@@ -0,0 +1,202 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/notify"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// presenceStore tracks when each device was first and last seen connected to the Home Hub, including devices that
+// have since disconnected. The history can optionally be persisted to a file to survive restarts
+type presenceStore struct {
+	mutex   sync.Mutex
+	file    string
+	devices map[string]*devicePresence
+	updated bool
+}
+
+type presenceState struct {
+	Devices map[string]*devicePresence `json:"devices"`
+}
+
+type devicePresence struct {
+	HostName    string    `json:"hostName"`
+	IPAddress   string    `json:"ipAddress"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+	Connected   bool      `json:"connected"`
+	Connections float64   `json:"connections"`
+}
+
+func newPresenceStore(file string) (*presenceStore, error) {
+	store := &presenceStore{
+		file:    file,
+		devices: make(map[string]*devicePresence),
+	}
+
+	if file == "" {
+		return store, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return store, err
+	}
+
+	var state presenceState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return store, err
+	}
+
+	for macAddress, presence := range state.Devices {
+		if presence != nil {
+			store.devices[macAddress] = presence
+		}
+	}
+	return store, nil
+}
+
+// update records which devices the Home Hub reports as connected at time now and returns the resulting events.
+// A device counts as a new connection when it was not connected on the previous update. Devices that are inactive
+// or no longer reported by the Home Hub are marked as disconnected. Inactive devices that have not been seen
+// connected yet are tracked without first and last seen times, and are only reported as joined once they first
+// connect. A device that connects with a different host name or IP address from the ones it was last seen with is
+// reported as changed. The first update without any stored history only records the devices that are present,
+// without returning events for them
+func (s *presenceStore) update(now time.Time, hosts map[string]*device) []notify.Event {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	baseline := !s.updated && len(s.devices) == 0
+
+	var events []notify.Event
+	newEvent := func(eventType string, macAddress string, presence *devicePresence) notify.Event {
+		return notify.Event{
+			Type:       eventType,
+			Time:       now,
+			MACAddress: macAddress,
+			HostName:   presence.HostName,
+			IPAddress:  presence.IPAddress,
+		}
+	}
+
+	for macAddress, presence := range s.devices {
+		if host := hosts[macAddress]; (host == nil || !host.active) && presence.Connected {
+			presence.Connected = false
+			events = append(events, newEvent(notify.EventDeviceLeft, macAddress, presence))
+		}
+	}
+
+	for macAddress, host := range hosts {
+		presence := s.devices[macAddress]
+		if presence == nil {
+			presence = &devicePresence{HostName: host.hostName, IPAddress: host.ipAddress}
+			s.devices[macAddress] = presence
+		}
+
+		if !host.active {
+			continue
+		}
+
+		if presence.FirstSeen.IsZero() {
+			presence.FirstSeen = now
+			presence.HostName, presence.IPAddress = host.hostName, host.ipAddress
+			events = append(events, newEvent(notify.EventDeviceJoined, macAddress, presence))
+		} else if presence.HostName != "" && presence.IPAddress != "" && (host.hostName != presence.HostName || host.ipAddress != presence.IPAddress) {
+			event := newEvent(notify.EventDeviceChanged, macAddress, presence)
+			event.PreviousHostName, event.PreviousIPAddress = event.HostName, event.IPAddress
+			event.HostName, event.IPAddress = host.hostName, host.ipAddress
+			events = append(events, event)
+		}
+
+		if !presence.Connected {
+			presence.Connected = true
+			presence.Connections++
+		}
+
+		presence.HostName = host.hostName
+		presence.IPAddress = host.ipAddress
+		presence.LastSeen = now
+	}
+
+	s.updated = true
+	if baseline {
+		return nil
+	}
+	return events
+}
+
+// snapshot returns a copy of the presence of each device keyed by MAC address
+func (s *presenceStore) snapshot() map[string]devicePresence {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	devices := make(map[string]devicePresence, len(s.devices))
+	for macAddress, presence := range s.devices {
+		devices[macAddress] = *presence
+	}
+	return devices
+}
+
+// save writes the device presence history to the state file, if one is configured
+func (s *presenceStore) save() error {
+	if s.file == "" {
+		return nil
+	}
+
+	s.mutex.Lock()
+	data, err := json.Marshal(presenceState{Devices: s.devices})
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeStateFile(s.file, data)
+}
+
+// collectPresence reports when each known device was first and last seen, and whether it is connected. Devices
+// that have disconnected are labelled with the host name and IP address they had when they were last seen
+func (e *Exporter) collectPresence(ctx context.Context, s *summary, channel chan<- prometheus.Metric) error {
+	if _, err := e.connectedDevices(s); err != nil {
+		return err
+	}
+
+	// Without a device list, every device would be counted as reconnecting on the next update
+	if _, ok := s.values[client.ConnectedDevices]; ok {
+		events := e.presence.update(time.Now(), s.hosts)
+		if e.notifier != nil && len(events) > 0 {
+			e.notifier.Notify(events...)
+		}
+
+		if err := e.presence.save(); err != nil {
+			slog.ErrorContext(ctx, "Error saving device presence", "file", e.presenceStateFile, "err", err)
+		}
+	}
+
+	for macAddress, presence := range e.presence.snapshot() {
+		connected := 0.0
+		if presence.Connected {
+			connected = 1
+		}
+
+		labelValues := []string{presence.HostName, presence.IPAddress, macAddress}
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["deviceConnected"], prometheus.GaugeValue, connected, labelValues...)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["deviceConnections"], prometheus.CounterValue, presence.Connections, labelValues...)
+		if !presence.FirstSeen.IsZero() {
+			channel <- prometheus.MustNewConstMetric(e.metricDescriptions["deviceFirstSeen"], prometheus.GaugeValue, float64(presence.FirstSeen.UnixNano())/1e9, labelValues...)
+			channel <- prometheus.MustNewConstMetric(e.metricDescriptions["deviceLastSeen"], prometheus.GaugeValue, float64(presence.LastSeen.UnixNano())/1e9, labelValues...)
+		}
+	}
+	return nil
+}
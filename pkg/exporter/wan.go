@@ -0,0 +1,96 @@
+package exporter
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const wanConnected = "Connected"
+
+// wanStatus holds the WAN connection values returned by the Home Hub in a summary statistics response
+type wanStatus struct {
+	connectionStatus    string
+	lastConnectionError string
+	uptime              float64
+	hasUptime           bool
+	dnsServers          string
+	ipv4Address         string
+	ipv6Address         string
+}
+
+// update records the value of a WAN connection XPath. It returns false if the XPath is not WAN related
+func (w *wanStatus) update(xpath string, value interface{}) bool {
+	stringValue, _ := value.(string)
+
+	switch xpath {
+	case client.WANConnectionStatus:
+		w.connectionStatus = stringValue
+	case client.WANLastConnectionError:
+		w.lastConnectionError = stringValue
+	case client.WANConnectionUptime:
+		w.uptime, w.hasUptime = toFloat(value)
+	case client.WANDNSServers:
+		w.dnsServers = strings.ReplaceAll(stringValue, " ", "")
+	case client.WANIPv4Address:
+		w.ipv4Address = stringValue
+	case client.WANIPv6Address:
+		w.ipv6Address = stringValue
+	default:
+		return false
+	}
+	return true
+}
+
+func (e *Exporter) collectWAN(ctx context.Context, s *summary, channel chan<- prometheus.Metric) error {
+	// Large byte counters are returned by the Home Hub as strings
+	if downloaded, ok := toFloat(s.values[client.DownloadedBytes]); ok {
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["downloadBytes"], prometheus.GaugeValue, downloaded)
+	}
+	if uploaded, ok := toFloat(s.values[client.UploadedBytes]); ok {
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["uploadBytes"], prometheus.GaugeValue, uploaded)
+	}
+
+	wan := &wanStatus{}
+	for xpath, value := range s.values {
+		wan.update(xpath, value)
+	}
+
+	if wan.connectionStatus == "" {
+		return nil
+	}
+
+	connected := 0.0
+	if wan.connectionStatus == wanConnected {
+		connected = 1
+	}
+
+	channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wanUp"], prometheus.GaugeValue, connected)
+	channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wanConnectionStatus"], prometheus.GaugeValue, 1, wan.connectionStatus)
+	channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wanInfo"], prometheus.GaugeValue, 1, wan.ipv4Address, wan.ipv6Address, wan.dnsServers)
+
+	if wan.lastConnectionError != "" {
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wanLastConnectionError"], prometheus.GaugeValue, 1, wan.lastConnectionError)
+	}
+
+	if wan.hasUptime && connected == 1 {
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wanUptime"], prometheus.GaugeValue, wan.uptime)
+	}
+
+	e.mutex.Lock()
+	// The connection was re-established if it has come back up, or if its uptime dropped between scrapes
+	if e.wanSeen && connected == 1 && (!e.wanConnected || wan.hasUptime && wan.uptime < e.wanUptime) {
+		e.wanReconnections++
+	}
+	e.wanSeen = true
+	e.wanConnected = connected == 1
+	e.wanUptime = wan.uptime
+	reconnections := e.wanReconnections
+	e.mutex.Unlock()
+
+	channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wanReconnections"], prometheus.CounterValue, reconnections)
+	return nil
+}
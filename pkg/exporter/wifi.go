@@ -0,0 +1,163 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Hubs can have more than one radio on a band, and more than one SSID with the same name, so the uid of the radio
+// and of the SSID distinguish their series
+var (
+	radioLabels = []string{"band", "radio"}
+	ssidLabels  = []string{"band", "radio", "ssid", "uid"}
+)
+
+// wifiStatus holds the Wi-Fi tables returned by the Home Hub in a summary statistics response
+type wifiStatus struct {
+	radios       []client.WiFiRadio
+	ssids        []client.WiFiSSID
+	accessPoints []client.WiFiAccessPoint
+	// err is the first error encountered decoding the Wi-Fi tables
+	err error
+}
+
+// update records the value of a Wi-Fi XPath. It returns false if the XPath is not Wi-Fi related
+func (w *wifiStatus) update(xpath string, value interface{}) bool {
+	var err error
+
+	switch xpath {
+	case client.WiFiRadios:
+		w.radios, err = client.DecodeWiFiRadios(value)
+	case client.WiFiSSIDs:
+		w.ssids, err = client.DecodeWiFiSSIDs(value)
+	case client.WiFiAccessPoints:
+		w.accessPoints, err = client.DecodeWiFiAccessPoints(value)
+	default:
+		return false
+	}
+
+	if err != nil {
+		slog.Warn("Error decoding Home Hub value", "xpath", xpath, "err", err)
+		if w.err == nil {
+			w.err = fmt.Errorf("unable to decode %s: %s", xpath, err)
+		}
+	}
+	return true
+}
+
+// wifiClient holds the link quality of a device associated with one of the Home Hub access points
+type wifiClient struct {
+	band           string
+	ssid           string
+	signalStrength float64
+	txRate         float64
+	rxRate         float64
+}
+
+// clients returns the devices associated with each access point keyed by upper case MAC address
+func (w *wifiStatus) clients() map[string]*wifiClient {
+	bands := w.bands()
+	ssids := make(map[int]client.WiFiSSID, len(w.ssids))
+	for _, ssid := range w.ssids {
+		ssids[ssid.UID] = ssid
+	}
+
+	clients := make(map[string]*wifiClient)
+	for _, accessPoint := range w.accessPoints {
+		ssid := ssids[accessPoint.SSIDUID()]
+		for _, associatedDevice := range accessPoint.AssociatedDevices {
+			if !associatedDevice.Active || associatedDevice.MACAddress == "" {
+				continue
+			}
+
+			// Data rates are reported in kbps from the point of view of the access point
+			clients[strings.ToUpper(associatedDevice.MACAddress)] = &wifiClient{
+				band:           bands[ssid.RadioUID()],
+				ssid:           ssid.SSID,
+				signalStrength: float64(associatedDevice.SignalStrength),
+				txRate:         float64(associatedDevice.LastDataDownlinkRate) / 1000,
+				rxRate:         float64(associatedDevice.LastDataUplinkRate) / 1000,
+			}
+		}
+	}
+	return clients
+}
+
+// bands returns the frequency band of each radio keyed by the radio uid
+func (w *wifiStatus) bands() map[int]string {
+	bands := make(map[int]string, len(w.radios))
+	for _, radio := range w.radios {
+		bands[radio.UID] = radioBand(radio)
+	}
+	return bands
+}
+
+func (e *Exporter) collectWiFi(ctx context.Context, s *summary, channel chan<- prometheus.Metric) error {
+	wifi := s.wifiStatus()
+
+	for _, radio := range wifi.radios {
+		labelValues := []string{radioBand(radio), strconv.Itoa(radio.UID)}
+
+		enabled := 0.0
+		if radio.Enable {
+			enabled = 1
+		}
+
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wifiRadioEnabled"], prometheus.GaugeValue, enabled, labelValues...)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wifiRadioChannel"], prometheus.GaugeValue, float64(radio.Channel), labelValues...)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wifiRadioTransmitPower"], prometheus.GaugeValue, float64(radio.TransmitPower), labelValues...)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wifiRadioNoise"], prometheus.GaugeValue, float64(radio.Stats.Noise), labelValues...)
+
+		if bandwidth, err := strconv.ParseFloat(strings.TrimSuffix(radio.CurrentOperatingChannelBandwidth, "MHz"), 64); err == nil {
+			channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wifiRadioBandwidth"], prometheus.GaugeValue, bandwidth, labelValues...)
+		}
+	}
+
+	associatedClients := make(map[int]float64)
+	for _, accessPoint := range wifi.accessPoints {
+		associatedClients[accessPoint.SSIDUID()] += float64(accessPoint.AssociatedDeviceNumberOfEntries)
+	}
+
+	bands := wifi.bands()
+	for _, ssid := range wifi.ssids {
+		if ssid.SSID == "" {
+			continue
+		}
+
+		labelValues := []string{bands[ssid.RadioUID()], strconv.Itoa(ssid.RadioUID()), ssid.SSID, strconv.Itoa(ssid.UID)}
+		stats := ssid.Stats
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wifiSSIDAssociatedClients"], prometheus.GaugeValue, associatedClients[ssid.UID], labelValues...)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wifiSSIDReceiveBytes"], prometheus.CounterValue, float64(stats.BytesReceived), labelValues...)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wifiSSIDTransmitBytes"], prometheus.CounterValue, float64(stats.BytesSent), labelValues...)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wifiSSIDReceivePackets"], prometheus.CounterValue, float64(stats.PacketsReceived), labelValues...)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wifiSSIDTransmitPackets"], prometheus.CounterValue, float64(stats.PacketsSent), labelValues...)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wifiSSIDReceiveErrors"], prometheus.CounterValue, float64(stats.ErrorsReceived), labelValues...)
+		channel <- prometheus.MustNewConstMetric(e.metricDescriptions["wifiSSIDTransmitErrors"], prometheus.CounterValue, float64(stats.ErrorsSent), labelValues...)
+	}
+	return wifi.err
+}
+
+func (e *Exporter) collectWiFiDevice(device *device, wifi *wifiClient, channel chan<- prometheus.Metric) {
+	labelValues := []string{device.hostName, device.ipAddress, device.macAddress, wifi.band, wifi.ssid}
+	channel <- prometheus.MustNewConstMetric(e.metricDescriptions["deviceSignal"], prometheus.GaugeValue, wifi.signalStrength, labelValues...)
+	channel <- prometheus.MustNewConstMetric(e.metricDescriptions["deviceTxRate"], prometheus.GaugeValue, wifi.txRate, labelValues...)
+	channel <- prometheus.MustNewConstMetric(e.metricDescriptions["deviceRxRate"], prometheus.GaugeValue, wifi.rxRate, labelValues...)
+}
+
+// radioBand returns the frequency band of a radio, such as 2.4GHz or 5GHz, falling back to its alias
+func radioBand(radio client.WiFiRadio) string {
+	if radio.OperatingFrequencyBand != "" {
+		return radio.OperatingFrequencyBand
+	}
+	if radio.Alias != "" {
+		return radio.Alias
+	}
+	return strconv.Itoa(radio.UID)
+}
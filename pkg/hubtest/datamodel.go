@@ -0,0 +1,113 @@
+package hubtest
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// defaultDataModel resembles a Home Hub connected over VDSL with a few devices attached. Large counters are strings,
+// as they are on a real Home Hub
+const defaultDataModel = `{
+  "Device": {
+    "DeviceInfo": {
+      "Manufacturer": "Sagemcom",
+      "ModelName": "Home Hub 6",
+      "SerialNumber": "+123456+NQ12345678",
+      "ExternalFirmwareVersion": "SG4B1000B540",
+      "UpTime": 86400
+    },
+    "IP": {
+      "Interfaces": [
+        {"uid": 1, "Alias": "IP_BR_LAN", "Name": "br0", "Status": "Up", "Enable": true, "LowerLayers": "Device.Ethernet.Link.1",
+         "IPv4Addresses": [{"uid": 1, "IPAddress": "192.168.1.254"}],
+         "Stats": {"BytesSent": "1024000", "BytesReceived": "2048000", "PacketsSent": "1000", "PacketsReceived": "2000", "ErrorsSent": 0, "ErrorsReceived": 0}},
+        {"uid": 2, "Alias": "IP_LOOPBACK", "Name": "lo", "Status": "Up", "Enable": true, "Loopback": true,
+         "Stats": {"BytesSent": "0", "BytesReceived": "0", "PacketsSent": "0", "PacketsReceived": "0", "ErrorsSent": 0, "ErrorsReceived": 0}},
+        {"uid": 3, "Alias": "IP_DATA", "Name": "ppp0", "Status": "Up", "Enable": true, "LowerLayers": "Device.PPP.Interface.1",
+         "IPv4Addresses": [{"uid": 1, "IPAddress": "81.2.69.142"}],
+         "IPv6Addresses": [{"uid": 1, "IPAddress": "2001:db8::1"}],
+         "Stats": {"BytesSent": "5368709120", "BytesReceived": "53687091200", "PacketsSent": "4000000", "PacketsReceived": "40000000", "ErrorsSent": 0, "ErrorsReceived": 3}}
+      ]
+    },
+    "PPP": {
+      "Interfaces": [
+        {"uid": 1, "Alias": "PPP_DATA", "Status": "Up", "ConnectionStatus": "Connected", "LastConnectionError": "ERROR_NONE",
+         "LastChange": 86000, "LowerLayers": "Device.DSL.Channel.1", "IPCP": {"DNSServers": "81.139.56.100,81.139.57.100"}}
+      ]
+    },
+    "DSL": {
+      "Lines": [
+        {"uid": 1, "Status": "Up", "DownstreamNoiseMargin": 62, "UpstreamNoiseMargin": 58, "DownstreamAttenuation": 145,
+         "UpstreamAttenuation": 120, "DownstreamMaxBitRate": 79987, "UpstreamMaxBitRate": 20000,
+         "Stats": {"Total": {"ErroredSecs": 12, "SeverelyErroredSecs": 1, "LinkRetrain": 2}}}
+      ],
+      "Channels": [
+        {"uid": 1, "Status": "Up", "LowerLayers": "Device.DSL.Line.1", "DownstreamCurrRate": 79987, "UpstreamCurrRate": 20000,
+         "Stats": {"Total": {"XTURCRCErrors": 5, "XTUCCRCErrors": 2, "XTURFECErrors": 1500, "XTUCFECErrors": 30, "XTURHECErrors": 0, "XTUCHECErrors": 0}}}
+      ]
+    },
+    "WiFi": {
+      "Radios": [
+        {"uid": 1, "Alias": "RADIO2G4", "Enable": true, "Status": "Up", "OperatingFrequencyBand": "2.4GHz", "Channel": 6,
+         "CurrentOperatingChannelBandwidth": "20MHz", "TransmitPower": 100, "Stats": {"Noise": -92}},
+        {"uid": 2, "Alias": "RADIO5G", "Enable": true, "Status": "Up", "OperatingFrequencyBand": "5GHz", "Channel": 36,
+         "CurrentOperatingChannelBandwidth": "80MHz", "TransmitPower": 100, "Stats": {"Noise": -95}}
+      ],
+      "SSIDs": [
+        {"uid": 1, "Alias": "WL_PRIV", "Enable": true, "Status": "Up", "SSID": "BT-ABC123", "LowerLayers": "Device.WiFi.Radio.1",
+         "Stats": {"BytesSent": "104857600", "BytesReceived": "52428800", "PacketsSent": "80000", "PacketsReceived": "60000", "ErrorsSent": 0, "ErrorsReceived": 0}},
+        {"uid": 2, "Alias": "WL_PRIV_5G", "Enable": true, "Status": "Up", "SSID": "BT-ABC123", "LowerLayers": "Device.WiFi.Radio.2",
+         "Stats": {"BytesSent": "524288000", "BytesReceived": "104857600", "PacketsSent": "400000", "PacketsReceived": "90000", "ErrorsSent": 0, "ErrorsReceived": 0}}
+      ],
+      "AccessPoints": [
+        {"uid": 1, "Alias": "AP_PRIV", "Enable": true, "SSIDReference": "Device.WiFi.SSID.1", "AssociatedDeviceNumberOfEntries": 1,
+         "AssociatedDevices": [{"MACAddress": "AA:BB:CC:DD:EE:01", "Active": true, "SignalStrength": -55, "LastDataDownlinkRate": 72000, "LastDataUplinkRate": 65000}]},
+        {"uid": 2, "Alias": "AP_PRIV_5G", "Enable": true, "SSIDReference": "Device.WiFi.SSID.2", "AssociatedDeviceNumberOfEntries": 1,
+         "AssociatedDevices": [{"MACAddress": "AA:BB:CC:DD:EE:02", "Active": true, "SignalStrength": -48, "LastDataDownlinkRate": 866000, "LastDataUplinkRate": 650000}]}
+      ]
+    },
+    "Hosts": {
+      "Hosts": [
+        {"uid": 1, "PhysAddress": "AA:BB:CC:DD:EE:01", "IPAddress": "192.168.1.64", "AddressSource": "DHCP", "HostName": "phone",
+         "Active": true, "InterfaceType": "WiFi", "Layer1Interface": "Device.WiFi.SSID.1", "DetectedDeviceType": "SMARTPHONE",
+         "LeaseTimeRemaining": 80000, "FirstSeen": "2024-01-01T10:00:00Z", "LastConnection": "2024-06-01T08:00:00Z"},
+        {"uid": 2, "PhysAddress": "AA:BB:CC:DD:EE:02", "IPAddress": "192.168.1.65", "AddressSource": "DHCP", "HostName": "laptop",
+         "Active": true, "InterfaceType": "WiFi", "Layer1Interface": "Device.WiFi.SSID.2", "DetectedDeviceType": "COMPUTER",
+         "LeaseTimeRemaining": 80000, "FirstSeen": "2024-01-01T10:00:00Z", "LastConnection": "2024-06-01T08:00:00Z"},
+        {"uid": 3, "PhysAddress": "AA:BB:CC:DD:EE:03", "IPAddress": "192.168.1.66", "AddressSource": "Static", "HostName": "nas",
+         "Active": true, "InterfaceType": "Ethernet", "Layer1Interface": "Device.Ethernet.Interface.1", "DetectedDeviceType": "STORAGE",
+         "LeaseTimeRemaining": 0, "FirstSeen": "2024-01-01T10:00:00Z", "LastConnection": "2024-06-01T08:00:00Z"},
+        {"uid": 4, "PhysAddress": "AA:BB:CC:DD:EE:04", "IPAddress": "192.168.1.67", "AddressSource": "DHCP", "HostName": "tablet",
+         "Active": false, "InterfaceType": "WiFi", "Layer1Interface": "Device.WiFi.SSID.1", "DetectedDeviceType": "TABLET",
+         "LeaseTimeRemaining": 0, "FirstSeen": "2024-01-01T10:00:00Z", "LastConnection": "2024-05-01T08:00:00Z"}
+      ]
+    },
+    "Services": {
+      "BandwidthMonitoring": {"Enable": true}
+    }
+  }
+}`
+
+// DefaultDataModel returns the data model served by a Hub unless WithDataModel is used. It resembles a Home Hub
+// connected over VDSL with a few devices attached
+func DefaultDataModel() map[string]interface{} {
+	var model map[string]interface{}
+	if err := json.Unmarshal([]byte(defaultDataModel), &model); err != nil {
+		panic(err)
+	}
+	return model
+}
+
+// defaultBandwidthRecords returns a week of bandwidth statistics for the devices in the default data model
+func defaultBandwidthRecords(now time.Time) []BandwidthRecord {
+	var records []BandwidthRecord
+	for day := 6; day >= 0; day-- {
+		date := now.AddDate(0, 0, -day)
+		records = append(records,
+			BandwidthRecord{MACAddress: "AA:BB:CC:DD:EE:01", Date: date, Downloaded: 250, Uploaded: 25},
+			BandwidthRecord{MACAddress: "AA:BB:CC:DD:EE:02", Date: date, Downloaded: 1200, Uploaded: 300},
+			BandwidthRecord{MACAddress: "AA:BB:CC:DD:EE:03", Date: date, Downloaded: 40, Uploaded: 900},
+		)
+	}
+	return records
+}
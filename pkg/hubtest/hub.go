@@ -0,0 +1,618 @@
+// Package hubtest provides a fake Home Hub for tests and demos. It implements the JSON-RPC API served at
+// cgi/json-req, including the login handshake, auth-key validation and the bandwidth monitoring file download,
+// and can inject faults such as expired sessions, slow responses, error codes and malformed replies
+package hubtest
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default credentials accepted by a Hub
+const (
+	DefaultUsername = "admin"
+	DefaultPassword = "password"
+)
+
+// APIPath is the path of the JSON-RPC API
+const APIPath = "/cgi/json-req"
+
+// Error descriptions returned by the Home Hub
+const (
+	ErrorOk             = "Ok"
+	ErrorNone           = "XMO_NO_ERR"
+	ErrorRequestNone    = "XMO_REQUEST_NO_ERR"
+	ErrorInvalidSession = "XMO_INVALID_SESSION_ERR"
+	ErrorAuthentication = "XMO_AUTHENTICATION_ERR"
+	ErrorRequestID      = "XMO_REQUEST_ID_ERR"
+	ErrorRequestAction  = "XMO_REQUEST_ACTION_ERR"
+	ErrorUnknownPath    = "XMO_UNKNOWN_PATH_ERR"
+	ErrorUnknownAction  = "XMO_UNKNOWN_ACTION_ERR"
+	ErrorInvalidParam   = "XMO_INVALID_PARAM_ERR"
+)
+
+// errorCodes are the numeric codes sent alongside each error description
+var errorCodes = map[string]int{
+	ErrorOk:             16777216,
+	ErrorInvalidSession: 16777219,
+	ErrorRequestAction:  16777221,
+	ErrorAuthentication: 16777223,
+	ErrorRequestID:      16777225,
+	ErrorInvalidParam:   16777231,
+	ErrorUnknownAction:  16777233,
+	ErrorUnknownPath:    16777236,
+	ErrorNone:           16777238,
+	ErrorRequestNone:    16777238,
+}
+
+const bandwidthDateFormat = "20060102"
+
+// BandwidthRecord is the amount of data transferred by a device on a day, as listed in the bandwidth
+// monitoring file
+type BandwidthRecord struct {
+	MACAddress string
+	Date       time.Time
+	// Downloaded is the number of megabytes downloaded by the device
+	Downloaded float64
+	// Uploaded is the number of megabytes uploaded by the device
+	Uploaded float64
+}
+
+// Action is an action that a Hub received in a request with a valid session
+type Action struct {
+	Method string
+	XPath  string
+	// StartDate and EndDate are the parameters of an uploadBMStatisticsFile action
+	StartDate string
+	EndDate   string
+}
+
+// Hub is a fake Home Hub. It is an http.Handler and is safe for concurrent use
+type Hub struct {
+	mutex        sync.Mutex
+	userName     string
+	passwordHash string
+	model        map[string]interface{}
+	bandwidth    []BandwidthRecord
+
+	sessions      map[int]*session
+	lastSessionID int
+	files         map[string][]byte
+	lastFileID    int
+
+	logins         int
+	requests       int
+	actions        []Action
+	protocolErrors []string
+
+	status    int
+	latency   time.Duration
+	malformed int
+	errors    map[string]string
+}
+
+type session struct {
+	nonce         string
+	lastRequestID int32
+}
+
+// Option configures a Hub
+type Option func(*Hub)
+
+// WithCredentials sets the username and plain text password that the hub accepts
+func WithCredentials(userName string, password string) Option {
+	return func(h *Hub) {
+		h.userName = userName
+		h.passwordHash = hexmd5(password)
+	}
+}
+
+// WithDataModel sets the data model tree served by getValue. Objects are maps keyed by name and tables are lists of
+// objects with a uid
+func WithDataModel(model map[string]interface{}) Option {
+	return func(h *Hub) {
+		h.model = model
+	}
+}
+
+// WithBandwidthRecords sets the contents of the bandwidth monitoring file
+func WithBandwidthRecords(records ...BandwidthRecord) Option {
+	return func(h *Hub) {
+		h.bandwidth = records
+	}
+}
+
+// New creates a Hub serving the default data model and a week of bandwidth statistics
+func New(opts ...Option) *Hub {
+	h := &Hub{
+		userName:     DefaultUsername,
+		passwordHash: hexmd5(DefaultPassword),
+		model:        DefaultDataModel(),
+		bandwidth:    defaultBandwidthRecords(time.Now()),
+		sessions:     make(map[int]*session),
+		files:        make(map[string][]byte),
+		errors:       make(map[string]string),
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// NewServer starts a server for a new Hub. The caller must close the server
+func NewServer(opts ...Option) (*Hub, *httptest.Server) {
+	h := New(opts...)
+	return h, httptest.NewServer(h)
+}
+
+// SetValue sets the value at xpath, creating any objects and table entries that are missing
+func (h *Hub) SetValue(xpath string, value interface{}) error {
+	normalized, err := normalize(value)
+	if err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return store(h.model, xpath, normalized)
+}
+
+// Value returns the value at xpath
+func (h *Hub) Value(xpath string) (interface{}, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	value, ok := lookup(h.model, xpath)
+	if !ok {
+		return nil, false
+	}
+
+	// Return a copy, so that the caller cannot modify the data model without holding the mutex
+	copied, err := normalize(value)
+	return copied, err == nil
+}
+
+// ExpireSession ends every session, so that the next request is rejected with XMO_INVALID_SESSION_ERR
+func (h *Hub) ExpireSession() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.sessions = make(map[int]*session)
+}
+
+// SetLatency delays every response by latency
+func (h *Hub) SetLatency(latency time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.latency = latency
+}
+
+// SetHTTPStatus makes the hub reply to every request with the given HTTP status code. A status of 0 restores
+// normal replies
+func (h *Hub) SetHTTPStatus(status int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.status = status
+}
+
+// SetMalformedReplies makes the hub reply to the next count JSON-RPC requests with truncated JSON
+func (h *Hub) SetMalformedReplies(count int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.malformed = count
+}
+
+// SetError makes the hub fail actions on xpath with the given error description, such as XMO_UNKNOWN_PATH_ERR.
+// An empty description clears the error
+func (h *Hub) SetError(xpath string, description string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if description == "" {
+		delete(h.errors, xpath)
+		return
+	}
+	h.errors[xpath] = description
+}
+
+// Logins returns the number of successful logins
+func (h *Hub) Logins() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.logins
+}
+
+// Requests returns the number of JSON-RPC requests received
+func (h *Hub) Requests() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.requests
+}
+
+// Actions returns the actions received in requests with a valid session, in the order that they were performed
+func (h *Hub) Actions() []Action {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]Action(nil), h.actions...)
+}
+
+// ProtocolErrors returns a description of each request that a real Home Hub would have rejected, such as one with
+// an invalid auth-key or a request id that was not incremented
+func (h *Hub) ProtocolErrors() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]string(nil), h.protocolErrors...)
+}
+
+// Advance moves the clock of the hub forward, increasing the uptime of the Home Hub and its WAN connection and the
+// traffic counters of the WAN interface. Values that are missing from the data model are left alone
+func (h *Hub) Advance(elapsed time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	seconds := elapsed.Seconds()
+	increments := map[string]float64{
+		"Device/DeviceInfo/UpTime":                                     seconds,
+		"Device/PPP/Interfaces/Interface[@uid='1']/LastChange":         seconds,
+		"Device/IP/Interfaces/Interface[@uid='3']/Stats/BytesReceived": seconds * 2500000,
+		"Device/IP/Interfaces/Interface[@uid='3']/Stats/BytesSent":     seconds * 250000,
+	}
+
+	for xpath, increment := range increments {
+		value, ok := lookup(h.model, xpath)
+		if !ok {
+			continue
+		}
+
+		switch v := value.(type) {
+		case float64:
+			store(h.model, xpath, v+increment) //nolint:errcheck
+		case string:
+			if current, err := strconv.ParseFloat(v, 64); err == nil {
+				store(h.model, xpath, strconv.FormatFloat(current+increment, 'f', 0, 64)) //nolint:errcheck
+			}
+		}
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mutex.Lock()
+	status, latency := h.status, h.latency
+	h.mutex.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == APIPath:
+		h.serveAPI(w, r)
+	case r.Method == http.MethodGet:
+		h.serveFile(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type hubRequest struct {
+	Request struct {
+		ID        int32       `json:"id"`
+		SessionID string      `json:"session-id"`
+		Actions   []hubAction `json:"actions"`
+		CNonce    int64       `json:"cnonce"`
+		AuthKey   string      `json:"auth-key"`
+	} `json:"request"`
+}
+
+type hubAction struct {
+	ID         int    `json:"id"`
+	Method     string `json:"method"`
+	XPath      string `json:"xpath"`
+	Parameters struct {
+		User      string `json:"user"`
+		StartDate string `json:"startDate"`
+		EndDate   string `json:"endDate"`
+	} `json:"parameters"`
+}
+
+// sessionCookie is the session cookie sent with every request
+type sessionCookie struct {
+	SessionID int    `json:"sess_id"`
+	User      string `json:"user"`
+	Ha1       string `json:"ha1"`
+	Nonce     string `json:"nonce"`
+}
+
+func (h *Hub) serveAPI(w http.ResponseWriter, r *http.Request) {
+	var req hubRequest
+	if err := json.Unmarshal([]byte(r.FormValue("req")), &req); err != nil {
+		h.protocolError("invalid request payload: %s", err)
+		http.Error(w, "invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	cookie, err := readSessionCookie(r)
+	if err != nil {
+		h.protocolError("invalid session cookie: %s", err)
+		http.Error(w, "invalid session cookie", http.StatusBadRequest)
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.requests++
+
+	w.Header().Set("Content-Type", "application/json")
+	if h.malformed > 0 {
+		h.malformed--
+		//nolint:golint,errcheck
+		w.Write([]byte(`{"reply": {"uid": 0, "id": `))
+		return
+	}
+
+	//nolint:golint,errcheck
+	json.NewEncoder(w).Encode(h.reply(req, cookie))
+}
+
+func readSessionCookie(r *http.Request) (sessionCookie, error) {
+	var cookie sessionCookie
+
+	c, err := r.Cookie("session")
+	if err != nil {
+		return cookie, err
+	}
+
+	value, err := url.QueryUnescape(c.Value)
+	if err != nil {
+		return cookie, err
+	}
+
+	err = json.Unmarshal([]byte(value), &cookie)
+	return cookie, err
+}
+
+// reply processes a JSON-RPC request. The caller must hold the mutex
+func (h *Hub) reply(req hubRequest, cookie sessionCookie) map[string]interface{} {
+	if req.Request.SessionID == "0" {
+		return h.login(req, cookie)
+	}
+
+	sessionID, _ := strconv.Atoi(req.Request.SessionID)
+	s, ok := h.sessions[sessionID]
+	if !ok {
+		return replyError(req, ErrorInvalidSession)
+	}
+
+	if description := h.authenticate(req, cookie, sessionID, s.nonce); description != "" {
+		return replyError(req, description)
+	}
+
+	if req.Request.ID <= s.lastRequestID {
+		h.protocolError("request id %d received after request id %d", req.Request.ID, s.lastRequestID)
+		return replyError(req, ErrorRequestID)
+	}
+	s.lastRequestID = req.Request.ID
+
+	actions := make([]map[string]interface{}, 0, len(req.Request.Actions))
+	for _, action := range req.Request.Actions {
+		actions = append(actions, h.action(action))
+	}
+	return replyOk(req, actions)
+}
+
+// login starts a new session. The auth-key of a login request is computed with an empty nonce
+func (h *Hub) login(req hubRequest, cookie sessionCookie) map[string]interface{} {
+	if len(req.Request.Actions) != 1 || req.Request.Actions[0].Method != "logIn" {
+		h.protocolError("request without a session must be a single logIn action")
+		return replyError(req, ErrorInvalidSession)
+	}
+
+	action := req.Request.Actions[0]
+	if action.Parameters.User != h.userName {
+		return replyError(req, ErrorAuthentication)
+	}
+
+	if description := h.authenticate(req, cookie, 0, ""); description != "" {
+		return replyError(req, description)
+	}
+
+	h.lastSessionID++
+	h.logins++
+	s := &session{
+		nonce:         strconv.FormatInt(rand.Int63(), 10),
+		lastRequestID: req.Request.ID,
+	}
+	h.sessions[h.lastSessionID] = s
+
+	return replyOk(req, []map[string]interface{}{
+		actionOk(action, map[string]interface{}{"id": h.lastSessionID, "nonce": s.nonce}),
+	})
+}
+
+// authenticate checks the auth-key and session cookie of a request in the same way as the Home Hub, returning
+// the error description if they are invalid
+func (h *Hub) authenticate(req hubRequest, cookie sessionCookie, sessionID int, nonce string) string {
+	ha1 := hexmd5(fmt.Sprintf("%s:%s:%s", h.userName, nonce, h.passwordHash))
+	authKey := hexmd5(fmt.Sprintf("%s:%d:%d:JSON:%s", ha1, req.Request.ID, req.Request.CNonce, APIPath))
+	if req.Request.AuthKey != authKey {
+		h.protocolError("invalid auth-key for request id %d", req.Request.ID)
+		return ErrorAuthentication
+	}
+
+	if cookie.SessionID != sessionID || cookie.Nonce != nonce || cookie.User != h.userName {
+		h.protocolError("session cookie does not match session %d", sessionID)
+		return ErrorInvalidSession
+	}
+
+	if cookie.Ha1 != ha1[:10]+h.passwordHash+ha1[10:] {
+		h.protocolError("invalid ha1 in the session cookie of session %d", sessionID)
+		return ErrorAuthentication
+	}
+	return ""
+}
+
+// action performs a single action. The caller must hold the mutex
+func (h *Hub) action(action hubAction) map[string]interface{} {
+	h.actions = append(h.actions, Action{
+		Method:    action.Method,
+		XPath:     action.XPath,
+		StartDate: action.Parameters.StartDate,
+		EndDate:   action.Parameters.EndDate,
+	})
+
+	if description, ok := h.errors[action.XPath]; ok {
+		return actionError(action, description)
+	}
+
+	switch action.Method {
+	case "getValue":
+		value, ok := lookup(h.model, action.XPath)
+		if !ok {
+			return actionError(action, ErrorUnknownPath)
+		}
+		return actionOk(action, map[string]interface{}{"value": value})
+	case "uploadBMStatisticsFile":
+		startDate, err := time.Parse(bandwidthDateFormat, action.Parameters.StartDate)
+		if err != nil {
+			return actionError(action, ErrorInvalidParam)
+		}
+		endDate, err := time.Parse(bandwidthDateFormat, action.Parameters.EndDate)
+		if err != nil {
+			return actionError(action, ErrorInvalidParam)
+		}
+
+		h.lastFileID++
+		name := fmt.Sprintf("BMStatistics_%d.csv", h.lastFileID)
+		h.files[name] = h.bandwidthFile(startDate, endDate)
+		return actionOk(action, map[string]interface{}{"data": name})
+	}
+	return actionError(action, ErrorUnknownAction)
+}
+
+// bandwidthFile returns the bandwidth monitoring records between the start and end dates inclusive
+func (h *Hub) bandwidthFile(startDate time.Time, endDate time.Time) []byte {
+	serialNumber, _ := lookup(h.model, "Device/DeviceInfo/SerialNumber")
+
+	var file strings.Builder
+	for _, record := range h.bandwidth {
+		day, _ := time.Parse(bandwidthDateFormat, record.Date.Format(bandwidthDateFormat))
+		if day.Before(startDate) || day.After(endDate) {
+			continue
+		}
+		fmt.Fprintf(&file, "%v,%s,%s,%g,%g\n", serialNumber, record.MACAddress, record.Date.Format("2006-01-02"), record.Downloaded, record.Uploaded)
+	}
+	return []byte(file.String())
+}
+
+// serveFile serves a bandwidth monitoring file to a client with a valid session
+func (h *Hub) serveFile(w http.ResponseWriter, r *http.Request) {
+	cookie, err := readSessionCookie(r)
+
+	h.mutex.Lock()
+	_, validSession := h.sessions[cookie.SessionID]
+	file, ok := h.files[strings.TrimPrefix(r.URL.Path, "/")]
+	h.mutex.Unlock()
+
+	if err != nil || !validSession {
+		http.Error(w, "invalid session", http.StatusForbidden)
+		return
+	}
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	//nolint:golint,errcheck
+	w.Write(file)
+}
+
+// protocolError records a request that a real Home Hub would have rejected. The caller must hold the mutex
+func (h *Hub) protocolError(format string, args ...interface{}) {
+	h.protocolErrors = append(h.protocolErrors, fmt.Sprintf(format, args...))
+}
+
+// replyOk returns the reply to a request whose actions were performed. As in testdata/action-error.yaml, the
+// reply reports XMO_REQUEST_ACTION_ERR if any of the actions failed
+func replyOk(req hubRequest, actions []map[string]interface{}) map[string]interface{} {
+	description := ErrorOk
+	for _, action := range actions {
+		if action["error"].(map[string]interface{})["description"] != ErrorNone {
+			description = ErrorRequestAction
+		}
+	}
+
+	return map[string]interface{}{
+		"reply": map[string]interface{}{
+			"uid":     0,
+			"id":      req.Request.ID,
+			"error":   errorValue(description),
+			"actions": actions,
+		},
+	}
+}
+
+func replyError(req hubRequest, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"reply": map[string]interface{}{
+			"uid":   0,
+			"id":    req.Request.ID,
+			"error": errorValue(description),
+		},
+	}
+}
+
+func actionOk(action hubAction, parameters map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"uid":   1,
+		"id":    action.ID,
+		"error": errorValue(ErrorNone),
+		"callbacks": []map[string]interface{}{
+			{"uid": 1, "result": errorValue(ErrorRequestNone), "xpath": action.XPath, "parameters": parameters},
+		},
+		"events": []interface{}{},
+	}
+}
+
+func actionError(action hubAction, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"uid":   1,
+		"id":    action.ID,
+		"error": errorValue(description),
+		"callbacks": []map[string]interface{}{
+			{"uid": 1, "result": errorValue(description), "xpath": action.XPath, "parameters": map[string]interface{}{}},
+		},
+		"events": []interface{}{},
+	}
+}
+
+func errorValue(description string) map[string]interface{} {
+	return map[string]interface{}{"code": errorCodes[description], "description": description}
+}
+
+func hexmd5(s string) string {
+	hash := md5.Sum([]byte(s))
+	return hex.EncodeToString(hash[:])
+}
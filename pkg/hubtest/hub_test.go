@@ -0,0 +1,280 @@
+package hubtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/cassette"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+)
+
+func newClient(server string, opts ...client.Option) client.Client {
+	return client.New(server, DefaultUsername, DefaultPassword, opts...)
+}
+
+func TestClientSession(t *testing.T) {
+	hub, server := NewServer(WithBandwidthRecords(
+		BandwidthRecord{MACAddress: "AA:BB:CC:DD:EE:01", Date: time.Now().AddDate(0, 0, -1), Downloaded: 100, Uploaded: 10},
+		BandwidthRecord{MACAddress: "AA:BB:CC:DD:EE:02", Date: time.Now(), Downloaded: 200, Uploaded: 20},
+	))
+	defer server.Close()
+
+	homehub := newClient(server.URL)
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	values, err := homehub.GetValues(context.Background(), client.UpTime, client.DownloadedBytes, "Device/Unknown")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if values[client.UpTime].Value != 86400.0 {
+		t.Fatalf("Expected uptime 86400. Got %v", values[client.UpTime].Value)
+	}
+	if values[client.DownloadedBytes].Value != "53687091200" {
+		t.Fatalf("Expected downloaded bytes 53687091200. Got %v", values[client.DownloadedBytes].Value)
+	}
+	if err := values["Device/Unknown"].Error; err == nil || !strings.Contains(err.Error(), ErrorUnknownPath) {
+		t.Fatalf("Expected %s for an unknown XPath. Got %v", ErrorUnknownPath, err)
+	}
+
+	devices, err := homehub.GetDevices(context.Background())
+	if err != nil || len(devices) != 4 {
+		t.Fatalf("Expected 4 devices. Got %d, %v", len(devices), err)
+	}
+
+	response := homehub.GetBandwidthStatistics(context.Background())
+	if response.Error != nil {
+		t.Fatalf("Unexpected error: %s", response.Error)
+	}
+	if records := client.ParseBandwidthRecords(response.Body); len(records) != 2 || records[1].Downloaded != 200 {
+		t.Fatalf("Expected 2 bandwidth records. Got %+v", records)
+	}
+
+	// Only the current day is requested after the first download
+	response = homehub.GetBandwidthStatistics(context.Background())
+	if records := client.ParseBandwidthRecords(response.Body); len(records) != 1 || records[0].MACAddress != "AA:BB:CC:DD:EE:02" {
+		t.Fatalf("Expected today's bandwidth record. Got %+v", records)
+	}
+
+	actions := hub.Actions()
+	today := time.Now().Format(bandwidthDateFormat)
+	if last := actions[len(actions)-1]; last.Method != "uploadBMStatisticsFile" || last.StartDate != today || last.EndDate != today {
+		t.Fatalf("Expected the last action to upload today's bandwidth statistics. Got %+v", last)
+	}
+
+	if errors := hub.ProtocolErrors(); len(errors) > 0 {
+		t.Fatalf("Unexpected protocol errors: %v", errors)
+	}
+}
+
+func TestInvalidCredentials(t *testing.T) {
+	hub, server := NewServer(WithCredentials("admin", "secret"))
+	defer server.Close()
+
+	response := newClient(server.URL).Login(context.Background())
+	if response.Error == nil || response.Error.Error() != ErrorAuthentication {
+		t.Fatalf("Expected %s. Got %v", ErrorAuthentication, response.Error)
+	}
+
+	if hub.Logins() != 0 {
+		t.Fatalf("Expected no successful logins. Got %d", hub.Logins())
+	}
+
+	if response := client.New(server.URL, "admin", "secret").Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+}
+
+func TestExpiredSession(t *testing.T) {
+	hub, server := NewServer()
+	defer server.Close()
+
+	homehub := newClient(server.URL)
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	hub.ExpireSession()
+
+	if response := homehub.GetSummaryStatistics(context.Background()); response.Error != nil {
+		t.Fatalf("Unexpected error: %s", response.Error)
+	}
+
+	if hub.Logins() != 2 || homehub.Relogins() != 1 {
+		t.Fatalf("Expected the client to log in again. Got %d logins and %d relogins", hub.Logins(), homehub.Relogins())
+	}
+
+	if errors := hub.ProtocolErrors(); len(errors) > 0 {
+		t.Fatalf("Unexpected protocol errors: %v", errors)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	hub, server := NewServer()
+	defer server.Close()
+
+	homehub := newClient(server.URL, client.WithTimeout(200*time.Millisecond))
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	hub.SetError(client.UpTime, "XMO_ACCESS_RESTRICTED_ERR")
+	values, err := homehub.GetValues(context.Background(), client.UpTime)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := values[client.UpTime].Error; err == nil || !strings.Contains(err.Error(), "XMO_ACCESS_RESTRICTED_ERR") {
+		t.Fatalf("Expected XMO_ACCESS_RESTRICTED_ERR. Got %v", err)
+	}
+	hub.SetError(client.UpTime, "")
+
+	hub.SetMalformedReplies(1)
+	if _, err := homehub.GetValues(context.Background(), client.UpTime); err == nil {
+		t.Fatal("Expected an error for a malformed reply")
+	}
+
+	hub.SetHTTPStatus(http.StatusServiceUnavailable)
+	if _, err := homehub.GetValues(context.Background(), client.UpTime); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("Expected an HTTP 503 error. Got %v", err)
+	}
+	hub.SetHTTPStatus(0)
+
+	hub.SetLatency(time.Second)
+	if _, err := homehub.GetValues(context.Background(), client.UpTime); err == nil {
+		t.Fatal("Expected a timeout for a slow response")
+	}
+	hub.SetLatency(0)
+
+	if _, err := homehub.GetValues(context.Background(), client.UpTime); err != nil {
+		t.Fatalf("Expected the hub to recover. Got %s", err)
+	}
+}
+
+func TestInvalidAuthKey(t *testing.T) {
+	hub, server := NewServer()
+	defer server.Close()
+
+	form := url.Values{}
+	form.Add("req", `{"request":{"id":0,"session-id":"0","actions":[{"id":0,"method":"logIn","parameters":{"user":"admin"}}],"cnonce":1,"auth-key":"invalid"}}`)
+
+	request, err := http.NewRequest(http.MethodPost, server.URL+APIPath, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.AddCookie(&http.Cookie{Name: "session", Value: url.QueryEscape(`{"sess_id":0,"user":"admin","ha1":"","nonce":""}`)})
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if errors := hub.ProtocolErrors(); len(errors) != 1 || !strings.Contains(errors[0], "auth-key") {
+		t.Fatalf("Expected an invalid auth-key error. Got %v", errors)
+	}
+}
+
+func TestDataModel(t *testing.T) {
+	hub := New()
+
+	if err := hub.SetValue("Device/IP/Interfaces/Interface[@uid='5']/Stats/BytesSent", "42"); err != nil {
+		t.Fatal(err)
+	}
+
+	value, ok := hub.Value("Device/IP/Interfaces/Interface[@uid='5']/Stats/BytesSent")
+	if !ok || value != "42" {
+		t.Fatalf("Expected 42. Got %v", value)
+	}
+
+	interfaces, _ := hub.Value("Device/IP/Interfaces")
+	if len(interfaces.([]interface{})) != 4 {
+		t.Fatalf("Expected a new interface to be added. Got %v", interfaces)
+	}
+
+	if err := hub.SetValue("Device/DeviceInfo/UpTime/Seconds", 1); err == nil {
+		t.Fatal("Expected an error setting a value below a number")
+	}
+
+	hub.Advance(10 * time.Second)
+	if uptime, _ := hub.Value("Device/DeviceInfo/UpTime"); uptime != 86410.0 {
+		t.Fatalf("Expected uptime 86410. Got %v", uptime)
+	}
+	if received, _ := hub.Value("Device/IP/Interfaces/Interface[@uid='3']/Stats/BytesReceived"); received != "53712091200" {
+		t.Fatalf("Expected 53712091200 bytes received. Got %v", received)
+	}
+}
+
+// TestActionErrorReply checks that the hub reports failed actions in the same way as the reply in
+// testdata/action-error.yaml, and that the client reads both replies alike
+func TestActionErrorReply(t *testing.T) {
+	recorded, err := cassette.Load(filepath.Join("testdata", "action-error.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, server := NewServer()
+	defer server.Close()
+
+	var recorder *cassette.Recorder
+	homehub := newClient(server.URL, client.WithTransportWrapper(func(next http.RoundTripper) http.RoundTripper {
+		recorder = cassette.NewRecorder(next)
+		return recorder
+	}))
+	if response := homehub.Login(context.Background()); response.Error != nil {
+		t.Fatalf("Login failed: %s", response.Error)
+	}
+
+	replayed := newClient("http://homehub", client.WithTransportWrapper(func(http.RoundTripper) http.RoundTripper {
+		return recorded.Transport()
+	}))
+
+	for name, c := range map[string]client.Client{"hub": homehub, "cassette": replayed} {
+		values, err := c.GetValues(context.Background(), client.UpTime, "Device/Unknown")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+		if values[client.UpTime].Value != 86400.0 {
+			t.Fatalf("%s: expected uptime 86400. Got %+v", name, values[client.UpTime])
+		}
+		if err := values["Device/Unknown"].Error; err == nil || !strings.Contains(err.Error(), ErrorUnknownPath) {
+			t.Fatalf("%s: expected %s for an unknown XPath. Got %v", name, ErrorUnknownPath, err)
+		}
+	}
+
+	interactions := recorder.Cassette().Interactions
+	expected := replyErrors(t, recorded.Interactions[0].Response.Body)
+	if actual := replyErrors(t, interactions[len(interactions)-1].Response.Body); actual != expected {
+		t.Fatalf("Expected reply errors %s. Got %s", expected, actual)
+	}
+}
+
+// replyErrors returns the error codes and descriptions of a reply and each of its actions
+func replyErrors(t *testing.T, body string) string {
+	var reply struct {
+		Reply struct {
+			Error   interface{} `json:"error"`
+			Actions []struct {
+				Error interface{} `json:"error"`
+			} `json:"actions"`
+		} `json:"reply"`
+	}
+	if err := json.Unmarshal([]byte(body), &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	errors := fmt.Sprint(reply.Reply.Error)
+	for _, action := range reply.Reply.Actions {
+		errors += " " + fmt.Sprint(action.Error)
+	}
+	return errors
+}
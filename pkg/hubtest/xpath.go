@@ -0,0 +1,159 @@
+package hubtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// segmentPattern matches a step of an XPath, which is either a name such as Interfaces or a list entry selected
+// by uid such as Interface[@uid='3']
+var segmentPattern = regexp.MustCompile(`^([^\[\]]+)(?:\[@uid='?(\d+)'?\])?$`)
+
+type segment struct {
+	name   string
+	uid    int
+	hasUID bool
+}
+
+func parseXPath(xpath string) ([]segment, error) {
+	var segments []segment
+	for _, step := range strings.Split(xpath, "/") {
+		match := segmentPattern.FindStringSubmatch(step)
+		if match == nil {
+			return nil, fmt.Errorf("invalid XPath %q", xpath)
+		}
+
+		s := segment{name: match[1]}
+		if match[2] != "" {
+			s.uid, _ = strconv.Atoi(match[2])
+			s.hasUID = true
+		}
+		segments = append(segments, s)
+	}
+	return segments, nil
+}
+
+// lookup returns the value at xpath in the data model. Lists are selected by name and their entries by uid,
+// so Device/IP/Interfaces returns every interface and Device/IP/Interfaces/Interface[@uid='3'] returns one
+func lookup(model map[string]interface{}, xpath string) (interface{}, bool) {
+	segments, err := parseXPath(xpath)
+	if err != nil {
+		return nil, false
+	}
+
+	var node interface{} = model
+	for _, s := range segments {
+		if s.hasUID {
+			list, ok := node.([]interface{})
+			if !ok {
+				return nil, false
+			}
+
+			index := findUID(list, s.uid)
+			if index < 0 {
+				return nil, false
+			}
+			node = list[index]
+			continue
+		}
+
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		node, ok = object[s.name]
+		if !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// store sets the value at xpath in the data model, creating any objects and list entries that are missing
+func store(model map[string]interface{}, xpath string, value interface{}) error {
+	segments, err := parseXPath(xpath)
+	if err != nil {
+		return err
+	}
+
+	if segments[0].hasUID {
+		return fmt.Errorf("invalid XPath %q. The root must be an object", xpath)
+	}
+
+	child, err := storeValue(model[segments[0].name], segments[1:], value)
+	if err != nil {
+		return fmt.Errorf("unable to set %s: %s", xpath, err)
+	}
+	model[segments[0].name] = child
+	return nil
+}
+
+func storeValue(node interface{}, segments []segment, value interface{}) (interface{}, error) {
+	if len(segments) == 0 {
+		return value, nil
+	}
+
+	s := segments[0]
+	if s.hasUID {
+		list, ok := node.([]interface{})
+		if node != nil && !ok {
+			return nil, fmt.Errorf("%s is not a list", s.name)
+		}
+
+		index := findUID(list, s.uid)
+		if index < 0 {
+			list = append(list, map[string]interface{}{"uid": float64(s.uid)})
+			index = len(list) - 1
+		}
+
+		child, err := storeValue(list[index], segments[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		list[index] = child
+		return list, nil
+	}
+
+	object, ok := node.(map[string]interface{})
+	if node != nil && !ok {
+		return nil, fmt.Errorf("%s is not an object", s.name)
+	}
+	if object == nil {
+		object = make(map[string]interface{})
+	}
+
+	child, err := storeValue(object[s.name], segments[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	object[s.name] = child
+	return object, nil
+}
+
+func findUID(list []interface{}, uid int) int {
+	for i, entry := range list {
+		if object, ok := entry.(map[string]interface{}); ok {
+			if value, ok := object["uid"].(float64); ok && int(value) == uid {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// normalize converts a value to the types produced by decoding JSON, so that values set by tests can be looked
+// up in the same way as the data model
+func normalize(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var normalized interface{}
+	err = json.Unmarshal(data, &normalized)
+	return normalized, err
+}
@@ -0,0 +1,103 @@
+// Package logging configures structured logging and removes Home Hub credentials from log output
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Supported log formats
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values are always redacted
+var sensitiveKeys = map[string]bool{
+	"password":     true,
+	"hub-password": true,
+	"auth-key":     true,
+	"ha1":          true,
+	"nonce":        true,
+	"session":      true,
+	"cookie":       true,
+}
+
+// sensitivePatterns match credentials embedded in text, such as JSON-RPC payloads, URL encoded form bodies and cookies
+var sensitivePatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`("(?:password|auth-key|ha1|nonce)"\s*:\s*)"(?:[^"\\]|\\.)*"`), `$1"` + redacted + `"`},
+	{regexp.MustCompile(`(\\"(?:password|auth-key|ha1|nonce)\\"\s*:\s*)\\"(?:[^"\\]|\\[^"])*\\"`), `$1\"` + redacted + `\"`},
+	{regexp.MustCompile(`(?i)(%22(?:password|auth-key|ha1|nonce)%22%3A)%22[^%]*%22`), `$1%22` + redacted + `%22`},
+	{regexp.MustCompile(`(?i)\b(session|password|auth-key|ha1)=[^;&\s]+`), `$1=` + redacted},
+}
+
+// ParseLevel returns the level with the given name, which is one of debug, info, warn or error
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("invalid log level %q. Must be debug, info, warn or error", level)
+}
+
+// New creates a logger that writes records at or above level to w in the given format. Credentials are
+// redacted from every message and attribute
+func New(w io.Writer, level string, format string) (*slog.Logger, error) {
+	logLevel, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &slog.HandlerOptions{
+		Level:       logLevel,
+		ReplaceAttr: redactAttr,
+	}
+
+	switch format {
+	case FormatLogfmt:
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q. Must be logfmt or json", format)
+}
+
+// Redact removes credentials from text
+func Redact(text string) string {
+	for _, p := range sensitivePatterns {
+		text = p.pattern.ReplaceAllString(text, p.replacement)
+	}
+	return text
+}
+
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, redacted)
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, Redact(attr.Value.String()))
+	case slog.KindAny:
+		// Errors and other values are logged as text, so they are redacted in the same way
+		text := fmt.Sprint(attr.Value.Any())
+		if redactedText := Redact(text); redactedText != text {
+			return slog.String(attr.Key, redactedText)
+		}
+	}
+	return attr
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+const secret = "s3cr3t-value"
+
+func TestRedaction(t *testing.T) {
+	for _, format := range []string{FormatLogfmt, FormatJSON} {
+		var buffer bytes.Buffer
+		logger, err := New(&buffer, "debug", format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		logger.Info("attributes", "password", secret, "auth-key", secret, "ha1", secret, "session", secret)
+		logger.Info("json", "body", `{"request":{"auth-key":"`+secret+`","nonce":"`+secret+`"}}`)
+		logger.Info("escaped json", "body", `{"value":"{\"ha1\":\"`+secret+`\",\"password\":\"`+secret+`\"}"}`)
+		logger.Info("form", "body", "req=%7B%22auth-key%22%3A%22"+secret+"%22%7D")
+		logger.Info("cookie", "header", "lang=en; session="+secret)
+		logger.Debug("error", "err", errors.New("login failed for password="+secret))
+
+		output := buffer.String()
+		if strings.Contains(output, secret) {
+			t.Fatalf("Expected credentials to be redacted from %s output:\n%s", format, output)
+		}
+		if count := strings.Count(output, redacted); count != 11 {
+			t.Fatalf("Expected 11 redacted values in %s output. Got %d:\n%s", format, count, output)
+		}
+	}
+}
+
+func TestRedactKeepsOtherValues(t *testing.T) {
+	text := `{"method":"getValue","xpath":"Device/DeviceInfo/UpTime","nonce":"abc"}`
+	expected := `{"method":"getValue","xpath":"Device/DeviceInfo/UpTime","nonce":"[REDACTED]"}`
+	if redactedText := Redact(text); redactedText != expected {
+		t.Fatalf("Expected %s. Got %s", expected, redactedText)
+	}
+}
+
+func TestLevel(t *testing.T) {
+	var buffer bytes.Buffer
+	logger, err := New(&buffer, "warn", FormatLogfmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("hidden")
+	logger.Warn("shown")
+
+	if output := buffer.String(); strings.Contains(output, "hidden") || !strings.Contains(output, "shown") {
+		t.Fatalf("Expected only the warning to be logged. Got %s", output)
+	}
+}
+
+func TestInvalidSettings(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "verbose", FormatLogfmt); err == nil {
+		t.Fatal("Expected an error for an invalid log level")
+	}
+
+	if _, err := New(&bytes.Buffer{}, "info", "xml"); err == nil {
+		t.Fatal("Expected an error for an invalid log format")
+	}
+
+	if level, err := ParseLevel("DEBUG"); err != nil || level != slog.LevelDebug {
+		t.Fatalf("Expected level names to be case insensitive. Got %s, %v", level, err)
+	}
+}
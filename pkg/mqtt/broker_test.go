@@ -0,0 +1,210 @@
+package mqtt
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+type brokerMessage struct {
+	topic    string
+	payload  string
+	qos      byte
+	retained bool
+}
+
+// broker runs an in-process mochi-mqtt broker, so that the publisher is tested against a complete MQTT
+// implementation. It records the messages that clients publish, along with the wills it publishes for them
+type broker struct {
+	mochi.HookBase
+
+	server   *mochi.Server
+	listener *listeners.TCP
+	username string
+	password string
+
+	mutex     sync.Mutex
+	connects  int
+	pings     int
+	messages  []brokerMessage
+	published chan struct{}
+}
+
+// newBroker starts a broker on a random local port. Clients must log in with username and password if
+// username is not empty
+func newBroker(t *testing.T, tlsConfig *tls.Config, username string, password string) *broker {
+	b := &broker{
+		server: mochi.New(&mochi.Options{
+			Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		}),
+		listener:  listeners.NewTCP("tcp", "127.0.0.1:0", &listeners.Config{TLSConfig: tlsConfig}),
+		username:  username,
+		password:  password,
+		published: make(chan struct{}, 1),
+	}
+
+	if err := b.server.AddHook(b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.server.AddListener(b.listener); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.server.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func (b *broker) address() string {
+	return b.listener.Address()
+}
+
+func (b *broker) close() {
+	//nolint:golint,errcheck
+	b.server.Close()
+}
+
+// dropConnections closes every client connection, as if the network had failed
+func (b *broker) dropConnections() {
+	for _, client := range b.server.Clients.GetAll() {
+		if !client.Net.Inline {
+			client.Stop(errors.New("connection dropped"))
+		}
+	}
+}
+
+// ID identifies the broker as a hook of the mochi-mqtt server
+func (b *broker) ID() string {
+	return "recorder"
+}
+
+// Provides returns whether the broker implements the given hook
+func (b *broker) Provides(hook byte) bool {
+	return bytes.Contains([]byte{
+		mochi.OnConnect,
+		mochi.OnConnectAuthenticate,
+		mochi.OnACLCheck,
+		mochi.OnSessionEstablished,
+		mochi.OnPacketRead,
+		mochi.OnPublished,
+		mochi.OnWillSent,
+	}, []byte{hook})
+}
+
+// OnConnect doubles the keep alive period that the broker allows. Paho checks the connection every half keep
+// alive period, so its pings can arrive up to one and a half periods apart, which is exactly the limit that
+// the broker enforces. The short keep alive periods used by the tests would otherwise be unreliable
+func (b *broker) OnConnect(client *mochi.Client, packet packets.Packet) error {
+	client.State.Keepalive *= 2
+	return nil
+}
+
+// OnConnectAuthenticate checks the credentials of a connecting client
+func (b *broker) OnConnectAuthenticate(client *mochi.Client, packet packets.Packet) bool {
+	return b.username == "" || (string(packet.Connect.Username) == b.username && string(packet.Connect.Password) == b.password)
+}
+
+// OnACLCheck allows every client to publish and subscribe to every topic
+func (b *broker) OnACLCheck(client *mochi.Client, topic string, write bool) bool {
+	return true
+}
+
+// OnSessionEstablished counts the successful connections
+func (b *broker) OnSessionEstablished(client *mochi.Client, packet packets.Packet) {
+	b.mutex.Lock()
+	b.connects++
+	b.mutex.Unlock()
+}
+
+// OnPacketRead counts the pings sent by clients
+func (b *broker) OnPacketRead(client *mochi.Client, packet packets.Packet) (packets.Packet, error) {
+	if packet.FixedHeader.Type == packets.Pingreq {
+		b.mutex.Lock()
+		b.pings++
+		b.mutex.Unlock()
+	}
+	return packet, nil
+}
+
+// OnPublished records a message published by a client
+func (b *broker) OnPublished(client *mochi.Client, packet packets.Packet) {
+	b.record(packet)
+}
+
+// OnWillSent records the will published for a client whose connection was lost without a DISCONNECT packet
+func (b *broker) OnWillSent(client *mochi.Client, packet packets.Packet) {
+	b.record(packet)
+}
+
+func (b *broker) record(packet packets.Packet) {
+	b.mutex.Lock()
+	b.messages = append(b.messages, brokerMessage{
+		topic:    packet.TopicName,
+		payload:  string(packet.Payload),
+		qos:      packet.FixedHeader.Qos,
+		retained: packet.FixedHeader.Retain,
+	})
+	b.mutex.Unlock()
+
+	select {
+	case b.published <- struct{}{}:
+	default:
+	}
+}
+
+// waitFor waits until a message has been published to topic with payload, or any payload if it is empty
+func (b *broker) waitFor(t *testing.T, topic string, payload string) brokerMessage {
+	timeout := time.After(5 * time.Second)
+	for {
+		b.mutex.Lock()
+		for _, message := range b.messages {
+			if message.topic == topic && (payload == "" || message.payload == payload) {
+				b.mutex.Unlock()
+				return message
+			}
+		}
+		b.mutex.Unlock()
+
+		select {
+		case <-b.published:
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatalf("Timed out waiting for message %q on topic %s", payload, topic)
+		}
+	}
+}
+
+// retainedMessage returns the message the broker retains for topic
+func (b *broker) retainedMessage(topic string) (brokerMessage, bool) {
+	for _, packet := range b.server.Topics.Messages(topic) {
+		return brokerMessage{topic: packet.TopicName, payload: string(packet.Payload), qos: packet.FixedHeader.Qos, retained: true}, true
+	}
+	return brokerMessage{}, false
+}
+
+func (b *broker) publishedMessages() []brokerMessage {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]brokerMessage(nil), b.messages...)
+}
+
+func (b *broker) connectCount() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.connects
+}
+
+func (b *broker) pingCount() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.pings
+}
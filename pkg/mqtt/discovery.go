@@ -0,0 +1,191 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Home Assistant components that entities are discovered as
+const (
+	componentSensor        = "sensor"
+	componentBinarySensor  = "binary_sensor"
+	componentDeviceTracker = "device_tracker"
+)
+
+type discoveryDevice struct {
+	Identifiers  []string   `json:"identifiers"`
+	Name         string     `json:"name"`
+	Manufacturer string     `json:"manufacturer,omitempty"`
+	Model        string     `json:"model,omitempty"`
+	SWVersion    string     `json:"sw_version,omitempty"`
+	Connections  [][]string `json:"connections,omitempty"`
+	ViaDevice    string     `json:"via_device,omitempty"`
+}
+
+// discoveryConfig is a Home Assistant MQTT discovery payload
+type discoveryConfig struct {
+	Name                string          `json:"name"`
+	UniqueID            string          `json:"unique_id"`
+	ObjectID            string          `json:"object_id"`
+	StateTopic          string          `json:"state_topic"`
+	AvailabilityTopic   string          `json:"availability_topic"`
+	UnitOfMeasurement   string          `json:"unit_of_measurement,omitempty"`
+	DeviceClass         string          `json:"device_class,omitempty"`
+	StateClass          string          `json:"state_class,omitempty"`
+	ValueTemplate       string          `json:"value_template,omitempty"`
+	JSONAttributesTopic string          `json:"json_attributes_topic,omitempty"`
+	PayloadOn           string          `json:"payload_on,omitempty"`
+	PayloadOff          string          `json:"payload_off,omitempty"`
+	PayloadHome         string          `json:"payload_home,omitempty"`
+	PayloadNotHome      string          `json:"payload_not_home,omitempty"`
+	SourceType          string          `json:"source_type,omitempty"`
+	Device              discoveryDevice `json:"device"`
+}
+
+// units maps metric name suffixes to Home Assistant units and device classes. Longer suffixes come first
+var units = []struct {
+	suffix      string
+	unit        string
+	deviceClass string
+}{
+	{"_bytes_total", "B", "data_size"},
+	{"_megabytes", "MB", "data_size"},
+	{"_seconds_total", "s", "duration"},
+	{"_seconds", "s", "duration"},
+	{"_mbps", "Mbit/s", "data_rate"},
+	{"_kbps", "kbit/s", "data_rate"},
+	{"_dbm", "dBm", "signal_strength"},
+	{"_db", "dB", ""},
+	{"_mhz", "MHz", "frequency"},
+	{"_percent", "%", ""},
+}
+
+// discoveryMessages returns the Home Assistant discovery messages for the entities of states. Metrics about
+// the Home Hub belong to one Home Assistant device, and each device connected to the Home Hub has its own
+func (p *Publisher) discoveryMessages(states []state) []message {
+	node := objectID(p.topicPrefix)
+	hub := discoveryDevice{
+		Identifiers:  []string{node},
+		Name:         "BT Home Hub",
+		Manufacturer: "BT",
+		Model:        "Home Hub",
+	}
+
+	for _, s := range states {
+		if s.name == "build_info" {
+			hub.SWVersion = s.labels["firmware"]
+		}
+	}
+
+	var messages []message
+	for _, s := range states {
+		device := hub
+		name := s.help
+		if s.macAddress != "" {
+			device = discoveryDevice{
+				Identifiers: []string{node + "_" + macID(s.macAddress)},
+				Name:        deviceName(s),
+				Connections: [][]string{{"mac", s.macAddress}},
+				ViaDevice:   node,
+			}
+		} else if summary := s.labelSummary(); summary != "" && s.kind != kindInfo {
+			name += " (" + summary + ")"
+		}
+
+		config := discoveryConfig{
+			Name:              name,
+			UniqueID:          node + "_" + s.id,
+			ObjectID:          node + "_" + s.id,
+			StateTopic:        s.topic,
+			AvailabilityTopic: p.availabilityTopic(),
+			Device:            device,
+		}
+
+		var component string
+		switch {
+		case s.macAddress != "" && s.name == "device_connected":
+			component = componentDeviceTracker
+			config.Name = device.Name
+			config.PayloadHome, config.PayloadNotHome, config.SourceType = "1", "0", "router"
+		case s.name == "up" || strings.HasSuffix(s.name, "_up") || strings.HasSuffix(s.name, "_enabled"):
+			component = componentBinarySensor
+			config.PayloadOn, config.PayloadOff = "1", "0"
+			if !strings.HasSuffix(s.name, "_enabled") {
+				config.DeviceClass = "connectivity"
+			}
+		case s.kind == kindInfo:
+			messages = append(messages, p.infoDiscoveryMessages(s, config)...)
+			continue
+		default:
+			component = componentSensor
+			p.describeSensor(s, &config)
+		}
+
+		messages = append(messages, p.discoveryMessage(component, node, s.id, config))
+	}
+	return messages
+}
+
+// infoDiscoveryMessages returns a sensor for each label of an info metric
+func (p *Publisher) infoDiscoveryMessages(s state, config discoveryConfig) []message {
+	var labels []string
+	for label := range s.labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	node := objectID(p.topicPrefix)
+	messages := make([]message, 0, len(labels))
+	for _, label := range labels {
+		id := s.id
+		labelConfig := config
+		if len(labels) > 1 {
+			id += "_" + objectID(label)
+			labelConfig.Name += " (" + label + ")"
+			labelConfig.UniqueID += "_" + objectID(label)
+			labelConfig.ObjectID += "_" + objectID(label)
+		}
+		labelConfig.ValueTemplate = "{{ value_json." + label + " }}"
+		labelConfig.JSONAttributesTopic = s.topic
+		messages = append(messages, p.discoveryMessage(componentSensor, node, id, labelConfig))
+	}
+	return messages
+}
+
+func (p *Publisher) describeSensor(s state, config *discoveryConfig) {
+	if s.kind == kindTimestamp {
+		config.DeviceClass = "timestamp"
+		return
+	}
+
+	for _, u := range units {
+		if strings.HasSuffix(s.name, u.suffix) {
+			config.UnitOfMeasurement, config.DeviceClass = u.unit, u.deviceClass
+			break
+		}
+	}
+
+	if s.kind == kindCounter {
+		config.StateClass = "total_increasing"
+	} else {
+		config.StateClass = "measurement"
+	}
+}
+
+func (p *Publisher) discoveryMessage(component string, node string, id string, config discoveryConfig) message {
+	//nolint:golint,errcheck
+	payload, _ := json.Marshal(config)
+	return message{
+		topic:    strings.Join([]string{p.discoveryPrefix, component, node, id, "config"}, "/"),
+		payload:  string(payload),
+		retained: true,
+	}
+}
+
+func deviceName(s state) string {
+	if name := s.labels[hostNameLabel]; name != "" {
+		return name
+	}
+	return s.macAddress
+}
@@ -0,0 +1,341 @@
+// Package mqtt publishes the metrics collected from the Home Hub to an MQTT broker, along with Home Assistant
+// discovery messages
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	defaultClientID        = "homehub-metrics-exporter"
+	defaultTopicPrefix     = "homehub"
+	defaultDiscoveryPrefix = "homeassistant"
+	defaultTimeout         = 10 * time.Second
+	defaultRetryInterval   = 10 * time.Second
+	defaultKeepAlive       = 30 * time.Second
+	// disconnectQuiesce is how many milliseconds the client is given to finish its work when disconnecting
+	disconnectQuiesce = 250
+
+	payloadOnline  = "online"
+	payloadOffline = "offline"
+)
+
+var errTimeout = errors.New("timed out waiting for the MQTT broker")
+
+// Publisher sends the metrics of every Home Hub poll to an MQTT broker. Messages are published in the
+// background, so that a slow broker does not delay scrapes
+type Publisher struct {
+	broker          string
+	clientID        string
+	username        string
+	password        string
+	tlsConfig       *tls.Config
+	topicPrefix     string
+	discoveryPrefix string
+	discovery       bool
+	qos             byte
+	retain          bool
+	timeout         time.Duration
+	retryInterval   time.Duration
+	keepAlive       time.Duration
+
+	ready chan struct{}
+	// running tracks the goroutine started by Start
+	running sync.WaitGroup
+
+	mutex   sync.Mutex
+	pending []*dto.MetricFamily
+
+	// discovered holds the payload last published to each discovery topic on the current connection
+	discovered map[string]string
+}
+
+// Option configures optional Publisher behaviour
+type Option func(*Publisher)
+
+// WithClientID sets the MQTT client identifier. Defaults to homehub-metrics-exporter
+func WithClientID(clientID string) Option {
+	return func(p *Publisher) {
+		p.clientID = clientID
+	}
+}
+
+// WithCredentials authenticates with the broker using username and password
+func WithCredentials(username string, password string) Option {
+	return func(p *Publisher) {
+		p.username = username
+		p.password = password
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used for ssl://, tls:// and mqtts:// brokers
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(p *Publisher) {
+		p.tlsConfig = tlsConfig
+	}
+}
+
+// WithTopicPrefix sets the prefix of the topics that metrics are published to. Defaults to homehub
+func WithTopicPrefix(prefix string) Option {
+	return func(p *Publisher) {
+		p.topicPrefix = prefix
+	}
+}
+
+// WithDiscovery enables or disables Home Assistant discovery messages, and sets the discovery topic prefix.
+// Discovery is enabled with the homeassistant prefix by default
+func WithDiscovery(enabled bool, prefix string) Option {
+	return func(p *Publisher) {
+		p.discovery = enabled
+		if prefix != "" {
+			p.discoveryPrefix = prefix
+		}
+	}
+}
+
+// WithQoS sets the quality of service level of published messages, which is 0 or 1. Defaults to 0
+func WithQoS(qos byte) Option {
+	return func(p *Publisher) {
+		p.qos = qos
+	}
+}
+
+// WithRetain makes the broker retain the latest state messages, so that subscribers receive them immediately.
+// Availability and discovery messages are always retained
+func WithRetain(retain bool) Option {
+	return func(p *Publisher) {
+		p.retain = retain
+	}
+}
+
+// WithTimeout sets how long to wait for the broker when connecting and publishing. Defaults to 10 seconds
+func WithTimeout(timeout time.Duration) Option {
+	return func(p *Publisher) {
+		if timeout > 0 {
+			p.timeout = timeout
+		}
+	}
+}
+
+// WithRetryInterval sets how long to wait before trying again when the broker cannot be connected to.
+// Defaults to 10 seconds
+func WithRetryInterval(interval time.Duration) Option {
+	return func(p *Publisher) {
+		p.retryInterval = interval
+	}
+}
+
+// New creates a Publisher for the broker URL, e.g. tcp://localhost:1883 or ssl://localhost:8883
+func New(broker string, opts ...Option) *Publisher {
+	p := &Publisher{
+		broker:          broker,
+		clientID:        defaultClientID,
+		topicPrefix:     defaultTopicPrefix,
+		discoveryPrefix: defaultDiscoveryPrefix,
+		discovery:       true,
+		timeout:         defaultTimeout,
+		retryInterval:   defaultRetryInterval,
+		keepAlive:       defaultKeepAlive,
+		ready:           make(chan struct{}, 1),
+		discovered:      make(map[string]string),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Start connects to the broker and publishes metrics until ctx is cancelled. The Home Hub is then marked as
+// offline and the connection is closed. If the connection is lost, the broker marks the Home Hub as offline
+// until the Publisher reconnects
+func (p *Publisher) Start(ctx context.Context) {
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		p.run(ctx)
+	}()
+}
+
+// Wait blocks until the Publisher started by Start has marked the Home Hub as offline and disconnected from the
+// broker. Another Publisher with the same client ID can then connect without the broker closing this connection
+func (p *Publisher) Wait() {
+	p.running.Wait()
+}
+
+// Publish queues the metrics of a poll for publishing. If the previous poll has not been published yet,
+// it is replaced
+func (p *Publisher) Publish(families []*dto.MetricFamily) {
+	p.mutex.Lock()
+	p.pending = families
+	p.mutex.Unlock()
+
+	select {
+	case p.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Publisher) run(ctx context.Context) {
+	connected := make(chan struct{}, 1)
+
+	options := paho.NewClientOptions().
+		AddBroker(p.broker).
+		SetClientID(p.clientID).
+		SetUsername(p.username).
+		SetPassword(p.password).
+		SetTLSConfig(p.tlsConfig).
+		SetCleanSession(true).
+		SetKeepAlive(p.keepAlive).
+		SetPingTimeout(p.timeout).
+		SetConnectTimeout(p.timeout).
+		SetWriteTimeout(p.timeout).
+		SetWill(p.availabilityTopic(), payloadOffline, p.qos, true).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(p.retryInterval).
+		SetOnConnectHandler(func(paho.Client) {
+			select {
+			case connected <- struct{}{}:
+			default:
+			}
+		}).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			slog.Warn("Lost connection to MQTT broker", "broker", p.broker, "err", err)
+		})
+
+	c := paho.NewClient(options)
+	if !p.connect(ctx, c) {
+		return
+	}
+	p.serve(ctx, c, connected)
+}
+
+// connect tries to connect to the broker until it succeeds or ctx is cancelled. Once connected, the client
+// reconnects by itself whenever the connection is lost
+func (p *Publisher) connect(ctx context.Context, c paho.Client) bool {
+	for {
+		token := c.Connect()
+		token.Wait()
+		err := token.Error()
+		if err == nil {
+			return true
+		}
+
+		slog.Warn("Unable to connect to MQTT broker", "broker", p.broker, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(p.retryInterval):
+		}
+	}
+}
+
+// serve publishes metrics until ctx is cancelled. The Home Hub is marked as online after every connection
+func (p *Publisher) serve(ctx context.Context, c paho.Client, connected <-chan struct{}) {
+	online := false
+	for {
+		select {
+		case <-ctx.Done():
+			if err := p.publish(c, []message{p.availability(payloadOffline)}); err != nil {
+				slog.Warn("Unable to publish offline status to MQTT broker", "broker", p.broker, "err", err)
+			}
+			c.Disconnect(disconnectQuiesce)
+			return
+		case <-connected:
+			slog.Info("Connected to MQTT broker", "broker", p.broker)
+			// Discovery messages are sent again, in case the broker lost its retained messages
+			p.discovered = make(map[string]string)
+			if err := p.publish(c, []message{p.availability(payloadOnline)}); err != nil {
+				slog.Warn("Unable to publish online status to MQTT broker", "broker", p.broker, "err", err)
+			}
+			online = true
+			p.publishPending(c)
+		case <-p.ready:
+			// Metrics are kept until the connection is back rather than being queued by the client
+			if online && c.IsConnectionOpen() {
+				p.publishPending(c)
+			}
+		}
+	}
+}
+
+// publishPending publishes the metrics of the latest poll, if they have not been published yet
+func (p *Publisher) publishPending(c paho.Client) {
+	p.mutex.Lock()
+	families := p.pending
+	p.pending = nil
+	p.mutex.Unlock()
+
+	if families == nil {
+		return
+	}
+
+	if err := p.publish(c, p.messages(families)); err != nil {
+		slog.Warn("Unable to publish metrics to MQTT broker", "broker", p.broker, "err", err)
+		// The discovery messages may not have arrived, so they are sent again with the next poll
+		p.discovered = make(map[string]string)
+	}
+}
+
+// publish sends messages and waits until they have been written, or acknowledged by the broker when the QoS is 1
+func (p *Publisher) publish(c paho.Client, messages []message) error {
+	tokens := make([]paho.Token, 0, len(messages))
+	for _, m := range messages {
+		tokens = append(tokens, c.Publish(m.topic, p.qos, m.retained, m.payload))
+	}
+
+	deadline := time.Now().Add(p.timeout)
+	for _, token := range tokens {
+		if !token.WaitTimeout(time.Until(deadline)) {
+			return errTimeout
+		}
+		if err := token.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// availability returns the retained message that tells subscribers whether the exporter is online
+func (p *Publisher) availability(payload string) message {
+	return message{topic: p.availabilityTopic(), payload: payload, retained: true}
+}
+
+func (p *Publisher) availabilityTopic() string {
+	return p.topicPrefix + "/status"
+}
+
+type message struct {
+	topic    string
+	payload  string
+	retained bool
+}
+
+// messages returns the state of every metric, preceded by any discovery messages that have changed
+func (p *Publisher) messages(families []*dto.MetricFamily) []message {
+	var messages []message
+
+	states := newStates(p.topicPrefix, families)
+	if p.discovery {
+		for _, discovery := range p.discoveryMessages(states) {
+			if p.discovered[discovery.topic] != discovery.payload {
+				p.discovered[discovery.topic] = discovery.payload
+				messages = append(messages, discovery)
+			}
+		}
+	}
+
+	for _, s := range states {
+		messages = append(messages, message{topic: s.topic, payload: s.payload, retained: p.retain})
+	}
+	return messages
+}
@@ -0,0 +1,343 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func createMetricFamilies(t *testing.T) []*dto.MetricFamily {
+	deviceLabels := []string{"host_name", "ip_address", "mac_address"}
+
+	uptime := prometheus.NewGauge(prometheus.GaugeOpts{Name: "bt_homehub_uptime_seconds", Help: "Uptime of the router"})
+	uptime.Set(3600)
+	up := prometheus.NewGauge(prometheus.GaugeOpts{Name: "bt_homehub_up", Help: "Whether the router is up"})
+	up.Set(1)
+	downloadBytes := prometheus.NewCounter(prometheus.CounterOpts{Name: "bt_homehub_download_bytes_total", Help: "Bytes downloaded from the internet"})
+	downloadBytes.Add(1024)
+	lastPoll := prometheus.NewGauge(prometheus.GaugeOpts{Name: "bt_homehub_last_successful_poll_timestamp_seconds", Help: "Unix timestamp of the last successful collection of metrics from the router"})
+	lastPoll.Set(1700000000.5)
+
+	deviceDownloaded := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "bt_homehub_device_downloaded_megabytes", Help: "Total megabytes downloaded by the device"}, deviceLabels)
+	deviceDownloaded.WithLabelValues("tablet", "192.168.1.64", "AA:BB:CC:DD:EE:F1").Set(600)
+	deviceConnected := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "bt_homehub_device_connected", Help: "Whether the device is connected to the router"}, deviceLabels)
+	deviceConnected.WithLabelValues("tablet", "192.168.1.64", "AA:BB:CC:DD:EE:F1").Set(1)
+
+	dslStatus := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "bt_homehub_dsl_line_status", Help: "Status of the DSL line"}, []string{"status"})
+	dslStatus.WithLabelValues("Up").Set(1)
+	wanInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "bt_homehub_wan_info", Help: "WAN connection addresses assigned by the ISP"}, []string{"ipv4_address", "ipv6_address"})
+	wanInfo.WithLabelValues("203.0.113.10", "").Set(1)
+	noiseMargin := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "bt_homehub_dsl_noise_margin_db", Help: "Signal to noise ratio margin of the DSL line"}, []string{"direction"})
+	noiseMargin.WithLabelValues("downstream").Set(6.5)
+	build := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "bt_homehub_build_info", Help: "Route build information"}, []string{"firmware"})
+	build.WithLabelValues("SG4B1000B540").Set(1)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(uptime, up, downloadBytes, lastPoll, deviceDownloaded, deviceConnected, dslStatus, wanInfo, noiseMargin, build)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return families
+}
+
+func TestStates(t *testing.T) {
+	payloads := make(map[string]string)
+	for _, s := range newStates("homehub", createMetricFamilies(t)) {
+		payloads[s.topic] = s.payload
+	}
+
+	expected := map[string]string{
+		"homehub/uptime_seconds":                           "3600",
+		"homehub/up":                                       "1",
+		"homehub/download_bytes_total":                     "1024",
+		"homehub/last_successful_poll_timestamp_seconds":   "2023-11-14T22:13:20Z",
+		"homehub/device/aabbccddeef1/downloaded_megabytes": "600",
+		"homehub/device/aabbccddeef1/connected":            "1",
+		"homehub/dsl_line_status":                          `{"status":"Up"}`,
+		"homehub/wan_info":                                 `{"ipv4_address":"203.0.113.10","ipv6_address":""}`,
+		"homehub/dsl_noise_margin_db/downstream":           "6.5",
+		"homehub/build_info":                               `{"firmware":"SG4B1000B540"}`,
+	}
+
+	if len(payloads) != len(expected) {
+		t.Fatalf("Expected %d states. Got %v", len(expected), payloads)
+	}
+
+	for topic, payload := range expected {
+		if payloads[topic] != payload {
+			t.Fatalf("Expected %s to be %q. Got %q", topic, payload, payloads[topic])
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	broker := newBroker(t, nil, "exporter", "secret")
+	defer broker.close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	publisher := New("tcp://"+broker.address(), WithCredentials("exporter", "secret"), WithRetain(true), WithQoS(1))
+	publisher.Start(ctx)
+	publisher.Publish(createMetricFamilies(t))
+
+	if status := broker.waitFor(t, "homehub/status", "online"); !status.retained {
+		t.Fatal("Expected online status to be retained")
+	}
+
+	state := broker.waitFor(t, "homehub/device/aabbccddeef1/downloaded_megabytes", "600")
+	if !state.retained || state.qos != 1 {
+		t.Fatalf("Expected a retained QoS 1 state message. Got %+v", state)
+	}
+
+	uptime := discoveryPayload(t, broker, "homeassistant/sensor/homehub/uptime_seconds/config")
+	if uptime.StateTopic != "homehub/uptime_seconds" || uptime.UnitOfMeasurement != "s" || uptime.DeviceClass != "duration" ||
+		uptime.StateClass != "measurement" || uptime.AvailabilityTopic != "homehub/status" || uptime.Device.SWVersion != "SG4B1000B540" {
+		t.Fatalf("Unexpected uptime discovery: %+v", uptime)
+	}
+
+	if download := discoveryPayload(t, broker, "homeassistant/sensor/homehub/download_bytes_total/config"); download.StateClass != "total_increasing" || download.UnitOfMeasurement != "B" {
+		t.Fatalf("Unexpected download discovery: %+v", download)
+	}
+
+	if up := discoveryPayload(t, broker, "homeassistant/binary_sensor/homehub/up/config"); up.PayloadOn != "1" || up.DeviceClass != "connectivity" {
+		t.Fatalf("Unexpected up discovery: %+v", up)
+	}
+
+	tracker := discoveryPayload(t, broker, "homeassistant/device_tracker/homehub/device_aabbccddeef1_connected/config")
+	if tracker.Name != "tablet" || tracker.StateTopic != "homehub/device/aabbccddeef1/connected" || tracker.PayloadHome != "1" ||
+		tracker.Device.ViaDevice != "homehub" || tracker.Device.Connections[0][1] != "aa:bb:cc:dd:ee:f1" {
+		t.Fatalf("Unexpected device tracker discovery: %+v", tracker)
+	}
+
+	if status := discoveryPayload(t, broker, "homeassistant/sensor/homehub/dsl_line_status/config"); status.ValueTemplate != "{{ value_json.status }}" {
+		t.Fatalf("Unexpected DSL status discovery: %+v", status)
+	}
+
+	if wan := discoveryPayload(t, broker, "homeassistant/sensor/homehub/wan_info_ipv4_address/config"); wan.Name != "WAN connection addresses assigned by the ISP (ipv4_address)" {
+		t.Fatalf("Unexpected WAN info discovery: %+v", wan)
+	}
+
+	if margin := discoveryPayload(t, broker, "homeassistant/sensor/homehub/dsl_noise_margin_db_downstream/config"); margin.Name != "Signal to noise ratio margin of the DSL line (downstream)" {
+		t.Fatalf("Unexpected noise margin discovery: %+v", margin)
+	}
+
+	// Unchanged discovery messages are only published once
+	count := len(broker.publishedMessages())
+	publisher.Publish(createMetricFamilies(t))
+	waitForMessageCount(t, broker, count+10)
+
+	for _, message := range broker.publishedMessages()[count:] {
+		if strings.HasPrefix(message.topic, "homeassistant/") {
+			t.Fatalf("Unexpected discovery message %s", message.topic)
+		}
+	}
+
+	cancel()
+	if status := broker.waitFor(t, "homehub/status", "offline"); !status.retained {
+		t.Fatal("Expected offline status to be retained")
+	}
+
+	// The last will is not published after a clean disconnect
+	time.Sleep(50 * time.Millisecond)
+	offline := 0
+	for _, message := range broker.publishedMessages() {
+		if message.payload == "offline" {
+			offline++
+		}
+	}
+	if offline != 1 {
+		t.Fatalf("Expected 1 offline message. Got %d", offline)
+	}
+}
+
+func TestLastWill(t *testing.T) {
+	broker := newBroker(t, nil, "", "")
+	defer broker.close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	publisher := New("tcp://"+broker.address(), WithDiscovery(false, ""), WithRetryInterval(100*time.Millisecond))
+	publisher.Start(ctx)
+	broker.waitFor(t, "homehub/status", "online")
+
+	broker.dropConnections()
+	if will := broker.waitFor(t, "homehub/status", "offline"); !will.retained {
+		t.Fatal("Expected last will to be retained")
+	}
+
+	// The client reconnects and marks the Home Hub as online again
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if status, _ := broker.retainedMessage("homehub/status"); status.payload == "online" && broker.connectCount() == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the client to reconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	publisher.Publish(createMetricFamilies(t))
+	broker.waitFor(t, "homehub/uptime_seconds", "3600")
+
+	for _, message := range broker.publishedMessages() {
+		if strings.HasPrefix(message.topic, "homeassistant/") {
+			t.Fatalf("Unexpected discovery message %s", message.topic)
+		}
+	}
+}
+
+func TestRestart(t *testing.T) {
+	broker := newBroker(t, nil, "", "")
+	defer broker.close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	previous := New("tcp://"+broker.address(), WithDiscovery(false, ""))
+	previous.Start(ctx)
+	broker.waitFor(t, "homehub/status", "online")
+
+	// A reloaded configuration replaces the publisher with another that uses the same client ID
+	cancel()
+	previous.Wait()
+	broker.waitFor(t, "homehub/status", "offline")
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	publisher := New("tcp://"+broker.address(), WithDiscovery(false, ""))
+	publisher.Start(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for broker.connectCount() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the new publisher to connect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	publisher.Publish(createMetricFamilies(t))
+	broker.waitFor(t, "homehub/uptime_seconds", "3600")
+
+	messages := broker.publishedMessages()
+	if last := messages[len(messages)-1]; last.topic == "homehub/status" {
+		t.Fatalf("Expected the online status to be published once per connection. Got %+v", messages)
+	}
+
+	if status, _ := broker.retainedMessage("homehub/status"); status.payload != "online" {
+		t.Fatalf("Expected the Home Hub to stay online. Got %+v", status)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	broker := newBroker(t, nil, "", "")
+	defer broker.close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A ping that is not answered within the timeout makes the client reconnect
+	publisher := New("tcp://"+broker.address(), WithDiscovery(false, ""), WithTimeout(time.Second))
+	publisher.keepAlive = time.Second
+	publisher.Start(ctx)
+	broker.waitFor(t, "homehub/status", "online")
+
+	time.Sleep(3 * time.Second)
+	if count := broker.pingCount(); count < 2 {
+		t.Fatalf("Expected the idle connection to be kept alive with pings. Got %d pings", count)
+	}
+
+	if count := broker.connectCount(); count != 1 {
+		t.Fatalf("Expected the connection to stay open. Got %d connections", count)
+	}
+
+	publisher.Publish(createMetricFamilies(t))
+	broker.waitFor(t, "homehub/uptime_seconds", "3600")
+
+	for _, message := range broker.publishedMessages() {
+		if message.payload == "offline" {
+			t.Fatal("Unexpected last will")
+		}
+	}
+}
+
+func TestTLS(t *testing.T) {
+	// Borrow the certificate of a TLS test server, which is valid for 127.0.0.1
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	serverTLS := server.TLS.Clone()
+	certificate := server.Certificate()
+	server.Close()
+
+	broker := newBroker(t, serverTLS, "", "")
+	defer broker.close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+
+	publisher := New("ssl://"+broker.address(), WithTLSConfig(&tls.Config{RootCAs: roots}), WithTopicPrefix("home/hub"))
+	publisher.Start(ctx)
+	publisher.Publish(createMetricFamilies(t))
+
+	broker.waitFor(t, "home/hub/status", "online")
+	broker.waitFor(t, "home/hub/uptime_seconds", "3600")
+	discoveryPayload(t, broker, "homeassistant/sensor/home_hub/uptime_seconds/config")
+}
+
+func TestInvalidCredentials(t *testing.T) {
+	broker := newBroker(t, nil, "exporter", "secret")
+	defer broker.close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	publisher := New("tcp://"+broker.address(), WithCredentials("exporter", "wrong"), WithRetryInterval(10*time.Millisecond))
+	publisher.Start(ctx)
+	publisher.Publish(createMetricFamilies(t))
+
+	time.Sleep(100 * time.Millisecond)
+	if count := broker.connectCount(); count != 0 {
+		t.Fatalf("Expected no successful connections. Got %d", count)
+	}
+
+	if messages := broker.publishedMessages(); len(messages) != 0 {
+		t.Fatalf("Expected no messages. Got %+v", messages)
+	}
+}
+
+func discoveryPayload(t *testing.T, broker *broker, topic string) discoveryConfig {
+	message := broker.waitFor(t, topic, "")
+	if !message.retained {
+		t.Fatalf("Expected discovery message %s to be retained", topic)
+	}
+
+	var config discoveryConfig
+	if err := json.Unmarshal([]byte(message.payload), &config); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func waitForMessageCount(t *testing.T, broker *broker, count int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for len(broker.publishedMessages()) < count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d messages", count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
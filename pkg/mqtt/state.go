@@ -0,0 +1,171 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	metricPrefix    = "bt_homehub_"
+	macAddressLabel = "mac_address"
+	hostNameLabel   = "host_name"
+)
+
+// state is the value of a metric, published to its own topic
+type state struct {
+	topic   string
+	payload string
+	// id is the topic without the prefix, made safe for use as a Home Assistant object ID
+	id string
+	// name is the metric name without the bt_homehub_ prefix
+	name string
+	help string
+	// kind describes how Home Assistant should present the value
+	kind   kind
+	labels map[string]string
+	// macAddress is set for metrics about a device connected to the Home Hub
+	macAddress string
+}
+
+type kind int
+
+const (
+	kindGauge kind = iota
+	kindCounter
+	kindTimestamp
+	// kindInfo metrics report a value in their labels, which are published as a JSON object
+	kindInfo
+)
+
+// newStates returns the states of the metrics in families. Metrics about a device are published to
+// <prefix>/device/<mac>/<name>, other metrics to <prefix>/<name> followed by the values of any labels
+func newStates(prefix string, families []*dto.MetricFamily) []state {
+	var states []state
+	for _, family := range families {
+		name := strings.TrimPrefix(family.GetName(), metricPrefix)
+
+		for _, metric := range family.GetMetric() {
+			s := state{
+				name:   name,
+				help:   family.GetHelp(),
+				kind:   metricKind(name, family.GetType()),
+				labels: make(map[string]string, len(metric.GetLabel())),
+			}
+
+			var labelValues []string
+			for _, label := range metric.GetLabel() {
+				s.labels[label.GetName()] = label.GetValue()
+				labelValues = append(labelValues, topicLevel(label.GetValue()))
+			}
+
+			value, ok := metricValue(metric)
+			if !ok {
+				continue
+			}
+
+			switch {
+			case s.labels[macAddressLabel] != "":
+				s.macAddress = strings.ToLower(s.labels[macAddressLabel])
+				s.topic = strings.Join([]string{prefix, "device", macID(s.macAddress), strings.TrimPrefix(name, "device_")}, "/")
+			case s.kind == kindInfo || len(labelValues) == 0:
+				s.topic = prefix + "/" + name
+			default:
+				s.topic = prefix + "/" + name + "/" + strings.Join(labelValues, "/")
+			}
+
+			s.id = objectID(strings.TrimPrefix(s.topic, prefix+"/"))
+			s.payload = formatValue(s.kind, value, s.labels)
+			states = append(states, s)
+		}
+	}
+	return states
+}
+
+func metricKind(name string, metricType dto.MetricType) kind {
+	switch {
+	case strings.HasSuffix(name, "_timestamp_seconds"):
+		return kindTimestamp
+	case metricType == dto.MetricType_GAUGE &&
+		(strings.HasSuffix(name, "_info") || strings.HasSuffix(name, "_status") || strings.HasSuffix(name, "_error")):
+		return kindInfo
+	case metricType == dto.MetricType_COUNTER:
+		return kindCounter
+	}
+	return kindGauge
+}
+
+func metricValue(metric *dto.Metric) (float64, bool) {
+	var value float64
+	switch {
+	case metric.Gauge != nil:
+		value = metric.Gauge.GetValue()
+	case metric.Counter != nil:
+		value = metric.Counter.GetValue()
+	case metric.Untyped != nil:
+		value = metric.Untyped.GetValue()
+	default:
+		return 0, false
+	}
+	return value, !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+
+func formatValue(k kind, value float64, labels map[string]string) string {
+	switch k {
+	case kindTimestamp:
+		seconds, fraction := math.Modf(value)
+		return time.Unix(int64(seconds), int64(fraction*1e9)).UTC().Format(time.RFC3339)
+	case kindInfo:
+		//nolint:golint,errcheck
+		payload, _ := json.Marshal(labels)
+		return string(payload)
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// topicLevel replaces characters that have a special meaning in MQTT topics
+func topicLevel(value string) string {
+	if value == "" {
+		return "_"
+	}
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_", " ", "_").Replace(value)
+}
+
+// macID returns the MAC address without separators, for use in topics and identifiers
+func macID(macAddress string) string {
+	return strings.ReplaceAll(strings.ToLower(macAddress), ":", "")
+}
+
+// objectID replaces the characters that Home Assistant does not allow in object IDs
+func objectID(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, id)
+}
+
+// labelSummary describes the labels of a metric that distinguish it from other metrics with the same name
+func (s state) labelSummary() string {
+	var names []string
+	for name := range s.labels {
+		switch name {
+		case macAddressLabel, hostNameLabel, "ip_address":
+		default:
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var values []string
+	for _, name := range names {
+		values = append(values, s.labels[name])
+	}
+	return strings.Join(values, ", ")
+}
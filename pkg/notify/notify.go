@@ -0,0 +1,184 @@
+// Package notify delivers events about devices joining and leaving the Home Hub network to webhooks
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of device event
+const (
+	EventDeviceJoined  = "device_joined"
+	EventDeviceLeft    = "device_left"
+	EventDeviceChanged = "device_changed"
+)
+
+const (
+	defaultDedupWindow  = 5 * time.Minute
+	defaultRetryBackoff = time.Second
+	queueSize           = 100
+)
+
+// Event is a change to a device on the Home Hub network
+type Event struct {
+	Type              string    `json:"type"`
+	Time              time.Time `json:"time"`
+	MACAddress        string    `json:"mac_address"`
+	HostName          string    `json:"host_name"`
+	IPAddress         string    `json:"ip_address"`
+	PreviousHostName  string    `json:"previous_host_name,omitempty"`
+	PreviousIPAddress string    `json:"previous_ip_address,omitempty"`
+}
+
+// Title returns a short summary of the event
+func (e Event) Title() string {
+	switch e.Type {
+	case EventDeviceJoined:
+		return "New device on the network"
+	case EventDeviceLeft:
+		return "Device left the network"
+	case EventDeviceChanged:
+		return "Device changed"
+	}
+	return e.Type
+}
+
+// Message returns a description of the event
+func (e Event) Message() string {
+	switch e.Type {
+	case EventDeviceJoined:
+		return fmt.Sprintf("New device %s joined the network", describeDevice(e.HostName, e.IPAddress, e.MACAddress))
+	case EventDeviceLeft:
+		return fmt.Sprintf("Device %s left the network", describeDevice(e.HostName, e.IPAddress, e.MACAddress))
+	case EventDeviceChanged:
+		return fmt.Sprintf("Device %s changed from %s to %s", e.MACAddress,
+			describeDevice(e.PreviousHostName, e.PreviousIPAddress, ""), describeDevice(e.HostName, e.IPAddress, ""))
+	}
+	return fmt.Sprintf("%s %s", e.Type, e.MACAddress)
+}
+
+// key identifies repeated events for deduplication
+func (e Event) key() string {
+	return strings.Join([]string{e.Type, e.MACAddress, e.HostName, e.IPAddress}, "|")
+}
+
+func describeDevice(hostName string, ipAddress string, macAddress string) string {
+	var details []string
+	for _, detail := range []string{ipAddress, macAddress} {
+		if detail != "" {
+			details = append(details, detail)
+		}
+	}
+
+	if hostName == "" {
+		hostName = "unknown"
+	}
+
+	if len(details) == 0 {
+		return hostName
+	}
+	return fmt.Sprintf("%s (%s)", hostName, strings.Join(details, ", "))
+}
+
+// Notifier sends device events to webhooks. Events are queued and delivered in the background, so that a slow
+// webhook does not delay scrapes
+type Notifier struct {
+	webhooks     []*webhookSender
+	allowlist    map[string]bool
+	dedupWindow  time.Duration
+	retryBackoff time.Duration
+
+	mutex sync.Mutex
+	sent  map[string]time.Time
+}
+
+// Option configures optional Notifier behaviour
+type Option func(*Notifier)
+
+// WithAllowlist suppresses all events for the given upper case MAC addresses
+func WithAllowlist(macAddresses map[string]bool) Option {
+	return func(n *Notifier) {
+		n.allowlist = macAddresses
+	}
+}
+
+// WithDedupWindow suppresses an event if the same event was sent for the device within window. Defaults to 5 minutes
+func WithDedupWindow(window time.Duration) Option {
+	return func(n *Notifier) {
+		n.dedupWindow = window
+	}
+}
+
+// WithRetryBackoff sets the delay before the first retry of a failed delivery. The delay doubles on every retry.
+// Defaults to 1 second
+func WithRetryBackoff(backoff time.Duration) Option {
+	return func(n *Notifier) {
+		n.retryBackoff = backoff
+	}
+}
+
+// New creates a Notifier that sends events to webhooks
+func New(webhooks []Webhook, opts ...Option) *Notifier {
+	n := &Notifier{
+		dedupWindow:  defaultDedupWindow,
+		retryBackoff: defaultRetryBackoff,
+		sent:         make(map[string]time.Time),
+	}
+
+	for _, opt := range opts {
+		opt(n)
+	}
+
+	for _, webhook := range webhooks {
+		n.webhooks = append(n.webhooks, newWebhookSender(webhook, n.retryBackoff))
+	}
+	return n
+}
+
+// Start delivers queued events until ctx is cancelled
+func (n *Notifier) Start(ctx context.Context) {
+	for _, webhook := range n.webhooks {
+		go webhook.run(ctx)
+	}
+}
+
+// Notify queues events for delivery. Events for allowlisted devices and events that were already sent
+// within the deduplication window are dropped
+func (n *Notifier) Notify(events ...Event) {
+	for _, event := range events {
+		if n.allowlist[strings.ToUpper(event.MACAddress)] {
+			continue
+		}
+
+		if n.duplicate(event) {
+			slog.Debug("Suppressing duplicate device event", "type", event.Type, "mac_address", event.MACAddress)
+			continue
+		}
+
+		for _, webhook := range n.webhooks {
+			webhook.enqueue(event)
+		}
+	}
+}
+
+func (n *Notifier) duplicate(event Event) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for key, sent := range n.sent {
+		if event.Time.Sub(sent) >= n.dedupWindow {
+			delete(n.sent, key)
+		}
+	}
+
+	key := event.key()
+	if _, ok := n.sent[key]; ok {
+		return true
+	}
+	n.sent[key] = event.Time
+	return false
+}
@@ -0,0 +1,238 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type receivedRequest struct {
+	header http.Header
+	body   string
+}
+
+type webhookServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []receivedRequest
+	statuses []int
+	received chan struct{}
+}
+
+// newWebhookServer returns a server that replies with statuses in turn, and then with 200
+func newWebhookServer(statuses ...int) *webhookServer {
+	server := &webhookServer{statuses: statuses, received: make(chan struct{}, 100)}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		server.mutex.Lock()
+		server.requests = append(server.requests, receivedRequest{header: r.Header, body: string(body)})
+		status := http.StatusOK
+		if len(server.statuses) > 0 {
+			status, server.statuses = server.statuses[0], server.statuses[1:]
+		}
+		server.mutex.Unlock()
+
+		w.WriteHeader(status)
+		server.received <- struct{}{}
+	}))
+	return server
+}
+
+func (s *webhookServer) waitForRequests(t *testing.T, count int) []receivedRequest {
+	for i := 0; i < count; i++ {
+		select {
+		case <-s.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for request %d", i+1)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]receivedRequest(nil), s.requests...)
+}
+
+func (s *webhookServer) requestCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.requests)
+}
+
+func newEvent(eventType string, macAddress string) Event {
+	return Event{
+		Type:       eventType,
+		Time:       time.Now(),
+		MACAddress: macAddress,
+		HostName:   "tablet",
+		IPAddress:  "192.168.1.64",
+	}
+}
+
+func TestFormats(t *testing.T) {
+	server := newWebhookServer()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	notifier := New([]Webhook{
+		{URL: server.URL + "/json"},
+		{URL: server.URL + "/slack", Format: FormatSlack},
+		{URL: server.URL + "/ntfy", Format: FormatNtfy},
+	})
+	notifier.Start(ctx)
+	notifier.Notify(newEvent(EventDeviceJoined, "AA:BB:CC:DD:EE:01"))
+
+	var jsonEvent Event
+	var slackMessage map[string]string
+	var ntfy receivedRequest
+
+	for _, request := range server.waitForRequests(t, 3) {
+		switch {
+		case strings.HasPrefix(request.body, `{"type"`):
+			if err := json.Unmarshal([]byte(request.body), &jsonEvent); err != nil {
+				t.Fatal(err)
+			}
+		case strings.HasPrefix(request.body, `{"text"`):
+			if err := json.Unmarshal([]byte(request.body), &slackMessage); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			ntfy = request
+		}
+	}
+
+	if jsonEvent.Type != EventDeviceJoined || jsonEvent.MACAddress != "AA:BB:CC:DD:EE:01" || jsonEvent.HostName != "tablet" {
+		t.Fatalf("Unexpected JSON event: %+v", jsonEvent)
+	}
+
+	expectedMessage := "New device tablet (192.168.1.64, AA:BB:CC:DD:EE:01) joined the network"
+	if slackMessage["text"] != expectedMessage {
+		t.Fatalf("Unexpected Slack message: %q", slackMessage["text"])
+	}
+
+	if ntfy.body != expectedMessage || ntfy.header.Get("Title") != "New device on the network" || ntfy.header.Get("Tags") != "warning" {
+		t.Fatalf("Unexpected ntfy request: %q %v", ntfy.body, ntfy.header)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	server := newWebhookServer(http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK, http.StatusBadRequest)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	notifier := New([]Webhook{{URL: server.URL}}, WithRetryBackoff(time.Millisecond))
+	notifier.Start(ctx)
+
+	// The first event is delivered on the third attempt, after which the webhook rejects the second event
+	notifier.Notify(newEvent(EventDeviceJoined, "AA:BB:CC:DD:EE:01"), newEvent(EventDeviceJoined, "AA:BB:CC:DD:EE:02"))
+	server.waitForRequests(t, 4)
+
+	// Client errors are not retried
+	time.Sleep(50 * time.Millisecond)
+	if count := server.requestCount(); count != 4 {
+		t.Fatalf("Expected 4 requests. Got %d", count)
+	}
+}
+
+func TestNoRetries(t *testing.T) {
+	server := newWebhookServer(http.StatusServiceUnavailable, http.StatusOK)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	maxRetries := 0
+	notifier := New([]Webhook{{URL: server.URL, MaxRetries: &maxRetries}}, WithRetryBackoff(time.Millisecond))
+	notifier.Start(ctx)
+
+	notifier.Notify(newEvent(EventDeviceJoined, "AA:BB:CC:DD:EE:01"))
+	server.waitForRequests(t, 1)
+
+	time.Sleep(50 * time.Millisecond)
+	if count := server.requestCount(); count != 1 {
+		t.Fatalf("Expected exactly 1 attempt. Got %d", count)
+	}
+}
+
+func TestSuppressedEvents(t *testing.T) {
+	server := newWebhookServer()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	notifier := New([]Webhook{{URL: server.URL, Events: []string{EventDeviceJoined, EventDeviceLeft}}},
+		WithAllowlist(map[string]bool{"AA:BB:CC:DD:EE:01": true}),
+		WithDedupWindow(time.Minute),
+	)
+	notifier.Start(ctx)
+
+	left := newEvent(EventDeviceLeft, "AA:BB:CC:DD:EE:04")
+	changed := newEvent(EventDeviceChanged, "AA:BB:CC:DD:EE:03")
+	later := newEvent(EventDeviceLeft, "aa:bb:cc:dd:ee:04")
+	later.Time = left.Time.Add(2 * time.Minute)
+
+	notifier.Notify(
+		// Allowlisted devices joining, leaving or changing are suppressed
+		newEvent(EventDeviceJoined, "aa:bb:cc:dd:ee:01"),
+		newEvent(EventDeviceLeft, "AA:BB:CC:DD:EE:01"),
+		left,
+		newEvent(EventDeviceLeft, "AA:BB:CC:DD:EE:04"),
+		// The webhook does not receive change events
+		changed,
+		newEvent(EventDeviceJoined, "AA:BB:CC:DD:EE:02"),
+		// Repeated events are sent again after the deduplication window
+		later,
+	)
+
+	requests := server.waitForRequests(t, 3)
+	time.Sleep(50 * time.Millisecond)
+	if count := server.requestCount(); count != 3 {
+		t.Fatalf("Expected 3 requests. Got %d", count)
+	}
+
+	var types []string
+	for _, request := range requests {
+		var event Event
+		if err := json.Unmarshal([]byte(request.body), &event); err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, event.Type+" "+strings.ToUpper(event.MACAddress))
+	}
+
+	expected := "device_left AA:BB:CC:DD:EE:04,device_joined AA:BB:CC:DD:EE:02,device_left AA:BB:CC:DD:EE:04"
+	if strings.Join(types, ",") != expected {
+		t.Fatalf("Expected %s. Got %s", expected, strings.Join(types, ","))
+	}
+}
+
+func TestMessages(t *testing.T) {
+	changed := Event{
+		Type:              EventDeviceChanged,
+		MACAddress:        "AA:BB:CC:DD:EE:01",
+		HostName:          "tablet",
+		IPAddress:         "192.168.1.70",
+		PreviousHostName:  "tablet",
+		PreviousIPAddress: "192.168.1.64",
+	}
+
+	expected := "Device AA:BB:CC:DD:EE:01 changed from tablet (192.168.1.64) to tablet (192.168.1.70)"
+	if changed.Message() != expected {
+		t.Fatalf("Expected %q. Got %q", expected, changed.Message())
+	}
+
+	left := Event{Type: EventDeviceLeft, MACAddress: "AA:BB:CC:DD:EE:01"}
+	if left.Message() != "Device unknown (AA:BB:CC:DD:EE:01) left the network" {
+		t.Fatalf("Unexpected message: %q", left.Message())
+	}
+}
@@ -0,0 +1,192 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Payload formats supported by webhooks
+const (
+	FormatJSON  = "json"
+	FormatSlack = "slack"
+	FormatNtfy  = "ntfy"
+)
+
+const (
+	defaultTimeout    = 10 * time.Second
+	defaultMaxRetries = 3
+)
+
+// Webhook is an HTTP endpoint that events are posted to
+type Webhook struct {
+	URL string
+	// Format is FormatJSON, FormatSlack or FormatNtfy. Defaults to FormatJSON
+	Format string
+	// Events are the event types sent to the webhook. All events are sent if empty
+	Events []string
+	// Timeout of each delivery attempt. Defaults to 10 seconds
+	Timeout time.Duration
+	// MaxRetries is how many times a failed delivery is retried. Defaults to 3 when nil
+	MaxRetries *int
+}
+
+type webhookSender struct {
+	webhook    Webhook
+	maxRetries int
+	events     map[string]bool
+	backoff    time.Duration
+	client     *http.Client
+	queue      chan Event
+}
+
+func newWebhookSender(webhook Webhook, backoff time.Duration) *webhookSender {
+	if webhook.Format == "" {
+		webhook.Format = FormatJSON
+	}
+	if webhook.Timeout <= 0 {
+		webhook.Timeout = defaultTimeout
+	}
+	maxRetries := defaultMaxRetries
+	if webhook.MaxRetries != nil {
+		maxRetries = *webhook.MaxRetries
+	}
+
+	var events map[string]bool
+	if len(webhook.Events) > 0 {
+		events = make(map[string]bool, len(webhook.Events))
+		for _, event := range webhook.Events {
+			events[event] = true
+		}
+	}
+
+	return &webhookSender{
+		webhook:    webhook,
+		maxRetries: maxRetries,
+		events:     events,
+		backoff:    backoff,
+		client:     &http.Client{Timeout: webhook.Timeout},
+		queue:      make(chan Event, queueSize),
+	}
+}
+
+func (w *webhookSender) enqueue(event Event) {
+	if w.events != nil && !w.events[event.Type] {
+		return
+	}
+
+	select {
+	case w.queue <- event:
+	default:
+		slog.Warn("Dropping device event because the webhook queue is full", "url", w.webhook.URL, "type", event.Type, "mac_address", event.MACAddress)
+	}
+}
+
+func (w *webhookSender) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-w.queue:
+			if err := w.deliver(ctx, event); err != nil {
+				slog.WarnContext(ctx, "Unable to deliver device event", "url", w.webhook.URL, "type", event.Type, "mac_address", event.MACAddress, "err", err)
+			}
+		}
+	}
+}
+
+// deliver posts the event, retrying with exponential backoff on network errors, 429 and 5xx responses
+func (w *webhookSender) deliver(ctx context.Context, event Event) error {
+	backoff := w.backoff
+
+	var err error
+	for attempt := 0; attempt <= w.maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		var retry bool
+		retry, err = w.post(ctx, event)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// post sends the event once and reports whether a failure is worth retrying
+func (w *webhookSender) post(ctx context.Context, event Event) (bool, error) {
+	request, err := w.newRequest(ctx, event)
+	if err != nil {
+		return false, err
+	}
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+
+	//nolint:golint,errcheck
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+	return retry, fmt.Errorf("webhook returned HTTP status %d", response.StatusCode)
+}
+
+func (w *webhookSender) newRequest(ctx context.Context, event Event) (*http.Request, error) {
+	var (
+		body        []byte
+		contentType = "application/json"
+		err         error
+	)
+
+	switch w.webhook.Format {
+	case FormatSlack:
+		body, err = json.Marshal(map[string]string{"text": event.Message()})
+	case FormatNtfy:
+		body, contentType = []byte(event.Message()), "text/plain; charset=utf-8"
+	default:
+		body, err = json.Marshal(event)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, w.webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", contentType)
+
+	if w.webhook.Format == FormatNtfy {
+		request.Header.Set("Title", event.Title())
+		request.Header.Set("Tags", ntfyTags[event.Type])
+		if event.Type == EventDeviceJoined {
+			request.Header.Set("Priority", "high")
+		}
+	}
+	return request, nil
+}
+
+// ntfyTags are shown as emojis by ntfy clients
+var ntfyTags = map[string]string{
+	EventDeviceJoined:  "warning",
+	EventDeviceLeft:    "wave",
+	EventDeviceChanged: "pencil2",
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/config"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/exporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// targetKeepAliveInterval is how often a target that is not polled reads a value from the Home Hub, so that its
+// session stays logged in between probes
+const targetKeepAliveInterval = time.Minute
+
+// probeTarget is a Home Hub listed in the targets section of the configuration file. Its client and exporter are
+// created when the target is first needed and are then reused, so that each target keeps its own logged in session
+type probeTarget struct {
+	name    string
+	hub     config.HubConfig
+	cfg     *config.Config
+	options []client.Option
+
+	mutex    sync.Mutex
+	exporter *exporter.Exporter
+	login    *targetLogin
+	cancel   context.CancelFunc
+	stopped  bool
+}
+
+// targetLogin is a login to a target that is in progress. done is closed once it has finished
+type targetLogin struct {
+	done     chan struct{}
+	exporter *exporter.Exporter
+	err      error
+}
+
+func newProbeTargets(cfg *config.Config, options []client.Option) map[string]*probeTarget {
+	targets := make(map[string]*probeTarget)
+	for i := range cfg.Targets {
+		target := &cfg.Targets[i]
+		targets[target.Name] = &probeTarget{
+			name:    target.Name,
+			hub:     target.Hub(cfg.Hub),
+			cfg:     cfg,
+			options: options,
+		}
+	}
+	return targets
+}
+
+// get returns the exporter for the target, logging in to the Home Hub if this has not been done yet. The login
+// is shared by concurrent callers and does not use ctx, so a probe that is cancelled does not fail the others.
+// ctx only limits how long the caller waits for it
+func (t *probeTarget) get(ctx context.Context) (*exporter.Exporter, error) {
+	t.mutex.Lock()
+	if t.stopped {
+		t.mutex.Unlock()
+		return nil, fmt.Errorf("target %s was removed by a configuration reload", t.name)
+	}
+
+	if t.exporter != nil {
+		e := t.exporter
+		t.mutex.Unlock()
+		return e, nil
+	}
+
+	login := t.login
+	if login == nil {
+		login = &targetLogin{done: make(chan struct{})}
+		t.login = login
+		go t.start(login)
+	}
+	t.mutex.Unlock()
+
+	select {
+	case <-login.done:
+		return login.exporter, login.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// start logs in to the target and starts its exporter. A failed login is retried by the next call to get
+func (t *probeTarget) start(login *targetLogin) {
+	defer close(login.done)
+
+	metrics := client.NewMetrics()
+	homehub, err := newHubClient(t.hub, append(append([]client.Option{}, t.options...), client.WithMetrics(metrics))...)
+	if err == nil {
+		if response := homehub.Login(context.Background()); response.Error != nil {
+			err = fmt.Errorf("Home Hub login failed: %s", response.Error)
+		}
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.login = nil
+	if err != nil {
+		login.err = err
+		return
+	}
+
+	if t.stopped {
+		login.err = fmt.Errorf("target %s was removed by a configuration reload", t.name)
+		return
+	}
+
+	// Device bandwidth totals and presence of targets are only kept in memory, since the state files belong to the
+	// top level configuration
+	e := newExporter(homehub, t.cfg, "", exporter.WithClientMetrics(metrics))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.Start(ctx)
+	if t.cfg.Polling.Interval <= 0 {
+		go t.keepAlive(ctx, homehub)
+	}
+
+	t.exporter, t.cancel = e, cancel
+	login.exporter = e
+}
+
+// keepAlive reads a value from the Home Hub periodically until ctx is cancelled, so that the session of a target
+// that is not polled stays logged in between probes. The client logs in again by itself if the session has expired
+func (t *probeTarget) keepAlive(ctx context.Context, homehub client.Client) {
+	ticker := time.NewTicker(targetKeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := homehub.GetValues(ctx, client.UpTime); err != nil && ctx.Err() == nil {
+				slog.Warn("Unable to keep target session alive", "target", t.name, "err", err)
+			}
+		}
+	}
+}
+
+// warm logs in to the target in the background, so that the first probe does not have to wait for it
+func (t *probeTarget) warm() {
+	if _, err := t.get(context.Background()); err != nil {
+		slog.Warn("Unable to log in to target", "target", t.name, "err", err)
+	}
+}
+
+// stop cancels any background polling of the target. The target cannot be used afterwards
+func (t *probeTarget) stop() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.stopped = true
+	if t.cancel != nil {
+		t.cancel()
+	}
+}
+
+func (a *app) target(name string) (*probeTarget, *config.Config) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.targets[name], a.config
+}
+
+// probeHandler scrapes the Home Hub named by the target query parameter
+func (a *app) probeHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("target")
+	if name == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	target, cfg := a.target(name)
+	if target == nil {
+		http.Error(w, fmt.Sprintf("unknown target %q", name), http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	registerer := prometheus.WrapRegistererWith(cfg.Labels, registry)
+
+	e, err := target.get(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error probing target", "target", name, "err", err)
+		up := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: prometheus.BuildFQName("bt", "homehub", "up"),
+			Help: "Whether the router is up",
+		})
+		registerer.MustRegister(up)
+	} else if err := registerer.Register(e.WithContext(ctx)); err != nil {
+		slog.ErrorContext(ctx, "Error registering metrics", "target", name, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+)
+
+// runQuery reads the given XPaths from the Home Hub and writes the values to w as JSON
+func runQuery(ctx context.Context, homehub client.Client, xpaths []string, w io.Writer) error {
+	if len(xpaths) == 0 {
+		return errors.New("usage: homehub-metrics-exporter [flags] query XPATH...")
+	}
+
+	values, err := homehub.GetValues(ctx, xpaths...)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(values)
+}
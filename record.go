@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/cassette"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/client"
+	"github.com/jamesnetherton/homehub-metrics-exporter/pkg/exporter"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// record scrapes the Home Hub once and saves the requests and responses to a cassette file, with credentials
+// removed, so that they can be replayed as a regression test fixture
+func (a *app) record(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("record", flag.ContinueOnError)
+	hashMACAddresses := flags.Bool("hash-mac-addresses", false, "Replace device MAC addresses with a hash of the address")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("usage: homehub-metrics-exporter [flags] record [--hash-mac-addresses] FILE")
+	}
+
+	cfg, err := a.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	var recorderOptions []cassette.RecorderOption
+	if *hashMACAddresses {
+		recorderOptions = append(recorderOptions, cassette.WithHashedMACAddresses())
+	}
+
+	var recorder *cassette.Recorder
+	options := append([]client.Option{}, a.clientOptions...)
+	options = append(options, client.WithTransportWrapper(func(next http.RoundTripper) http.RoundTripper {
+		recorder = cassette.NewRecorder(next, recorderOptions...)
+		return recorder
+	}))
+
+	homehub, err := newHubClient(cfg.Hub, options...)
+	if err != nil {
+		return err
+	}
+
+	if response := homehub.Login(ctx); response.Error != nil {
+		return fmt.Errorf("Home Hub login failed: %s", response.Error)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter.New(homehub,
+		exporter.WithCustomMetrics(cfg.Metrics),
+		exporter.WithCollectors(enabledCollectors(cfg)...),
+	))
+
+	if _, err := registry.Gather(); err != nil {
+		return err
+	}
+
+	cassetteFile := flags.Arg(0)
+	recorded := recorder.Cassette()
+	if err := recorded.Save(cassetteFile); err != nil {
+		return err
+	}
+
+	slog.Info("Recorded Home Hub traffic", "file", cassetteFile, "interactions", len(recorded.Interactions))
+	return nil
+}